@@ -2,13 +2,17 @@ package main
 
 import (
 	"context"
+	"crypto/ed25519"
+	"encoding/base64"
 	"errors"
 	"flag"
+	"fmt"
 	"io"
 	"log"
 	"math/rand"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
@@ -23,6 +27,8 @@ const (
 	reconnectInitialBackoff = 1 * time.Second
 	reconnectMaxBackoff     = 60 * time.Second
 	reconnectJitterFraction = 0.2
+
+	enrollInitialPoll = 5 * time.Second
 )
 
 type serviceClient interface {
@@ -30,12 +36,22 @@ type serviceClient interface {
 	CloseTerminalSessions(ctx context.Context) error
 }
 
-var defaultNewServiceClient = func(cfg *agentconfig.Config, keyPair agentcrypto.KeyPair, logger *log.Logger, onDeviceToken func(string)) serviceClient {
-	return ws.NewClient(cfg, keyPair, logger, onDeviceToken)
+var defaultNewServiceClient = func(cfg *agentconfig.Config, keys *agentcrypto.SharedKeyStore, logger *log.Logger, onDeviceToken func(string)) (serviceClient, error) {
+	return ws.NewClient(cfg, keys, logger, onDeviceToken)
 }
 
 var newServiceClient = defaultNewServiceClient
 
+type enrollClient interface {
+	Enroll(ctx context.Context) error
+}
+
+var defaultNewEnrollClient = func(cfg *agentconfig.Config, keys *agentcrypto.SharedKeyStore, logger *log.Logger, onDeviceToken func(string)) (enrollClient, error) {
+	return ws.NewClient(cfg, keys, logger, onDeviceToken)
+}
+
+var newEnrollClient = defaultNewEnrollClient
+
 func main() {
 	if err := run(context.Background(), os.Args[1:], os.Stdout, os.Stderr); err != nil {
 		log.New(os.Stderr, "", log.LstdFlags).Println(err)
@@ -44,10 +60,130 @@ func main() {
 }
 
 func run(parent context.Context, args []string, stdout, stderr io.Writer) error {
+	if len(args) > 0 && args[0] == "enroll" {
+		return runEnroll(parent, args[1:], stdout, stderr)
+	}
+
 	flagSet := flag.NewFlagSet("agent", flag.ContinueOnError)
 	flagSet.SetOutput(stderr)
 
 	configPath := flagSet.String("config", defaultConfigPath, "path to config file")
+	rotateKeys := flagSet.Bool("rotate-keys", false, "generate a new device key and rotate to it on the next connect")
+	rollbackKeys := flagSet.Bool("rollback-keys", false, "restore the device key retired by the last rotation")
+	importKey := flagSet.String("import-key", "", "import a private key (raw, PKCS#8 PEM or OpenSSH) into keys.dir and exit")
+	exportPublicKey := flagSet.String("export-public-key", "", "print the device public key as pem, openssh or raw (base64) and exit")
+	if err := flagSet.Parse(args); err != nil {
+		return err
+	}
+
+	logger := log.New(stdout, "", log.LstdFlags)
+
+	cfg, err := agentconfig.Load(*configPath)
+	if err != nil {
+		return err
+	}
+
+	rotationSupported := agentcrypto.SupportsRotation(cfg.Keys.Backend)
+	if (*rotateKeys || *rollbackKeys || *importKey != "") && !rotationSupported {
+		return fmt.Errorf("-rotate-keys, -rollback-keys and -import-key require keys.backend=file (got %q)", cfg.Keys.Backend)
+	}
+
+	if *importKey != "" {
+		imported, err := agentcrypto.ImportKeyPair(cfg.Keys.Dir, *importKey)
+		if err != nil {
+			return err
+		}
+		logger.Printf("[agent] imported device key: deviceId=%s", agentcrypto.DeviceID(imported.Public))
+		return nil
+	}
+
+	if *rollbackKeys {
+		restored, err := agentcrypto.RejectRotation(cfg.Keys.Dir, 0)
+		if err != nil {
+			return err
+		}
+		if !restored {
+			return errors.New("no previous device key to roll back to")
+		}
+		logger.Printf("[agent] restored previous device key")
+	}
+
+	keys, err := agentcrypto.OpenKeyStore(keyStoreOptions(&cfg))
+	if err != nil {
+		return err
+	}
+	if closer, ok := keys.(io.Closer); ok {
+		defer closer.Close()
+	}
+	if *exportPublicKey != "" {
+		return writePublicKey(stdout, keys.PublicKey(), *exportPublicKey)
+	}
+	if rotationSupported {
+		if err := agentcrypto.PruneRotation(cfg.Keys.Dir, cfg.RollbackWindow()); err != nil {
+			logger.Printf("[agent] prune previous key error: %v", err)
+		}
+	}
+	if *rotateKeys {
+		next, err := agentcrypto.StageRotation(cfg.Keys.Dir)
+		if err != nil {
+			return err
+		}
+		logger.Printf("[agent] staged key rotation: deviceId=%s (pending server confirmation)", agentcrypto.DeviceID(next.Public))
+	}
+
+	if err := restoreDeviceToken(&cfg, keys, logger); err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(parent, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	return serveWithReconnect(ctx, &cfg, agentcrypto.NewSharedKeyStore(keys), logger)
+}
+
+func keyStoreOptions(cfg *agentconfig.Config) agentcrypto.KeyStoreOptions {
+	return agentcrypto.KeyStoreOptions{
+		Backend: cfg.Keys.Backend,
+		Dir:     cfg.Keys.Dir,
+		Env:     cfg.Keys.Env,
+		FD:      cfg.Keys.FD,
+		Command: cfg.Keys.Command,
+		// 非正数由 crypto 包使用默认超时。
+		CommandTimeout: time.Duration(cfg.Keys.CommandTimeoutSeconds) * time.Second,
+	}
+}
+
+// writePublicKey 按指定格式输出设备公钥，便于在服务端或 secrets 工具中登记。
+func writePublicKey(w io.Writer, pub ed25519.PublicKey, format string) error {
+	var out []byte
+	switch format {
+	case "pem":
+		data, err := agentcrypto.MarshalPublicKeyPEM(pub)
+		if err != nil {
+			return err
+		}
+		out = data
+	case "openssh":
+		out = agentcrypto.MarshalAuthorizedKey(pub, "devops-agent-"+agentcrypto.DeviceID(pub))
+	case "raw":
+		out = []byte(base64.StdEncoding.EncodeToString(pub) + "\n")
+	default:
+		return fmt.Errorf("unknown public key format %q (want pem, openssh or raw)", format)
+	}
+	_, err := w.Write(out)
+	return err
+}
+
+// runEnroll 实现 `agent enroll`：携带一次性注册令牌与主机信息注册本机，
+// 待审批时按退避轮询，获得 deviceToken 并持久化后退出。
+func runEnroll(parent context.Context, args []string, stdout, stderr io.Writer) error {
+	flagSet := flag.NewFlagSet("agent enroll", flag.ContinueOnError)
+	flagSet.SetOutput(stderr)
+
+	configPath := flagSet.String("config", defaultConfigPath, "path to config file")
+	token := flagSet.String("token", "", "one-time enrollment token (defaults to auth.enrollmentToken)")
+	timeout := flagSet.Duration("timeout", 0, "stop waiting for approval after this long (0 waits indefinitely)")
+	force := flagSet.Bool("force", false, "enroll even if a device token is already stored")
 	if err := flagSet.Parse(args); err != nil {
 		return err
 	}
@@ -58,28 +194,109 @@ func run(parent context.Context, args []string, stdout, stderr io.Writer) error
 	if err != nil {
 		return err
 	}
+	if *token != "" {
+		cfg.Auth.EnrollmentToken = *token
+	}
+	if strings.TrimSpace(cfg.Auth.EnrollmentToken) == "" {
+		return errors.New("no enrollment token: pass -token or set auth.enrollmentToken")
+	}
+	if strings.TrimSpace(cfg.Auth.DeviceTokenPath) == "" {
+		return errors.New("auth.deviceTokenPath must be set to store the issued device token")
+	}
 
-	keyPair, err := agentcrypto.EnsureKeyPair(cfg.Keys.Dir)
+	keys, err := agentcrypto.OpenKeyStore(keyStoreOptions(&cfg))
 	if err != nil {
 		return err
 	}
+	if closer, ok := keys.(io.Closer); ok {
+		defer closer.Close()
+	}
+	if err := restoreDeviceToken(&cfg, keys, logger); err != nil {
+		return err
+	}
+	if cfg.Auth.DeviceToken != "" && !*force {
+		logger.Printf("[agent] already enrolled (device token present at %s); use -force to enroll again", cfg.Auth.DeviceTokenPath)
+		return nil
+	}
 
 	ctx, stop := signal.NotifyContext(parent, os.Interrupt, syscall.SIGTERM)
 	defer stop()
+	if *timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *timeout)
+		defer cancel()
+	}
 
-	return serveWithReconnect(ctx, &cfg, keyPair, logger)
+	return enrollWithBackoff(ctx, &cfg, agentcrypto.NewSharedKeyStore(keys), logger)
 }
 
-func serveWithReconnect(ctx context.Context, cfg *agentconfig.Config, keyPair agentcrypto.KeyPair, logger *log.Logger) error {
+// enrollWithBackoff 重复注册直至获批：待审批与连接错误按退避重试（优先采用服务端建议的间隔），
+// 注册令牌被拒绝时立即返回，避免反复冲击网关。
+func enrollWithBackoff(ctx context.Context, cfg *agentconfig.Config, keys *agentcrypto.SharedKeyStore, logger *log.Logger) error {
+	poll := enrollInitialPoll
+
+	for {
+		client, err := newEnrollClient(cfg, keys, logger, newDeviceTokenHandler(cfg.Auth.DeviceTokenPath, cfg, logger))
+		if err != nil {
+			return err
+		}
+		err = client.Enroll(ctx)
+		if err == nil {
+			logger.Printf("[agent] enrolled: deviceId=%s", agentcrypto.DeviceID(keys.Load().PublicKey()))
+			return nil
+		}
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return fmt.Errorf("enrollment not completed: %w", ctxErr)
+		}
+		if errors.Is(err, ws.ErrAuthRejected) {
+			return fmt.Errorf("enrollment token rejected: %w", err)
+		}
+
+		wait := pendingApprovalDelay(err, poll)
+		if errors.Is(err, ws.ErrPendingApproval) {
+			logger.Printf("[agent] enrollment pending approval; checking again in %s", wait)
+		} else {
+			logger.Printf("[agent] enrollment attempt failed: %v; retrying in %s", err, wait)
+		}
+		if sleepErr := sleepWithContext(ctx, wait); sleepErr != nil {
+			return fmt.Errorf("enrollment not completed: %w", sleepErr)
+		}
+		poll = nextPoll(poll, cfg.EnrollPollMax())
+	}
+}
+
+// pendingApprovalDelay 返回下一次轮询前的等待时间：服务端给出 RetryAfter 时以其为下限。
+func pendingApprovalDelay(err error, poll time.Duration) time.Duration {
+	wait := withJitter(poll)
+	var pending *ws.PendingApprovalError
+	if errors.As(err, &pending) && pending.RetryAfter > wait {
+		wait = pending.RetryAfter
+	}
+	return wait
+}
+
+func nextPoll(poll, max time.Duration) time.Duration {
+	poll *= 2
+	if poll > max {
+		poll = max
+	}
+	return poll
+}
+
+func serveWithReconnect(ctx context.Context, cfg *agentconfig.Config, keys *agentcrypto.SharedKeyStore, logger *log.Logger) error {
 	backoff := reconnectInitialBackoff
+	pendingPoll := enrollInitialPoll
 
 	for {
 		if err := ctx.Err(); err != nil {
 			return err
 		}
 
-		client := newServiceClient(cfg, keyPair, logger, newDeviceTokenHandler(cfg.Auth.DeviceTokenPath, cfg, logger))
-		err := client.ConnectAndServe(ctx)
+		client, err := newServiceClient(cfg, keys, logger, newDeviceTokenHandler(cfg.Auth.DeviceTokenPath, cfg, logger))
+		if err != nil {
+			return err
+		}
+		err = client.ConnectAndServe(ctx)
 		if closeErr := client.CloseTerminalSessions(context.Background()); closeErr != nil {
 			logger.Printf("[agent] close terminal sessions error: %v", closeErr)
 		}
@@ -88,6 +305,29 @@ func serveWithReconnect(ctx context.Context, cfg *agentconfig.Config, keyPair ag
 			return nil
 		}
 
+		if errors.Is(err, ws.ErrRotationRejected) {
+			logger.Printf("[agent] key rotation rejected by server: %v", err)
+			if rejectErr := rejectKeyRotation(cfg, keys); rejectErr != nil {
+				return rejectErr
+			}
+			backoff = reconnectInitialBackoff
+			continue
+		}
+
+		if errors.Is(err, ws.ErrPendingApproval) {
+			wait := pendingApprovalDelay(err, pendingPoll)
+			logger.Printf("[agent] enrollment pending approval; checking again in %s", wait)
+			if sleepErr := sleepWithContext(ctx, wait); sleepErr != nil {
+				return sleepErr
+			}
+			pendingPoll = nextPoll(pendingPoll, cfg.EnrollPollMax())
+			continue
+		}
+
+		if errors.Is(err, ws.ErrAuthRejected) && cfg.Enrolling() {
+			return fmt.Errorf("enrollment token rejected: %w", err)
+		}
+
 		if errors.Is(err, ws.ErrAuthRejected) && cfg.Auth.DeviceToken != "" {
 			logger.Printf("[agent] device token rejected by server, falling back to static auth token: %v", err)
 			if clearErr := agentconfig.ClearDeviceToken(cfg.Auth.DeviceTokenPath); clearErr != nil {
@@ -134,12 +374,63 @@ func sleepWithContext(ctx context.Context, d time.Duration) error {
 	}
 }
 
+// restoreDeviceToken 派生 deviceToken 加密密钥并加载已持久化的 token；
+// 文件损坏或密钥不匹配时丢弃该 token，回退到静态 authToken 重新获取。
+func restoreDeviceToken(cfg *agentconfig.Config, keys agentcrypto.KeyStore, logger *log.Logger) error {
+	var (
+		key []byte
+		err error
+	)
+	if path := strings.TrimSpace(cfg.Auth.TokenKeyFile); path != "" {
+		key, err = agentcrypto.LoadTokenSecret(path)
+	} else {
+		key, err = agentcrypto.DeriveTokenKey(keys)
+	}
+	if err != nil {
+		return fmt.Errorf("derive device token key: %w", err)
+	}
+	cfg.Auth.TokenKey = key
+
+	err = cfg.RestoreDeviceToken()
+	if errors.Is(err, agentconfig.ErrDeviceTokenCorrupt) {
+		logger.Printf("[agent] discard unreadable device token: %v", err)
+		return agentconfig.ClearDeviceToken(cfg.Auth.DeviceTokenPath)
+	}
+	return err
+}
+
+// rejectKeyRotation 丢弃未确认的新密钥，或在回滚窗口内恢复已提交轮换前的旧密钥。
+func rejectKeyRotation(cfg *agentconfig.Config, keys *agentcrypto.SharedKeyStore) error {
+	restored, err := agentcrypto.RejectRotation(cfg.Keys.Dir, cfg.RollbackWindow())
+	if err != nil || !restored {
+		return err
+	}
+
+	kp, err := agentcrypto.EnsureKeyPair(cfg.Keys.Dir)
+	if err != nil {
+		return err
+	}
+	keys.Replace(kp)
+	if strings.TrimSpace(cfg.Auth.TokenKeyFile) != "" {
+		return nil
+	}
+	key, err := agentcrypto.DeriveTokenKey(kp)
+	if err != nil {
+		return fmt.Errorf("derive device token key: %w", err)
+	}
+	return cfg.RekeyDeviceToken(key)
+}
+
 func newDeviceTokenHandler(path string, cfg *agentconfig.Config, logger *log.Logger) func(string) {
 	return func(token string) {
 		if cfg != nil {
 			cfg.UpdateDeviceToken(token)
 		}
-		if err := agentconfig.SaveDeviceToken(path, token); err != nil && logger != nil {
+		var key []byte
+		if cfg != nil {
+			key = cfg.Auth.TokenKey
+		}
+		if err := agentconfig.SaveDeviceToken(path, token, key); err != nil && logger != nil {
 			logger.Printf("[config] save device token error: %v", err)
 		}
 	}
@@ -8,9 +8,11 @@ import (
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 
 	agentconfig "devops-agent/internal/config"
 	agentcrypto "devops-agent/internal/crypto"
+	"devops-agent/internal/ws"
 )
 
 func TestDeviceTokenHandlerPersistsToken(t *testing.T) {
@@ -38,12 +40,12 @@ func TestServeWithReconnectClosesTerminalSessionsOnShutdown(t *testing.T) {
 	t.Cleanup(resetClientFactory)
 
 	stub := &stubServiceClient{connectErr: context.Canceled}
-	newServiceClient = func(*agentconfig.Config, agentcrypto.KeyPair, *log.Logger, func(string)) serviceClient {
-		return stub
+	newServiceClient = func(*agentconfig.Config, *agentcrypto.SharedKeyStore, *log.Logger, func(string)) (serviceClient, error) {
+		return stub, nil
 	}
 
 	cfg := &agentconfig.Config{}
-	err := serveWithReconnect(context.Background(), cfg, agentcrypto.KeyPair{}, log.New(&bytes.Buffer{}, "", 0))
+	err := serveWithReconnect(context.Background(), cfg, new(agentcrypto.SharedKeyStore), log.New(&bytes.Buffer{}, "", 0))
 	if err != nil {
 		t.Fatalf("serveWithReconnect() error = %v, want nil", err)
 	}
@@ -63,12 +65,12 @@ func TestServeWithReconnectStillClosesTerminalSessionsBeforeReconnect(t *testing
 
 	stub := &stubServiceClient{connectErr: errors.New("boom")}
 	ctx, cancel := context.WithCancel(context.Background())
-	newServiceClient = func(*agentconfig.Config, agentcrypto.KeyPair, *log.Logger, func(string)) serviceClient {
+	newServiceClient = func(*agentconfig.Config, *agentcrypto.SharedKeyStore, *log.Logger, func(string)) (serviceClient, error) {
 		cancel()
-		return stub
+		return stub, nil
 	}
 
-	err := serveWithReconnect(ctx, &agentconfig.Config{}, agentcrypto.KeyPair{}, log.New(&bytes.Buffer{}, "", 0))
+	err := serveWithReconnect(ctx, &agentconfig.Config{}, new(agentcrypto.SharedKeyStore), log.New(&bytes.Buffer{}, "", 0))
 	if !errors.Is(err, context.Canceled) {
 		t.Fatalf("serveWithReconnect() error = %v, want %v", err, context.Canceled)
 	}
@@ -77,6 +79,63 @@ func TestServeWithReconnectStillClosesTerminalSessionsBeforeReconnect(t *testing
 	}
 }
 
+func TestEnrollWithBackoffStopsOnRejectedToken(t *testing.T) {
+	t.Cleanup(resetClientFactory)
+
+	stub := &stubEnrollClient{errs: []error{ws.ErrAuthRejected}}
+	newEnrollClient = func(*agentconfig.Config, *agentcrypto.SharedKeyStore, *log.Logger, func(string)) (enrollClient, error) {
+		return stub, nil
+	}
+
+	keys := agentcrypto.NewSharedKeyStore(agentcrypto.KeyPair{})
+	err := enrollWithBackoff(context.Background(), &agentconfig.Config{}, keys, log.New(&bytes.Buffer{}, "", 0))
+	if !errors.Is(err, ws.ErrAuthRejected) {
+		t.Fatalf("enrollWithBackoff() error = %v, want %v", err, ws.ErrAuthRejected)
+	}
+	if stub.calls != 1 {
+		t.Fatalf("enroll calls = %d, want 1", stub.calls)
+	}
+}
+
+func TestEnrollWithBackoffWaitsWhilePending(t *testing.T) {
+	t.Cleanup(resetClientFactory)
+
+	pending := &ws.PendingApprovalError{Code: "ENROLLMENT_PENDING", RetryAfter: time.Hour}
+	stub := &stubEnrollClient{errs: []error{pending}}
+	newEnrollClient = func(*agentconfig.Config, *agentcrypto.SharedKeyStore, *log.Logger, func(string)) (enrollClient, error) {
+		return stub, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	keys := agentcrypto.NewSharedKeyStore(agentcrypto.KeyPair{})
+	err := enrollWithBackoff(ctx, &agentconfig.Config{}, keys, log.New(&bytes.Buffer{}, "", 0))
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("enrollWithBackoff() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+	if stub.calls != 1 {
+		t.Fatalf("enroll calls = %d, want 1 (pending must not be retried immediately)", stub.calls)
+	}
+	if got := pendingApprovalDelay(pending, time.Second); got != time.Hour {
+		t.Fatalf("pendingApprovalDelay() = %s, want server RetryAfter 1h", got)
+	}
+}
+
+type stubEnrollClient struct {
+	errs  []error
+	calls int
+}
+
+func (s *stubEnrollClient) Enroll(context.Context) error {
+	s.calls++
+	if len(s.errs) == 0 {
+		return nil
+	}
+	err := s.errs[0]
+	s.errs = s.errs[1:]
+	return err
+}
+
 type stubServiceClient struct {
 	connectErr  error
 	closeErr    error
@@ -98,4 +157,31 @@ func (s *stubServiceClient) CloseTerminalSessions(context.Context) error {
 
 func resetClientFactory() {
 	newServiceClient = defaultNewServiceClient
+	newEnrollClient = defaultNewEnrollClient
+}
+
+func TestRestoreDeviceTokenDiscardsUnreadableToken(t *testing.T) {
+	tokenPath := filepath.Join(t.TempDir(), "device.token")
+	if err := os.WriteFile(tokenPath, []byte("enc:v1:AAAA\n"), 0o600); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+
+	cfg := &agentconfig.Config{
+		Keys: agentconfig.KeysConfig{Dir: t.TempDir()},
+		Auth: agentconfig.AuthConfig{DeviceTokenPath: tokenPath},
+	}
+	keyPair, err := agentcrypto.EnsureKeyPair(cfg.Keys.Dir)
+	if err != nil {
+		t.Fatalf("EnsureKeyPair() error = %v", err)
+	}
+
+	if err := restoreDeviceToken(cfg, keyPair, log.New(&bytes.Buffer{}, "", 0)); err != nil {
+		t.Fatalf("restoreDeviceToken() error = %v", err)
+	}
+	if cfg.Auth.DeviceToken != "" {
+		t.Fatalf("DeviceToken = %q, want empty", cfg.Auth.DeviceToken)
+	}
+	if _, err := os.Stat(tokenPath); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("token file still present: err=%v", err)
+	}
 }
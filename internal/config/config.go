@@ -6,17 +6,30 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/ilyakaznacheev/cleanenv"
+
+	"devops-agent/internal/proc"
 )
 
 type Config struct {
-	Server    ServerConfig    `yaml:"server"`
-	Keys      KeysConfig      `yaml:"keys"`
-	Auth      AuthConfig      `yaml:"auth"`
-	Heartbeat HeartbeatConfig `yaml:"heartbeat"`
-	Shell     ShellConfig     `yaml:"shell"`
-	Logging   LoggingConfig   `yaml:"logging"`
+	Server      ServerConfig      `yaml:"server"`
+	Keys        KeysConfig        `yaml:"keys"`
+	Auth        AuthConfig        `yaml:"auth"`
+	Gateway     GatewayConfig     `yaml:"gateway"`
+	Operators   OperatorsConfig   `yaml:"operators"`
+	Policy      PolicyConfig      `yaml:"policy"`
+	Replay      ReplayConfig      `yaml:"replay"`
+	Heartbeat   HeartbeatConfig   `yaml:"heartbeat"`
+	Shell       ShellConfig       `yaml:"shell"`
+	Env         EnvConfig         `yaml:"env"`
+	RunAs       RunAsConfig       `yaml:"runAs"`
+	Limits      LimitsConfig      `yaml:"limits"`
+	Output      OutputConfig      `yaml:"output"`
+	Termination TerminationConfig `yaml:"termination"`
+	Redact      RedactConfig      `yaml:"redact"`
+	Logging     LoggingConfig     `yaml:"logging"`
 }
 
 type ServerConfig struct {
@@ -24,29 +37,182 @@ type ServerConfig struct {
 }
 
 type KeysConfig struct {
-	Dir string `yaml:"dir" env:"AGENT_KEYS__DIR" env-default:"./keys"`
+	// Backend 为设备密钥来源：file（keys.dir 下的密钥文件，默认）/ env / fd / command。
+	Backend string `yaml:"backend" env:"AGENT_KEYS__BACKEND" env-default:"file"`
+	Dir     string `yaml:"dir" env:"AGENT_KEYS__DIR" env-default:"./keys"`
+	// Env 为 env 后端读取私钥（PEM / OpenSSH / Base64 原始格式）的环境变量名。
+	Env string `yaml:"env" env:"AGENT_KEYS__ENV" env-default:"AGENT_DEVICE_PRIVATE_KEY"`
+	// FD 为 fd 后端读取私钥的文件描述符。
+	FD int `yaml:"fd" env:"AGENT_KEYS__FD" env-default:"3"`
+	// Command 为 command 后端的外部签名进程（JSON Lines over stdin/stdout）。
+	Command []string `yaml:"command" env:"AGENT_KEYS__COMMAND" env-separator:" "`
+	// CommandTimeoutSeconds 为 command 后端单次请求的超时，超时后外部进程被杀死、下一次请求重新拉起。
+	CommandTimeoutSeconds int `yaml:"commandTimeoutSeconds" env:"AGENT_KEYS__COMMAND_TIMEOUT_SECONDS" env-default:"10"`
+	// RollbackWindowHours 为密钥轮换提交后保留旧密钥的时长，窗口内服务端拒绝轮换时可恢复旧密钥。
+	RollbackWindowHours int `yaml:"rollbackWindowHours" env:"AGENT_KEYS__ROLLBACK_WINDOW_HOURS" env-default:"168"`
 }
 
 type AuthConfig struct {
 	Token           string `yaml:"token" env:"AGENT_AUTH__TOKEN"`
 	DeviceTokenPath string `yaml:"deviceTokenPath" env:"AGENT_AUTH__DEVICE_TOKEN_PATH"`
-	DeviceToken     string `yaml:"-" env:"-"` // 运行时单独从 DeviceTokenPath 加载
+	// EnrollmentToken 为首次注册使用的一次性令牌（`agent enroll` 或无其他 token 时自动注册）。
+	EnrollmentToken string `yaml:"enrollmentToken" env:"AGENT_AUTH__ENROLLMENT_TOKEN"`
+	// EnrollPollMaxSeconds 为注册待审批时轮询间隔的上限。
+	EnrollPollMaxSeconds int `yaml:"enrollPollMaxSeconds" env:"AGENT_AUTH__ENROLL_POLL_MAX_SECONDS" env-default:"300"`
+	// RefreshBeforeSeconds 为 deviceToken 到期前主动续期的提前量，实际续期时间会再随机提前至多一半，
+	// 避免整个集群在同一时刻续期。
+	RefreshBeforeSeconds int `yaml:"refreshBeforeSeconds" env:"AGENT_AUTH__REFRESH_BEFORE_SECONDS" env-default:"3600"`
+
+	// TokenKeyFile 为加密 deviceToken 的独立本地密钥文件（不存在时自动生成）；
+	// 为空时由设备 Ed25519 私钥派生加密密钥。
+	TokenKeyFile string `yaml:"tokenKeyFile" env:"AGENT_AUTH__TOKEN_KEY_FILE"`
+
+	DeviceToken          string `yaml:"-" env:"-"` // 运行时由 RestoreDeviceToken 从 DeviceTokenPath 加载
+	DeviceTokenExpiresAt int64  `yaml:"-" env:"-"` // 运行时由 hello-ok / auth.token.rotate 告知，毫秒时间戳
+	TokenKey             []byte `yaml:"-" env:"-"` // 运行时派生的 deviceToken 加密密钥
+}
+
+// GatewayConfig 控制网关身份校验。
+//
+// PublicKey 为预置的网关 Ed25519 公钥（Base64）；为空时从 KeyPath 读取首次信任（TOFU）后固定的公钥，
+// KeyPath 为空时默认为 keys.dir/gateway_ed25519.pub。
+type GatewayConfig struct {
+	PublicKey           string `yaml:"publicKey" env:"AGENT_GATEWAY__PUBLIC_KEY"`
+	KeyPath             string `yaml:"keyPath" env:"AGENT_GATEWAY__KEY_PATH"`
+	DisableTOFU         bool   `yaml:"disableTofu" env:"AGENT_GATEWAY__DISABLE_TOFU"`
+	AllowUnsignedFrames bool   `yaml:"allowUnsignedFrames" env:"AGENT_GATEWAY__ALLOW_UNSIGNED_FRAMES"`
+}
+
+// OperatorsConfig 控制运维人员签名校验。
+//
+// File 为运维人员公钥白名单，默认 keys.dir/operators.yaml；
+// HighRiskPatterns 为空时使用内置高危命令模式；Required=true 时所有命令都必须携带运维人员签名。
+type OperatorsConfig struct {
+	File             string   `yaml:"file" env:"AGENT_OPERATORS__FILE"`
+	Required         bool     `yaml:"required" env:"AGENT_OPERATORS__REQUIRED"`
+	HighRiskPatterns []string `yaml:"highRiskPatterns" env:"AGENT_OPERATORS__HIGH_RISK_PATTERNS"`
+}
+
+// ReplayConfig 控制 command.push 的抗重放校验。
+//
+// CachePath 为已见 nonce 的持久化路径，默认 keys.dir/replay_nonces.json；
+// AllowMissingFields=true 时放行未携带 issuedAt/expiresAt/nonce 的旧版推送（仅用于过渡）。
+type ReplayConfig struct {
+	CachePath          string `yaml:"cachePath" env:"AGENT_REPLAY__CACHE_PATH"`
+	CacheSize          int    `yaml:"cacheSize" env:"AGENT_REPLAY__CACHE_SIZE" env-default:"4096"`
+	MaxSkewSeconds     int    `yaml:"maxSkewSeconds" env:"AGENT_REPLAY__MAX_SKEW_SECONDS" env-default:"300"`
+	MaxAgeSeconds      int    `yaml:"maxAgeSeconds" env:"AGENT_REPLAY__MAX_AGE_SECONDS" env-default:"600"`
+	AllowMissingFields bool   `yaml:"allowMissingFields" env:"AGENT_REPLAY__ALLOW_MISSING_FIELDS"`
+}
+
+// PolicyConfig 控制本地命令策略（允许 / 拒绝 / 需审批规则）。
+//
+// File 为策略文件路径，默认 keys.dir/policy.yaml。
+type PolicyConfig struct {
+	File string `yaml:"file" env:"AGENT_POLICY__FILE"`
 }
 
 type HeartbeatConfig struct {
 	TickIntervalMs int `yaml:"tickIntervalMs" env:"AGENT_HEARTBEAT__TICK_INTERVAL_MS" env-default:"15000"`
 }
 
+// EnvConfig 为命令执行与终端会话共用的子进程环境策略。AGENT_* 变量始终被清除，不受此处配置影响。
+type EnvConfig struct {
+	// Inherit 为允许从 Agent 进程继承的变量名模式（支持 "LC_*"），设为 ["*"] 继承全部。
+	Inherit []string `yaml:"inherit" env:"AGENT_ENV__INHERIT" env-default:"PATH,HOME,USER,LOGNAME,SHELL,LANG,LANGUAGE,LC_*,TERM,TZ,TMPDIR"`
+	// Deny 为禁止出现在子进程中的变量名模式，任务下发这些变量时直接拒绝。
+	Deny []string `yaml:"deny" env:"AGENT_ENV__DENY" env-default:"LD_PRELOAD,LD_LIBRARY_PATH,LD_AUDIT,BASH_ENV,ENV,PROMPT_COMMAND"`
+}
+
+// EnvPolicy 返回子进程环境策略。
+func (c Config) EnvPolicy() proc.EnvPolicy {
+	return proc.EnvPolicy{Inherit: c.Env.Inherit, Deny: c.Env.Deny}
+}
+
+// RunAsConfig 为 server 可请求的运行身份白名单（名称或数字 ID，支持 "svc-*" 模式）。
+// AllowedUsers 为空时拒绝所有 runAs 请求；用户自身的主组与附加组无需列入 AllowedGroups。
+type RunAsConfig struct {
+	AllowedUsers  []string `yaml:"allowedUsers" env:"AGENT_RUN_AS__ALLOWED_USERS"`
+	AllowedGroups []string `yaml:"allowedGroups" env:"AGENT_RUN_AS__ALLOWED_GROUPS"`
+}
+
+// UserPolicy 返回运行身份白名单策略。
+func (c Config) UserPolicy() proc.UserPolicy {
+	return proc.UserPolicy{AllowedUsers: c.RunAs.AllowedUsers, AllowedGroups: c.RunAs.AllowedGroups}
+}
+
+// LimitsConfig 为命令执行的默认资源限制，0 表示不限制；command.push 可逐项覆盖。
+// 内存 / CPU 配额 / 进程数通过 CgroupRoot 下的临时 cgroup v2 施加，cgroup 不可用时内存退化为 RLIMIT_AS。
+type LimitsConfig struct {
+	CgroupRoot string  `yaml:"cgroupRoot" env:"AGENT_LIMITS__CGROUP_ROOT" env-default:"/sys/fs/cgroup/devops-agent"`
+	MemoryMB   int64   `yaml:"memoryMb" env:"AGENT_LIMITS__MEMORY_MB"`
+	CPUQuota   float64 `yaml:"cpuQuota" env:"AGENT_LIMITS__CPU_QUOTA"`
+	Pids       int64   `yaml:"pids" env:"AGENT_LIMITS__PIDS"`
+	NoFile     uint64  `yaml:"noFile" env:"AGENT_LIMITS__NO_FILE"`
+	CPUSeconds uint64  `yaml:"cpuSeconds" env:"AGENT_LIMITS__CPU_SECONDS"`
+}
+
+// ResourceLimits 返回默认资源限制。
+func (c Config) ResourceLimits() proc.Limits {
+	return proc.Limits{
+		MemoryBytes: c.Limits.MemoryMB << 20,
+		CPUQuota:    c.Limits.CPUQuota,
+		Pids:        c.Limits.Pids,
+		NoFile:      c.Limits.NoFile,
+		CPUSeconds:  c.Limits.CPUSeconds,
+	}
+}
+
+// OutputConfig 为命令输出上限（字节），0 表示不限制；command.push 可逐项覆盖。
+// 超出上限时保留各流头部与末尾 TailBytes 字节（至多为上限的一半），丢弃中间部分并回传截断标记。
+type OutputConfig struct {
+	MaxStdoutBytes int64 `yaml:"maxStdoutBytes" env:"AGENT_OUTPUT__MAX_STDOUT_BYTES" env-default:"10485760"`
+	MaxStderrBytes int64 `yaml:"maxStderrBytes" env:"AGENT_OUTPUT__MAX_STDERR_BYTES" env-default:"10485760"`
+	TailBytes      int64 `yaml:"tailBytes" env:"AGENT_OUTPUT__TAIL_BYTES" env-default:"65536"`
+	// FlushIntervalMs / MinChunkBytes 控制输出分片合并：缓冲达到 MinChunkBytes 或首个未发送字节等待满
+	// FlushIntervalMs 毫秒时发送一个 result.chunk；两者均为 0 时每次读取立即发送。
+	FlushIntervalMs int `yaml:"flushIntervalMs" env:"AGENT_OUTPUT__FLUSH_INTERVAL_MS" env-default:"50"`
+	MinChunkBytes   int `yaml:"minChunkBytes" env:"AGENT_OUTPUT__MIN_CHUNK_BYTES" env-default:"4096"`
+}
+
+// TerminationConfig 为命令超时后的终止策略：先向进程组发送 Signal，GraceSeconds 秒后输出仍未结束
+// 再发送 SIGKILL；command.push 的 termination 可逐项覆盖。
+type TerminationConfig struct {
+	Signal       string `yaml:"signal" env:"AGENT_TERMINATION__SIGNAL" env-default:"SIGTERM"`
+	GraceSeconds int    `yaml:"graceSeconds" env:"AGENT_TERMINATION__GRACE_SECONDS" env-default:"5"`
+}
+
+// RedactConfig 为命令与终端输出的密钥遮蔽配置：Builtins 启用内置的常见令牌检测器，
+// Patterns 为附加正则（RE2，含捕获组时只遮蔽第一个捕获组）。Agent 自身的 auth.token / deviceToken
+// 与 server 通过 redaction.update 下发的值在启用时总会被遮蔽。
+type RedactConfig struct {
+	Enabled  bool     `yaml:"enabled" env:"AGENT_REDACT__ENABLED" env-default:"true"`
+	Builtins bool     `yaml:"builtins" env:"AGENT_REDACT__BUILTINS" env-default:"true"`
+	Patterns []string `yaml:"patterns"`
+}
+
 type ShellConfig struct {
 	Enabled bool   `yaml:"enabled" env:"AGENT_SHELL__ENABLED"`
 	WorkDir string `yaml:"workDir" env:"AGENT_SHELL__WORK_DIR"`
+	// ExecPath 为 argv 模式解析可执行文件的受控搜索路径（冒号分隔），与 Agent 进程自身的 PATH 无关。
+	ExecPath string `yaml:"execPath" env:"AGENT_SHELL__EXEC_PATH" env-default:"/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin"`
+	// Interpreters 为脚本任务允许的解释器（名称只在 ExecPath 中查找，或绝对路径）。
+	Interpreters []string `yaml:"interpreters" env:"AGENT_SHELL__INTERPRETERS" env-default:"sh,bash,python3"`
+	// StdinBufferBytes 为每个命令流式 stdin 的缓冲上限（字节），超出时 command.stdin.write 被拒绝，可稍后重发。
+	StdinBufferBytes int `yaml:"stdinBufferBytes" env:"AGENT_SHELL__STDIN_BUFFER_BYTES" env-default:"1048576"`
 }
 
 type LoggingConfig struct {
 	Level string `yaml:"level" env:"AGENT_LOGGING__LEVEL" env-default:"info"`
 }
 
-const defaultTickIntervalMs = 15000
+const (
+	defaultTickIntervalMs = 15000
+	defaultGatewayKeyFile = "gateway_ed25519.pub"
+	defaultOperatorsFile  = "operators.yaml"
+	defaultPolicyFile     = "policy.yaml"
+	defaultReplayCache    = "replay_nonces.json"
+)
 
 func Load(path string) (Config, error) {
 	var cfg Config
@@ -73,15 +239,32 @@ func Load(path string) (Config, error) {
 		}
 	}
 
-	if cfg.Auth.DeviceTokenPath != "" {
-		token, err := LoadDeviceToken(cfg.Auth.DeviceTokenPath)
-		if err != nil && !errors.Is(err, os.ErrNotExist) {
-			return cfg, fmt.Errorf("load device token: %w", err)
+	return cfg, nil
+}
+
+// RestoreDeviceToken 使用 Auth.TokenKey 从 DeviceTokenPath 加载 deviceToken。
+//
+// 需在设备密钥就绪、TokenKey 已设置后调用；若文件仍为旧版明文且已有 TokenKey，会透明地加密回写。
+// 文件解密或完整性校验失败时返回 ErrDeviceTokenCorrupt。
+func (c *Config) RestoreDeviceToken() error {
+	if strings.TrimSpace(c.Auth.DeviceTokenPath) == "" {
+		return nil
+	}
+	token, sealed, err := readDeviceToken(c.Auth.DeviceTokenPath, c.Auth.TokenKey)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
 		}
-		cfg.Auth.DeviceToken = token
+		return fmt.Errorf("load device token: %w", err)
 	}
+	c.Auth.DeviceToken = token
 
-	return cfg, nil
+	if !sealed && token != "" && len(c.Auth.TokenKey) > 0 {
+		if err := SaveDeviceToken(c.Auth.DeviceTokenPath, token, c.Auth.TokenKey); err != nil {
+			return fmt.Errorf("migrate plaintext device token: %w", err)
+		}
+	}
+	return nil
 }
 
 func (c Config) TickInterval() int {
@@ -91,6 +274,81 @@ func (c Config) TickInterval() int {
 	return c.Heartbeat.TickIntervalMs
 }
 
+// GatewayKeyPath 返回 TOFU 固定网关公钥的持久化路径。
+func (c Config) GatewayKeyPath() string {
+	if path := strings.TrimSpace(c.Gateway.KeyPath); path != "" {
+		return path
+	}
+	return filepath.Join(c.Keys.Dir, defaultGatewayKeyFile)
+}
+
+// OperatorsFile 返回运维人员公钥白名单路径。
+func (c Config) OperatorsFile() string {
+	if path := strings.TrimSpace(c.Operators.File); path != "" {
+		return path
+	}
+	return filepath.Join(c.Keys.Dir, defaultOperatorsFile)
+}
+
+// PolicyFile 返回命令策略文件路径。
+func (c Config) PolicyFile() string {
+	if path := strings.TrimSpace(c.Policy.File); path != "" {
+		return path
+	}
+	return filepath.Join(c.Keys.Dir, defaultPolicyFile)
+}
+
+// ReplayCachePath 返回已见 nonce 缓存的持久化路径。
+func (c Config) ReplayCachePath() string {
+	if path := strings.TrimSpace(c.Replay.CachePath); path != "" {
+		return path
+	}
+	return filepath.Join(c.Keys.Dir, defaultReplayCache)
+}
+
+// RollbackWindow 返回密钥轮换的回滚窗口。
+func (c Config) RollbackWindow() time.Duration {
+	return time.Duration(c.Keys.RollbackWindowHours) * time.Hour
+}
+
+// FlushInterval 返回输出分片合并的时间窗口，非正数表示不按时间合并。
+func (c Config) FlushInterval() time.Duration {
+	if c.Output.FlushIntervalMs <= 0 {
+		return 0
+	}
+	return time.Duration(c.Output.FlushIntervalMs) * time.Millisecond
+}
+
+// TerminationGrace 返回超时首个信号到 SIGKILL 之间的宽限期，非正数表示立即升级。
+func (c Config) TerminationGrace() time.Duration {
+	if c.Termination.GraceSeconds <= 0 {
+		return 0
+	}
+	return time.Duration(c.Termination.GraceSeconds) * time.Second
+}
+
+// EnrollPollMax 返回注册待审批时轮询间隔的上限，未配置时为 5 分钟。
+func (c Config) EnrollPollMax() time.Duration {
+	if c.Auth.EnrollPollMaxSeconds <= 0 {
+		return 5 * time.Minute
+	}
+	return time.Duration(c.Auth.EnrollPollMaxSeconds) * time.Second
+}
+
+// Enrolling 报告下一次连接是否会使用 enrollmentToken 注册（没有 deviceToken 与静态 token 可用时）。
+func (c Config) Enrolling() bool {
+	return c.Auth.DeviceToken == "" && strings.TrimSpace(c.Auth.Token) == "" && strings.TrimSpace(c.Auth.EnrollmentToken) != ""
+}
+
+// RekeyDeviceToken 切换 deviceToken 的加密密钥，并用新密钥重新加密已持久化的 token。
+func (c *Config) RekeyDeviceToken(key []byte) error {
+	c.Auth.TokenKey = key
+	if c.Auth.DeviceToken == "" {
+		return nil
+	}
+	return SaveDeviceToken(c.Auth.DeviceTokenPath, c.Auth.DeviceToken, key)
+}
+
 func (c Config) SelectedAuthToken() string {
 	if c.Auth.DeviceToken != "" {
 		return c.Auth.DeviceToken
@@ -98,6 +356,11 @@ func (c Config) SelectedAuthToken() string {
 	return c.Auth.Token
 }
 
+// DeviceTokenExpired 判断已知过期时间的 deviceToken 是否已失效；过期时间未知时返回 false。
+func (c Config) DeviceTokenExpired(now time.Time) bool {
+	return c.Auth.DeviceToken != "" && c.Auth.DeviceTokenExpiresAt > 0 && now.UnixMilli() >= c.Auth.DeviceTokenExpiresAt
+}
+
 func (c *Config) UpdateDeviceToken(token string) {
 	if c == nil || strings.TrimSpace(token) == "" {
 		return
@@ -105,38 +368,87 @@ func (c *Config) UpdateDeviceToken(token string) {
 	c.Auth.DeviceToken = token
 }
 
-func LoadDeviceToken(path string) (string, error) {
+// LoadDeviceToken 读取 deviceToken，兼容加密格式与旧版明文格式；加密格式需提供 key。
+func LoadDeviceToken(path string, key []byte) (string, error) {
+	token, _, err := readDeviceToken(path, key)
+	return token, err
+}
+
+func readDeviceToken(path string, key []byte) (token string, sealed bool, err error) {
 	if strings.TrimSpace(path) == "" {
-		return "", nil
+		return "", false, nil
 	}
 	absPath, err := filepath.Abs(path)
 	if err != nil {
-		return "", fmt.Errorf("resolve deviceTokenPath: %w", err)
+		return "", false, fmt.Errorf("resolve deviceTokenPath: %w", err)
 	}
 	data, err := os.ReadFile(absPath)
 	if err != nil {
-		return "", err
+		return "", false, err
+	}
+	content := strings.TrimSpace(string(data))
+	if !isSealedToken(content) {
+		return content, false, nil
+	}
+	token, err = openToken(content, key)
+	if err != nil {
+		return "", true, err
 	}
-	token := strings.TrimSpace(string(data))
-	return token, nil
+	return strings.TrimSpace(token), true, nil
 }
 
-func SaveDeviceToken(path, token string) error {
+// SaveDeviceToken 原子地持久化 deviceToken：先写入同目录临时文件并 fsync，再 rename 覆盖，
+// 避免轮换过程中进程崩溃留下截断的 token 文件。
+//
+// key 非空时以 AES-256-GCM 加密落盘（带完整性校验）；key 为空时按旧版明文格式写入。
+func SaveDeviceToken(path, token string, key []byte) error {
 	path = strings.TrimSpace(path)
 	token = strings.TrimSpace(token)
 	if path == "" || token == "" {
 		return nil
 	}
+	content := token
+	if len(key) > 0 {
+		sealed, err := sealToken(token, key)
+		if err != nil {
+			return fmt.Errorf("encrypt device token: %w", err)
+		}
+		content = sealed
+	}
 	absPath, err := filepath.Abs(path)
 	if err != nil {
 		return fmt.Errorf("resolve deviceTokenPath: %w", err)
 	}
-	if err := os.MkdirAll(filepath.Dir(absPath), 0o700); err != nil {
+	dir := filepath.Dir(absPath)
+	if err := os.MkdirAll(dir, 0o700); err != nil {
 		return fmt.Errorf("create device token dir: %w", err)
 	}
-	if err := os.WriteFile(absPath, []byte(token+"\n"), 0o600); err != nil {
+
+	tmp, err := os.CreateTemp(dir, filepath.Base(absPath)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("create device token temp file: %w", err)
+	}
+	tmpPath := tmp.Name()
+	defer os.Remove(tmpPath)
+
+	if err := tmp.Chmod(0o600); err != nil {
+		tmp.Close()
+		return fmt.Errorf("chmod device token temp file: %w", err)
+	}
+	if _, err := tmp.WriteString(content + "\n"); err != nil {
+		tmp.Close()
 		return fmt.Errorf("write device token: %w", err)
 	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("sync device token: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("close device token temp file: %w", err)
+	}
+	if err := os.Rename(tmpPath, absPath); err != nil {
+		return fmt.Errorf("replace device token: %w", err)
+	}
 	return nil
 }
 
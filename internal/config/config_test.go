@@ -1,6 +1,11 @@
 package config
 
-import "testing"
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
 
 func TestSelectedAuthTokenPrefersDeviceToken(t *testing.T) {
 	cfg := Config{
@@ -30,3 +35,51 @@ func TestUpdateDeviceTokenIgnoresBlankAndNilReceiver(t *testing.T) {
 	var nilCfg *Config
 	nilCfg.UpdateDeviceToken("new-token")
 }
+
+func TestSaveDeviceTokenReplacesFileAtomically(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "device.token")
+
+	if err := SaveDeviceToken(path, "first", nil); err != nil {
+		t.Fatalf("SaveDeviceToken(first) error = %v", err)
+	}
+	if err := SaveDeviceToken(path, "second", nil); err != nil {
+		t.Fatalf("SaveDeviceToken(second) error = %v", err)
+	}
+
+	token, err := LoadDeviceToken(path, nil)
+	if err != nil {
+		t.Fatalf("LoadDeviceToken() error = %v", err)
+	}
+	if token != "second" {
+		t.Fatalf("token = %q, want %q", token, "second")
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("ReadDir() error = %v", err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("dir entries = %d, want only the token file", len(entries))
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("Stat() error = %v", err)
+	}
+	if perm := info.Mode().Perm(); perm != 0o600 {
+		t.Fatalf("token file perm = %o, want 600", perm)
+	}
+}
+
+func TestDeviceTokenExpired(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	cfg := Config{Auth: AuthConfig{DeviceToken: "token"}}
+	if cfg.DeviceTokenExpired(now) {
+		t.Fatalf("DeviceTokenExpired() = true with unknown expiry")
+	}
+
+	cfg.Auth.DeviceTokenExpiresAt = now.Add(-time.Second).UnixMilli()
+	if !cfg.DeviceTokenExpired(now) {
+		t.Fatalf("DeviceTokenExpired() = false for past expiry")
+	}
+}
@@ -0,0 +1,69 @@
+package config
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// sealedTokenPrefix 标识加密后的 deviceToken 文件内容：
+//
+//	enc:v1:<Base64(nonce || AES-256-GCM 密文)>
+const sealedTokenPrefix = "enc:v1:"
+
+var tokenAAD = []byte("devops-agent/device-token")
+
+// ErrDeviceTokenCorrupt 表示 token 文件无法解密或未通过完整性校验（被篡改、密钥变化等）。
+var ErrDeviceTokenCorrupt = errors.New("device token corrupt or key mismatch")
+
+func isSealedToken(data string) bool {
+	return strings.HasPrefix(data, sealedTokenPrefix)
+}
+
+func sealToken(token string, key []byte) (string, error) {
+	aead, err := newTokenAEAD(key)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", fmt.Errorf("generate nonce: %w", err)
+	}
+	sealed := aead.Seal(nonce, nonce, []byte(token), tokenAAD)
+	return sealedTokenPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+func openToken(data string, key []byte) (string, error) {
+	if len(key) == 0 {
+		return "", fmt.Errorf("%w: token file is encrypted but no key is available", ErrDeviceTokenCorrupt)
+	}
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(data, sealedTokenPrefix))
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrDeviceTokenCorrupt, err)
+	}
+	aead, err := newTokenAEAD(key)
+	if err != nil {
+		return "", err
+	}
+	if len(raw) < aead.NonceSize() {
+		return "", fmt.Errorf("%w: truncated", ErrDeviceTokenCorrupt)
+	}
+	plain, err := aead.Open(nil, raw[:aead.NonceSize()], raw[aead.NonceSize():], tokenAAD)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrDeviceTokenCorrupt, err)
+	}
+	return string(plain), nil
+}
+
+func newTokenAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("token cipher: %w", err)
+	}
+	return cipher.NewGCM(block)
+}
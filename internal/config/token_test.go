@@ -0,0 +1,83 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSaveDeviceTokenEncryptsWithKey(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "device.token")
+	key := bytes.Repeat([]byte{7}, 32)
+
+	if err := SaveDeviceToken(path, "secret-token", key); err != nil {
+		t.Fatalf("SaveDeviceToken() error = %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile() error = %v", err)
+	}
+	if strings.Contains(string(data), "secret-token") || !isSealedToken(string(data)) {
+		t.Fatalf("token file = %q, want sealed content", data)
+	}
+
+	token, err := LoadDeviceToken(path, key)
+	if err != nil {
+		t.Fatalf("LoadDeviceToken() error = %v", err)
+	}
+	if token != "secret-token" {
+		t.Fatalf("token = %q, want %q", token, "secret-token")
+	}
+
+	if _, err := LoadDeviceToken(path, bytes.Repeat([]byte{8}, 32)); !errors.Is(err, ErrDeviceTokenCorrupt) {
+		t.Fatalf("LoadDeviceToken(wrong key) error = %v, want %v", err, ErrDeviceTokenCorrupt)
+	}
+}
+
+func TestLoadDeviceTokenDetectsTampering(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "device.token")
+	key := bytes.Repeat([]byte{7}, 32)
+	if err := SaveDeviceToken(path, "secret-token", key); err != nil {
+		t.Fatalf("SaveDeviceToken() error = %v", err)
+	}
+
+	data, _ := os.ReadFile(path)
+	tampered := []byte(strings.TrimSpace(string(data)))
+	mid := len(sealedTokenPrefix) + 20
+	if tampered[mid] == 'A' {
+		tampered[mid] = 'B'
+	} else {
+		tampered[mid] = 'A'
+	}
+	if err := os.WriteFile(path, tampered, 0o600); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+
+	if _, err := LoadDeviceToken(path, key); !errors.Is(err, ErrDeviceTokenCorrupt) {
+		t.Fatalf("LoadDeviceToken(tampered) error = %v, want %v", err, ErrDeviceTokenCorrupt)
+	}
+}
+
+func TestRestoreDeviceTokenMigratesPlaintext(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "device.token")
+	if err := os.WriteFile(path, []byte("legacy-token\n"), 0o600); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+
+	cfg := &Config{Auth: AuthConfig{DeviceTokenPath: path, TokenKey: bytes.Repeat([]byte{1}, 32)}}
+	if err := cfg.RestoreDeviceToken(); err != nil {
+		t.Fatalf("RestoreDeviceToken() error = %v", err)
+	}
+	if cfg.Auth.DeviceToken != "legacy-token" {
+		t.Fatalf("DeviceToken = %q, want %q", cfg.Auth.DeviceToken, "legacy-token")
+	}
+
+	data, _ := os.ReadFile(path)
+	if !isSealedToken(string(data)) {
+		t.Fatalf("token file = %q, want migrated to sealed format", data)
+	}
+}
@@ -0,0 +1,263 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// 支持的密钥文件格式：
+//
+//   - 原始字节：私钥 64 字节（seed‖public）、公钥 32 字节，即 EnsureKeyPair 自动生成的格式；
+//   - PKCS#8 / PKIX PEM（"PRIVATE KEY" / "PUBLIC KEY"），如 `openssl genpkey -algorithm ed25519` 的输出；
+//   - OpenSSH："OPENSSH PRIVATE KEY"（仅支持未加密私钥）与 authorized_keys 格式的 "ssh-ed25519 …" 公钥行。
+
+var ErrInvalidKey = errors.New("invalid ed25519 key")
+
+const (
+	pemPrivateKey        = "PRIVATE KEY"
+	pemPublicKey         = "PUBLIC KEY"
+	pemOpenSSHPrivateKey = "OPENSSH PRIVATE KEY"
+
+	sshKeyType   = "ssh-ed25519"
+	opensshMagic = "openssh-key-v1\x00"
+)
+
+// ParsePrivateKey 解析任一受支持格式的 Ed25519 私钥，并校验其内嵌公钥与 seed 一致。
+func ParsePrivateKey(data []byte) (ed25519.PrivateKey, error) {
+	var priv ed25519.PrivateKey
+	if block, _ := pem.Decode(data); block != nil {
+		switch block.Type {
+		case pemPrivateKey:
+			key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+			if err != nil {
+				return nil, fmt.Errorf("%w: parse PKCS#8: %v", ErrInvalidKey, err)
+			}
+			edKey, ok := key.(ed25519.PrivateKey)
+			if !ok {
+				return nil, fmt.Errorf("%w: PKCS#8 key is %T, not ed25519", ErrInvalidKey, key)
+			}
+			priv = edKey
+		case pemOpenSSHPrivateKey:
+			key, err := parseOpenSSHPrivateKey(block.Bytes)
+			if err != nil {
+				return nil, err
+			}
+			priv = key
+		default:
+			return nil, fmt.Errorf("%w: unsupported PEM block %q", ErrInvalidKey, block.Type)
+		}
+	} else {
+		if len(data) != ed25519.PrivateKeySize {
+			return nil, fmt.Errorf("%w: raw private key is %d bytes, want %d", ErrInvalidKey, len(data), ed25519.PrivateKeySize)
+		}
+		priv = ed25519.PrivateKey(append([]byte(nil), data...))
+	}
+
+	derived := ed25519.NewKeyFromSeed(priv.Seed())
+	if !bytes.Equal(derived, priv) {
+		return nil, fmt.Errorf("%w: embedded public key does not match private seed", ErrInvalidKey)
+	}
+	return priv, nil
+}
+
+// DecodePublicKey 解析任一受支持格式的 Ed25519 公钥。
+func DecodePublicKey(data []byte) (ed25519.PublicKey, error) {
+	if block, _ := pem.Decode(data); block != nil {
+		if block.Type != pemPublicKey {
+			return nil, fmt.Errorf("%w: unsupported PEM block %q", ErrInvalidKey, block.Type)
+		}
+		key, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("%w: parse PKIX: %v", ErrInvalidKey, err)
+		}
+		pub, ok := key.(ed25519.PublicKey)
+		if !ok {
+			return nil, fmt.Errorf("%w: PKIX key is %T, not ed25519", ErrInvalidKey, key)
+		}
+		return pub, nil
+	}
+
+	if fields := strings.Fields(string(data)); len(fields) >= 2 && fields[0] == sshKeyType {
+		blob, err := base64.StdEncoding.DecodeString(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("%w: decode authorized key: %v", ErrInvalidKey, err)
+		}
+		return parseSSHPublicBlob(blob)
+	}
+
+	if len(data) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("%w: raw public key is %d bytes, want %d", ErrInvalidKey, len(data), ed25519.PublicKeySize)
+	}
+	return ed25519.PublicKey(append([]byte(nil), data...)), nil
+}
+
+// ReadKeyPair 读取并校验一对密钥文件：
+//   - 私钥文件权限不得对 group/other 开放；
+//   - 公钥文件不存在时由私钥推导并补写为原始格式；
+//   - 公钥文件存在时必须与私钥匹配。
+func ReadKeyPair(privPath, pubPath string) (KeyPair, error) {
+	info, err := os.Stat(privPath)
+	if err != nil {
+		return KeyPair{}, fmt.Errorf("stat private key: %w", err)
+	}
+	if perm := info.Mode().Perm(); perm&0o077 != 0 {
+		return KeyPair{}, fmt.Errorf("private key %s has permissions %#o; restrict it with `chmod 600 %s`", privPath, perm, privPath)
+	}
+
+	data, err := os.ReadFile(privPath)
+	if err != nil {
+		return KeyPair{}, fmt.Errorf("read private key: %w", err)
+	}
+	priv, err := ParsePrivateKey(data)
+	if err != nil {
+		return KeyPair{}, fmt.Errorf("private key %s: %w", privPath, err)
+	}
+	derived := priv.Public().(ed25519.PublicKey)
+
+	pubData, err := os.ReadFile(pubPath)
+	if errors.Is(err, os.ErrNotExist) {
+		if err := os.WriteFile(pubPath, derived, 0o600); err != nil {
+			return KeyPair{}, fmt.Errorf("write public key: %w", err)
+		}
+		return KeyPair{Public: derived, Private: priv}, nil
+	}
+	if err != nil {
+		return KeyPair{}, fmt.Errorf("read public key: %w", err)
+	}
+	pub, err := DecodePublicKey(pubData)
+	if err != nil {
+		return KeyPair{}, fmt.Errorf("public key %s: %w", pubPath, err)
+	}
+	if !pub.Equal(derived) {
+		return KeyPair{}, fmt.Errorf("%w: public key %s does not match private key %s", ErrInvalidKey, pubPath, privPath)
+	}
+	return KeyPair{Public: pub, Private: priv}, nil
+}
+
+// MarshalPublicKeyPEM 以 PKIX PEM 格式导出公钥。
+func MarshalPublicKeyPEM(pub ed25519.PublicKey) ([]byte, error) {
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return nil, fmt.Errorf("marshal PKIX public key: %w", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: pemPublicKey, Bytes: der}), nil
+}
+
+// MarshalAuthorizedKey 以 OpenSSH authorized_keys 格式导出公钥。
+func MarshalAuthorizedKey(pub ed25519.PublicKey, comment string) []byte {
+	var blob bytes.Buffer
+	writeSSHString(&blob, []byte(sshKeyType))
+	writeSSHString(&blob, pub)
+
+	line := sshKeyType + " " + base64.StdEncoding.EncodeToString(blob.Bytes())
+	if comment != "" {
+		line += " " + comment
+	}
+	return []byte(line + "\n")
+}
+
+// parseOpenSSHPrivateKey 解析 openssh-key-v1 格式（PROTOCOL.key）中第一把 ed25519 私钥。
+func parseOpenSSHPrivateKey(data []byte) (ed25519.PrivateKey, error) {
+	if !bytes.HasPrefix(data, []byte(opensshMagic)) {
+		return nil, fmt.Errorf("%w: missing openssh-key-v1 magic", ErrInvalidKey)
+	}
+	r := sshReader{buf: data[len(opensshMagic):]}
+
+	cipherName := string(r.readString())
+	kdfName := string(r.readString())
+	r.readString() // kdfoptions
+	numKeys := r.readUint32()
+	if r.err == nil && (cipherName != "none" || kdfName != "none") {
+		return nil, fmt.Errorf("%w: encrypted OpenSSH keys are not supported (cipher=%s); decrypt with `ssh-keygen -p -N \"\"`", ErrInvalidKey, cipherName)
+	}
+	if r.err == nil && numKeys != 1 {
+		return nil, fmt.Errorf("%w: OpenSSH file holds %d keys, want 1", ErrInvalidKey, numKeys)
+	}
+	r.readString() // public key blob
+	private := sshReader{buf: r.readString()}
+	if r.err != nil {
+		return nil, fmt.Errorf("%w: malformed OpenSSH key: %v", ErrInvalidKey, r.err)
+	}
+
+	check1, check2 := private.readUint32(), private.readUint32()
+	keyType := string(private.readString())
+	pub := private.readString()
+	priv := private.readString()
+	if private.err != nil {
+		return nil, fmt.Errorf("%w: malformed OpenSSH private section: %v", ErrInvalidKey, private.err)
+	}
+	if check1 != check2 {
+		return nil, fmt.Errorf("%w: OpenSSH check bytes mismatch (encrypted or corrupt key)", ErrInvalidKey)
+	}
+	if keyType != sshKeyType {
+		return nil, fmt.Errorf("%w: OpenSSH key type %q, want %s", ErrInvalidKey, keyType, sshKeyType)
+	}
+	if len(priv) != ed25519.PrivateKeySize || !bytes.Equal(priv[32:], pub) {
+		return nil, fmt.Errorf("%w: malformed OpenSSH ed25519 key material", ErrInvalidKey)
+	}
+	return ed25519.PrivateKey(append([]byte(nil), priv...)), nil
+}
+
+func parseSSHPublicBlob(blob []byte) (ed25519.PublicKey, error) {
+	r := sshReader{buf: blob}
+	keyType := string(r.readString())
+	pub := r.readString()
+	if r.err != nil {
+		return nil, fmt.Errorf("%w: malformed authorized key: %v", ErrInvalidKey, r.err)
+	}
+	if keyType != sshKeyType {
+		return nil, fmt.Errorf("%w: authorized key type %q, want %s", ErrInvalidKey, keyType, sshKeyType)
+	}
+	if len(pub) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("%w: authorized key is %d bytes, want %d", ErrInvalidKey, len(pub), ed25519.PublicKeySize)
+	}
+	return ed25519.PublicKey(append([]byte(nil), pub...)), nil
+}
+
+// sshReader 按 SSH wire 格式（RFC 4251）顺序读取字段，出错后后续读取均返回零值。
+type sshReader struct {
+	buf []byte
+	err error
+}
+
+func (r *sshReader) readUint32() uint32 {
+	if r.err != nil {
+		return 0
+	}
+	if len(r.buf) < 4 {
+		r.err = errors.New("unexpected end of data")
+		return 0
+	}
+	v := binary.BigEndian.Uint32(r.buf)
+	r.buf = r.buf[4:]
+	return v
+}
+
+func (r *sshReader) readString() []byte {
+	n := r.readUint32()
+	if r.err != nil {
+		return nil
+	}
+	if uint32(len(r.buf)) < n {
+		r.err = errors.New("string exceeds data")
+		return nil
+	}
+	s := r.buf[:n]
+	r.buf = r.buf[n:]
+	return s
+}
+
+func writeSSHString(buf *bytes.Buffer, s []byte) {
+	var n [4]byte
+	binary.BigEndian.PutUint32(n[:], uint32(len(s)))
+	buf.Write(n[:])
+	buf.Write(s)
+}
@@ -0,0 +1,88 @@
+package crypto
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	ErrSignatureMissing = errors.New("signature missing")
+	ErrSignatureInvalid = errors.New("signature invalid")
+)
+
+// ParsePublicKey 解析 Base64 编码的 Ed25519 公钥。
+func ParsePublicKey(encoded string) (ed25519.PublicKey, error) {
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return nil, fmt.Errorf("decode public key: %w", err)
+	}
+	if len(raw) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("public key length = %d, want %d", len(raw), ed25519.PublicKeySize)
+	}
+	return ed25519.PublicKey(raw), nil
+}
+
+// Verify 校验 Base64 编码的 Ed25519 签名。
+func Verify(pub ed25519.PublicKey, message []byte, signature string) error {
+	if strings.TrimSpace(signature) == "" {
+		return ErrSignatureMissing
+	}
+	sig, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		return fmt.Errorf("%w: decode: %v", ErrSignatureInvalid, err)
+	}
+	if !ed25519.Verify(pub, message, sig) {
+		return ErrSignatureInvalid
+	}
+	return nil
+}
+
+// HelloSigningMessage 构造网关在 hello-ok 中证明身份时签名的消息：
+//
+//	connectRequestId|deviceId|nonce
+func HelloSigningMessage(requestID, deviceID, nonce string) []byte {
+	return []byte(requestID + "|" + deviceID + "|" + nonce)
+}
+
+// FrameSigningMessage 构造网关对事件帧签名的消息：
+//
+//	event|payload
+//
+// payload 为帧中原样传输的 JSON 字节，Agent 不做重新序列化。
+func FrameSigningMessage(event string, payload []byte) []byte {
+	msg := make([]byte, 0, len(event)+1+len(payload))
+	msg = append(msg, event...)
+	msg = append(msg, '|')
+	return append(msg, payload...)
+}
+
+// LoadGatewayKey 读取已固定（pin）的网关公钥文件，文件内容为 Base64 文本。
+// 文件不存在时返回的错误满足 errors.Is(err, os.ErrNotExist)。
+func LoadGatewayKey(path string) (ed25519.PublicKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	pub, err := ParsePublicKey(string(data))
+	if err != nil {
+		return nil, fmt.Errorf("parse gateway key %q: %w", path, err)
+	}
+	return pub, nil
+}
+
+// SaveGatewayKey 以 Base64 文本形式固定网关公钥，用于首次信任（TOFU）后的持久化。
+func SaveGatewayKey(path string, pub ed25519.PublicKey) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return fmt.Errorf("create gateway key dir: %w", err)
+	}
+	encoded := base64.StdEncoding.EncodeToString(pub) + "\n"
+	if err := os.WriteFile(path, []byte(encoded), 0o600); err != nil {
+		return fmt.Errorf("write gateway key: %w", err)
+	}
+	return nil
+}
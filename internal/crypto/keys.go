@@ -22,24 +22,22 @@ const (
 )
 
 // EnsureKeyPair 确保 keyDir 下存在一对可用的 Ed25519 密钥；若不存在则自动生成。
+//
+// 已有密钥按 ReadKeyPair 校验格式、公私钥匹配关系与文件权限。
 func EnsureKeyPair(keyDir string) (KeyPair, error) {
 	if err := os.MkdirAll(keyDir, 0o700); err != nil {
 		return KeyPair{}, fmt.Errorf("create key dir: %w", err)
 	}
 
+	if err := recoverRotation(keyDir); err != nil {
+		return KeyPair{}, err
+	}
+
 	privPath := filepath.Join(keyDir, privateKeyFile)
 	pubPath := filepath.Join(keyDir, publicKeyFile)
 
 	if _, err := os.Stat(privPath); err == nil {
-		priv, err := os.ReadFile(privPath)
-		if err != nil {
-			return KeyPair{}, fmt.Errorf("read private key: %w", err)
-		}
-		pub, err := os.ReadFile(pubPath)
-		if err != nil {
-			return KeyPair{}, fmt.Errorf("read public key: %w", err)
-		}
-		return KeyPair{Public: ed25519.PublicKey(pub), Private: ed25519.PrivateKey(priv)}, nil
+		return ReadKeyPair(privPath, pubPath)
 	}
 
 	// 不存在则生成
@@ -64,3 +62,32 @@ func DeviceID(pub ed25519.PublicKey) string {
 	// 使用前 16 字节以避免过长。
 	return hex.EncodeToString(sum[:16])
 }
+
+// ImportKeyPair 将任一受支持格式的私钥文件导入 keyDir（以原始格式保存）。keyDir 中已有密钥时拒绝覆盖。
+func ImportKeyPair(keyDir, srcPath string) (KeyPair, error) {
+	data, err := os.ReadFile(srcPath)
+	if err != nil {
+		return KeyPair{}, fmt.Errorf("read key to import: %w", err)
+	}
+	priv, err := ParsePrivateKey(data)
+	if err != nil {
+		return KeyPair{}, fmt.Errorf("import %s: %w", srcPath, err)
+	}
+
+	if err := os.MkdirAll(keyDir, 0o700); err != nil {
+		return KeyPair{}, fmt.Errorf("create key dir: %w", err)
+	}
+	privPath := filepath.Join(keyDir, privateKeyFile)
+	if _, err := os.Stat(privPath); err == nil {
+		return KeyPair{}, fmt.Errorf("refusing to overwrite existing private key %s", privPath)
+	}
+
+	pub := priv.Public().(ed25519.PublicKey)
+	if err := os.WriteFile(filepath.Join(keyDir, publicKeyFile), pub, 0o600); err != nil {
+		return KeyPair{}, fmt.Errorf("write public key: %w", err)
+	}
+	if err := os.WriteFile(privPath, priv, 0o600); err != nil {
+		return KeyPair{}, fmt.Errorf("write private key: %w", err)
+	}
+	return KeyPair{Public: pub, Private: priv}, nil
+}
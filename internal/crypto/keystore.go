@@ -0,0 +1,339 @@
+package crypto
+
+import (
+	"bufio"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// KeyStore 代表 Agent 完成设备签名，调用方只依赖公钥与签名能力，不直接接触私钥字节。
+type KeyStore interface {
+	PublicKey() ed25519.PublicKey
+	Sign(msg []byte) ([]byte, error)
+}
+
+// SharedKeyStore 持有在多个 goroutine 间共享的当前设备密钥：密钥轮换提交或回滚时整体替换，
+// 读方每次通过 Load 取得一致的快照。零值持有 nil 密钥。
+type SharedKeyStore struct {
+	mu    sync.RWMutex
+	store KeyStore
+}
+
+// NewSharedKeyStore 返回持有 store 的 SharedKeyStore。
+func NewSharedKeyStore(store KeyStore) *SharedKeyStore {
+	return &SharedKeyStore{store: store}
+}
+
+// Load 返回当前设备密钥。
+func (s *SharedKeyStore) Load() KeyStore {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.store
+}
+
+// Replace 将当前设备密钥替换为 store。
+func (s *SharedKeyStore) Replace(store KeyStore) {
+	s.mu.Lock()
+	s.store = store
+	s.mu.Unlock()
+}
+
+// 支持的 KeyStore 后端（keys.backend）。
+const (
+	BackendFile    = "file"
+	BackendEnv     = "env"
+	BackendFD      = "fd"
+	BackendCommand = "command"
+)
+
+// PublicKey 实现 KeyStore。
+func (kp KeyPair) PublicKey() ed25519.PublicKey {
+	return kp.Public
+}
+
+// Sign 实现 KeyStore。
+func (kp KeyPair) Sign(msg []byte) ([]byte, error) {
+	if len(kp.Private) != ed25519.PrivateKeySize {
+		return nil, fmt.Errorf("%w: private key is %d bytes, want %d", ErrInvalidKey, len(kp.Private), ed25519.PrivateKeySize)
+	}
+	return ed25519.Sign(kp.Private, msg), nil
+}
+
+// KeyStoreOptions 描述如何打开设备密钥。
+type KeyStoreOptions struct {
+	// Backend 为 file（默认）/ env / fd / command。
+	Backend string
+	// Dir 为 file 后端的密钥目录。
+	Dir string
+	// Env 为 env 后端读取私钥的环境变量名，读取后即从进程环境中移除。
+	Env string
+	// FD 为 fd 后端读取私钥的文件描述符，读取后即关闭。
+	FD int
+	// Command 为 command 后端的外部签名进程及其参数。
+	Command []string
+	// CommandTimeout 为 command 后端单次请求的超时，非正数使用 DefaultKeyCommandTimeout。
+	CommandTimeout time.Duration
+}
+
+// OpenKeyStore 按 opts.Backend 打开设备密钥。只有 file 后端支持密钥轮换。
+func OpenKeyStore(opts KeyStoreOptions) (KeyStore, error) {
+	switch strings.ToLower(strings.TrimSpace(opts.Backend)) {
+	case "", BackendFile:
+		return EnsureKeyPair(opts.Dir)
+	case BackendEnv:
+		return loadEnvKey(opts.Env)
+	case BackendFD:
+		return loadFDKey(opts.FD)
+	case BackendCommand:
+		return StartCommandStore(opts.Command, opts.CommandTimeout)
+	default:
+		return nil, fmt.Errorf("unknown keys.backend %q (want file, env, fd or command)", opts.Backend)
+	}
+}
+
+// SupportsRotation 报告该后端是否支持 -rotate-keys / -rollback-keys。
+func SupportsRotation(backend string) bool {
+	b := strings.ToLower(strings.TrimSpace(backend))
+	return b == "" || b == BackendFile
+}
+
+func loadEnvKey(name string) (KeyPair, error) {
+	if name == "" {
+		return KeyPair{}, errors.New("keys.env is empty")
+	}
+	value, ok := os.LookupEnv(name)
+	if !ok || strings.TrimSpace(value) == "" {
+		return KeyPair{}, fmt.Errorf("private key env %s is not set", name)
+	}
+	// 避免子进程（命令执行、终端会话）继承私钥。
+	os.Unsetenv(name)
+
+	kp, err := parseKeyMaterial([]byte(value))
+	if err != nil {
+		return KeyPair{}, fmt.Errorf("private key env %s: %w", name, err)
+	}
+	return kp, nil
+}
+
+func loadFDKey(fd int) (KeyPair, error) {
+	if fd < 3 {
+		return KeyPair{}, fmt.Errorf("keys.fd %d must be >= 3", fd)
+	}
+	f := os.NewFile(uintptr(fd), fmt.Sprintf("fd%d", fd))
+	if f == nil {
+		return KeyPair{}, fmt.Errorf("private key fd %d is not open", fd)
+	}
+	defer f.Close()
+
+	data, err := io.ReadAll(io.LimitReader(f, 64<<10))
+	if err != nil {
+		return KeyPair{}, fmt.Errorf("read private key fd %d: %w", fd, err)
+	}
+	kp, err := parseKeyMaterial(data)
+	if err != nil {
+		return KeyPair{}, fmt.Errorf("private key fd %d: %w", fd, err)
+	}
+	return kp, nil
+}
+
+// parseKeyMaterial 解析 env / fd 中的私钥：PEM 或 OpenSSH 文本，或原始私钥的 Base64 编码。
+func parseKeyMaterial(data []byte) (KeyPair, error) {
+	text := strings.TrimSpace(string(data))
+	if !strings.HasPrefix(text, "-----BEGIN") {
+		raw, err := base64.StdEncoding.DecodeString(text)
+		if err != nil {
+			return KeyPair{}, fmt.Errorf("%w: expected PEM, OpenSSH or base64 raw key", ErrInvalidKey)
+		}
+		data = raw
+	}
+	priv, err := ParsePrivateKey(data)
+	if err != nil {
+		return KeyPair{}, err
+	}
+	return KeyPair{Public: priv.Public().(ed25519.PublicKey), Private: priv}, nil
+}
+
+// CommandStore 将签名委托给外部进程，私钥始终留在该进程（如 vault agent、HSM 封装）中。
+//
+// 协议为 stdin/stdout 上的 JSON Lines，每个请求对应一行响应：
+//
+//	→ {"op":"publicKey"}            ← {"publicKey":"<Base64>"}
+//	→ {"op":"sign","data":"<Base64>"} ← {"signature":"<Base64>"}
+//
+// 出错时响应 {"error":"…"}。进程异常退出后下一次调用会重新拉起；单次请求超过超时仍无响应时
+// 进程被杀死并返回错误，下一次调用同样重新拉起，避免挂起的签名进程卡住握手与 token 刷新。
+type CommandStore struct {
+	argv    []string
+	timeout time.Duration
+
+	mu     sync.Mutex
+	cmd    *exec.Cmd
+	stdin  *os.File
+	out    *os.File
+	stdout *bufio.Scanner
+	public ed25519.PublicKey
+}
+
+// DefaultKeyCommandTimeout 为 command 后端单次请求的默认超时。
+const DefaultKeyCommandTimeout = 10 * time.Second
+
+type commandRequest struct {
+	Op   string `json:"op"`
+	Data string `json:"data,omitempty"`
+}
+
+type commandResponse struct {
+	PublicKey string `json:"publicKey,omitempty"`
+	Signature string `json:"signature,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// StartCommandStore 启动外部签名进程并获取其公钥；timeout 为单次请求的超时，非正数使用 DefaultKeyCommandTimeout。
+func StartCommandStore(argv []string, timeout time.Duration) (*CommandStore, error) {
+	if len(argv) == 0 || strings.TrimSpace(argv[0]) == "" {
+		return nil, errors.New("keys.command is empty")
+	}
+	if timeout <= 0 {
+		timeout = DefaultKeyCommandTimeout
+	}
+	s := &CommandStore{argv: argv, timeout: timeout}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	res, err := s.call(commandRequest{Op: "publicKey"})
+	if err != nil {
+		return nil, err
+	}
+	pub, err := ParsePublicKey(res.PublicKey)
+	if err != nil {
+		s.stop()
+		return nil, fmt.Errorf("key command public key: %w", err)
+	}
+	s.public = pub
+	return s, nil
+}
+
+// PublicKey 实现 KeyStore。
+func (s *CommandStore) PublicKey() ed25519.PublicKey {
+	return s.public
+}
+
+// Sign 实现 KeyStore，并用已知公钥校验外部进程返回的签名。
+func (s *CommandStore) Sign(msg []byte) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	res, err := s.call(commandRequest{Op: "sign", Data: base64.StdEncoding.EncodeToString(msg)})
+	if err != nil {
+		return nil, err
+	}
+	if err := Verify(s.public, msg, res.Signature); err != nil {
+		return nil, fmt.Errorf("key command signature: %w", err)
+	}
+	sig, _ := base64.StdEncoding.DecodeString(res.Signature)
+	return sig, nil
+}
+
+// Close 结束外部签名进程。
+func (s *CommandStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stop()
+	return nil
+}
+
+// call 发送一个请求并在 s.timeout 内读取一行响应；调用方需持有 s.mu。
+func (s *CommandStore) call(req commandRequest) (commandResponse, error) {
+	if s.cmd == nil {
+		if err := s.start(); err != nil {
+			return commandResponse{}, err
+		}
+	}
+
+	line, err := json.Marshal(req)
+	if err != nil {
+		return commandResponse{}, fmt.Errorf("marshal key command request: %w", err)
+	}
+	deadline := time.Now().Add(s.timeout)
+	_ = s.stdin.SetWriteDeadline(deadline)
+	_ = s.out.SetReadDeadline(deadline)
+	if _, err := s.stdin.Write(append(line, '\n')); err != nil {
+		s.stop()
+		return commandResponse{}, fmt.Errorf("write key command request: %w", s.timeoutError(err))
+	}
+	if !s.stdout.Scan() {
+		err := s.stdout.Err()
+		if err == nil {
+			err = io.ErrUnexpectedEOF
+		}
+		s.stop()
+		return commandResponse{}, fmt.Errorf("read key command response: %w", s.timeoutError(err))
+	}
+
+	var res commandResponse
+	if err := json.Unmarshal(s.stdout.Bytes(), &res); err != nil {
+		s.stop()
+		return commandResponse{}, fmt.Errorf("decode key command response: %w", err)
+	}
+	if res.Error != "" {
+		return commandResponse{}, fmt.Errorf("key command %s: %s", req.Op, res.Error)
+	}
+	return res, nil
+}
+
+func (s *CommandStore) timeoutError(err error) error {
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		return fmt.Errorf("no response within %s: %w", s.timeout, err)
+	}
+	return err
+}
+
+// start 拉起外部签名进程。管道由 os.Pipe 自行创建（而不是 StdinPipe / StdoutPipe），以便为每次请求设置读写截止时间。
+func (s *CommandStore) start() error {
+	stdinR, stdinW, err := os.Pipe()
+	if err != nil {
+		return fmt.Errorf("key command stdin: %w", err)
+	}
+	stdoutR, stdoutW, err := os.Pipe()
+	if err != nil {
+		stdinR.Close()
+		stdinW.Close()
+		return fmt.Errorf("key command stdout: %w", err)
+	}
+
+	cmd := exec.Command(s.argv[0], s.argv[1:]...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = stdinR, stdoutW, os.Stderr
+	err = cmd.Start()
+	// 子进程已持有自己的一端；父进程关闭后，子进程退出时读取端才能读到 EOF。
+	stdinR.Close()
+	stdoutW.Close()
+	if err != nil {
+		stdinW.Close()
+		stdoutR.Close()
+		return fmt.Errorf("start key command: %w", err)
+	}
+	s.cmd, s.stdin, s.out, s.stdout = cmd, stdinW, stdoutR, bufio.NewScanner(stdoutR)
+	return nil
+}
+
+func (s *CommandStore) stop() {
+	if s.cmd == nil {
+		return
+	}
+	s.stdin.Close()
+	if s.cmd.Process != nil {
+		s.cmd.Process.Kill()
+	}
+	s.cmd.Wait()
+	s.out.Close()
+	s.cmd, s.stdin, s.out, s.stdout = nil, nil, nil, nil
+}
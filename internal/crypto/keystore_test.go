@@ -0,0 +1,198 @@
+package crypto
+
+import (
+	"bufio"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestOpenKeyStoreFromEnvScrubsVariable(t *testing.T) {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey() error = %v", err)
+	}
+	t.Setenv("TEST_AGENT_DEVICE_KEY", base64.StdEncoding.EncodeToString(priv))
+
+	store, err := OpenKeyStore(KeyStoreOptions{Backend: BackendEnv, Env: "TEST_AGENT_DEVICE_KEY"})
+	if err != nil {
+		t.Fatalf("OpenKeyStore(env) error = %v", err)
+	}
+	if !store.PublicKey().Equal(priv.Public()) {
+		t.Fatalf("env store public key mismatch")
+	}
+	if _, ok := os.LookupEnv("TEST_AGENT_DEVICE_KEY"); ok {
+		t.Fatalf("private key env still set after loading")
+	}
+	assertSigns(t, store)
+}
+
+func TestOpenKeyStoreFromFD(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("Pipe() error = %v", err)
+	}
+	if _, err := w.WriteString(testOpenSSHPrivateKey); err != nil {
+		t.Fatalf("WriteString() error = %v", err)
+	}
+	w.Close()
+	// OpenKeyStore 会关闭传入的 fd，这里传递副本以免与 r 的关闭冲突。
+	fd, err := syscall.Dup(int(r.Fd()))
+	if err != nil {
+		t.Fatalf("Dup() error = %v", err)
+	}
+	r.Close()
+
+	store, err := OpenKeyStore(KeyStoreOptions{Backend: BackendFD, FD: fd})
+	if err != nil {
+		t.Fatalf("OpenKeyStore(fd) error = %v", err)
+	}
+	want, _ := DecodePublicKey([]byte(testOpenSSHPublicKey))
+	if !store.PublicKey().Equal(want) {
+		t.Fatalf("fd store public key mismatch")
+	}
+	assertSigns(t, store)
+}
+
+func TestCommandStoreDelegatesSigning(t *testing.T) {
+	t.Setenv("TEST_KEY_COMMAND_HELPER", "1")
+	store, err := StartCommandStore([]string{os.Args[0], "-test.run=TestKeyCommandHelper"}, 0)
+	if err != nil {
+		t.Fatalf("StartCommandStore() error = %v", err)
+	}
+	defer store.Close()
+
+	want, _ := DecodePublicKey([]byte(testOpenSSHPublicKey))
+	if !store.PublicKey().Equal(want) {
+		t.Fatalf("command store public key mismatch")
+	}
+	assertSigns(t, store)
+
+	// 外部进程退出后应自动重新拉起。
+	store.mu.Lock()
+	store.stop()
+	store.mu.Unlock()
+	assertSigns(t, store)
+
+	if _, err := DeriveTokenKey(store); err != nil {
+		t.Fatalf("DeriveTokenKey(command) error = %v", err)
+	}
+}
+
+func TestCommandStoreTimesOutHungCommand(t *testing.T) {
+	t.Setenv("TEST_KEY_COMMAND_HELPER", "1")
+	t.Setenv("TEST_KEY_COMMAND_HANG_ON_SIGN", "1")
+	store, err := StartCommandStore([]string{os.Args[0], "-test.run=TestKeyCommandHelper"}, 200*time.Millisecond)
+	if err != nil {
+		t.Fatalf("StartCommandStore() error = %v", err)
+	}
+	defer store.Close()
+
+	start := time.Now()
+	if _, err := store.Sign([]byte("hello")); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("Sign() error = %v, want %v", err, os.ErrDeadlineExceeded)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("Sign() returned after %v, want the per-call timeout", elapsed)
+	}
+
+	// 超时的进程已被杀死，下一次请求重新拉起。
+	t.Setenv("TEST_KEY_COMMAND_HANG_ON_SIGN", "")
+	assertSigns(t, store)
+}
+
+func TestSharedKeyStoreReplaceIsVisibleToConcurrentReaders(t *testing.T) {
+	first, err := EnsureKeyPair(t.TempDir())
+	if err != nil {
+		t.Fatalf("EnsureKeyPair() error = %v", err)
+	}
+	next, err := EnsureKeyPair(t.TempDir())
+	if err != nil {
+		t.Fatalf("EnsureKeyPair() error = %v", err)
+	}
+	shared := NewSharedKeyStore(first)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				if pub := shared.Load().PublicKey(); !pub.Equal(first.Public) && !pub.Equal(next.Public) {
+					t.Errorf("Load() returned an unknown key")
+					return
+				}
+			}
+		}()
+	}
+	shared.Replace(next)
+	wg.Wait()
+
+	if !shared.Load().PublicKey().Equal(next.Public) {
+		t.Fatalf("Load() after Replace() did not return the new key")
+	}
+}
+
+func TestOpenKeyStoreRejectsUnknownBackend(t *testing.T) {
+	if _, err := OpenKeyStore(KeyStoreOptions{Backend: "vault"}); err == nil {
+		t.Fatalf("OpenKeyStore(vault) error = nil, want error")
+	}
+	if SupportsRotation(BackendCommand) || !SupportsRotation("") {
+		t.Fatalf("SupportsRotation() reports wrong backends")
+	}
+}
+
+// TestKeyCommandHelper 作为 command 后端测试中的外部签名进程运行。
+func TestKeyCommandHelper(t *testing.T) {
+	if os.Getenv("TEST_KEY_COMMAND_HELPER") != "1" {
+		t.Skip("helper process")
+	}
+	priv, err := ParsePrivateKey([]byte(testOpenSSHPrivateKey))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	scanner := bufio.NewScanner(os.Stdin)
+	enc := json.NewEncoder(os.Stdout)
+	for scanner.Scan() {
+		var req commandRequest
+		if err := json.Unmarshal(scanner.Bytes(), &req); err != nil {
+			enc.Encode(commandResponse{Error: err.Error()})
+			continue
+		}
+		switch req.Op {
+		case "publicKey":
+			enc.Encode(commandResponse{PublicKey: base64.StdEncoding.EncodeToString(priv.Public().(ed25519.PublicKey))})
+		case "sign":
+			if os.Getenv("TEST_KEY_COMMAND_HANG_ON_SIGN") == "1" {
+				time.Sleep(time.Hour)
+			}
+			data, _ := base64.StdEncoding.DecodeString(req.Data)
+			enc.Encode(commandResponse{Signature: base64.StdEncoding.EncodeToString(ed25519.Sign(priv, data))})
+		default:
+			enc.Encode(commandResponse{Error: "unknown op"})
+		}
+	}
+	os.Exit(0)
+}
+
+func assertSigns(t *testing.T, store KeyStore) {
+	t.Helper()
+	msg := []byte("hello")
+	sig, err := store.Sign(msg)
+	if err != nil {
+		t.Fatalf("Sign() error = %v", err)
+	}
+	if !ed25519.Verify(store.PublicKey(), msg, sig) {
+		t.Fatalf("signature does not verify")
+	}
+}
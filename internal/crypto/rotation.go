@@ -0,0 +1,203 @@
+package crypto
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// 密钥轮换期间 keyDir 中的文件：
+//
+//   - *.next：已生成、待服务端确认的新密钥；
+//   - *.prev：已提交轮换前的旧密钥，在回滚窗口内保留，便于服务端拒绝轮换时恢复；
+//     回滚窗口自退役时刻起算，以 *.prev 私钥文件的修改时间记录（提交时更新）。
+const (
+	nextSuffix = ".next"
+	prevSuffix = ".prev"
+)
+
+// RotationSigningMessage 构造旧密钥证明轮换连续性时签名的消息：
+//
+//	rotate|previousDeviceId|newPublicKey|nonce
+//
+// newPublicKey 为新公钥的 Base64 编码，nonce 为本次握手的服务端挑战。
+func RotationSigningMessage(previousDeviceID, newPublicKey, nonce string) []byte {
+	return []byte("rotate|" + previousDeviceID + "|" + newPublicKey + "|" + nonce)
+}
+
+// SignRotation 使用旧密钥对新公钥签名，返回 Base64 编码的签名。
+func SignRotation(old KeyStore, newPublic ed25519.PublicKey, nonce string) (string, error) {
+	msg := RotationSigningMessage(DeviceID(old.PublicKey()), base64.StdEncoding.EncodeToString(newPublic), nonce)
+	sig, err := old.Sign(msg)
+	if err != nil {
+		return "", fmt.Errorf("sign key rotation: %w", err)
+	}
+	return base64.StdEncoding.EncodeToString(sig), nil
+}
+
+// StageRotation 生成待确认的新密钥对；已有待确认密钥时直接返回该密钥，保证重复调用幂等。
+func StageRotation(keyDir string) (KeyPair, error) {
+	if kp, ok, err := PendingRotation(keyDir); err != nil || ok {
+		return kp, err
+	}
+
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return KeyPair{}, fmt.Errorf("generate ed25519 key: %w", err)
+	}
+	// 先写公钥再写私钥：PendingRotation 以私钥文件是否存在判断是否有待确认轮换。
+	if err := os.WriteFile(keyPath(keyDir, publicKeyFile, nextSuffix), pub, 0o600); err != nil {
+		return KeyPair{}, fmt.Errorf("write staged public key: %w", err)
+	}
+	if err := os.WriteFile(keyPath(keyDir, privateKeyFile, nextSuffix), priv, 0o600); err != nil {
+		return KeyPair{}, fmt.Errorf("write staged private key: %w", err)
+	}
+	return KeyPair{Public: pub, Private: priv}, nil
+}
+
+// PendingRotation 返回待服务端确认的新密钥对。
+func PendingRotation(keyDir string) (KeyPair, bool, error) {
+	privPath := keyPath(keyDir, privateKeyFile, nextSuffix)
+	if _, err := os.Stat(privPath); errors.Is(err, os.ErrNotExist) {
+		return KeyPair{}, false, nil
+	} else if err != nil {
+		return KeyPair{}, false, fmt.Errorf("stat staged private key: %w", err)
+	}
+
+	kp, err := ReadKeyPair(privPath, keyPath(keyDir, publicKeyFile, nextSuffix))
+	if err != nil {
+		return KeyPair{}, false, fmt.Errorf("staged key: %w", err)
+	}
+	return kp, true, nil
+}
+
+// CommitRotation 在服务端确认后提交轮换：当前密钥移为 *.prev，待确认密钥移为当前密钥。
+//
+// 按「退役私钥、退役公钥、提升私钥、提升公钥」的顺序逐个同目录 rename；进程在中途崩溃时，
+// 下次 EnsureKeyPair 会通过 recoverRotation 成对完成剩余步骤。
+func CommitRotation(keyDir string) error {
+	for _, name := range []string{privateKeyFile, publicKeyFile} {
+		if err := retireKey(keyDir, name); err != nil {
+			return err
+		}
+	}
+	return recoverRotation(keyDir)
+}
+
+// retireKey 将当前密钥文件移为 *.prev。rename 保留文件原有的修改时间，因此先将其更新为退役时刻，
+// 使回滚窗口从提交时起算。
+func retireKey(keyDir, name string) error {
+	now := time.Now()
+	if err := os.Chtimes(keyPath(keyDir, name, ""), now, now); err != nil {
+		return fmt.Errorf("stamp current key %s: %w", name, err)
+	}
+	if err := os.Rename(keyPath(keyDir, name, ""), keyPath(keyDir, name, prevSuffix)); err != nil {
+		return fmt.Errorf("retire current key %s: %w", name, err)
+	}
+	return nil
+}
+
+// AbortRotation 丢弃待确认的新密钥。
+func AbortRotation(keyDir string) error {
+	for _, name := range []string{privateKeyFile, publicKeyFile} {
+		if err := os.Remove(keyPath(keyDir, name, nextSuffix)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("remove staged key %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// RejectRotation 处理服务端拒绝轮换：
+//
+//   - 存在待确认密钥时丢弃它，继续使用当前密钥；
+//   - 否则若回滚窗口内保留有旧密钥，则恢复旧密钥为当前密钥。
+//
+// 返回值表示当前密钥是否被替换（调用方需重新加载密钥）。
+func RejectRotation(keyDir string, window time.Duration) (bool, error) {
+	if _, ok, err := PendingRotation(keyDir); err != nil {
+		return false, err
+	} else if ok {
+		return false, AbortRotation(keyDir)
+	}
+
+	prevPriv := keyPath(keyDir, privateKeyFile, prevSuffix)
+	info, err := os.Stat(prevPriv)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	} else if err != nil {
+		return false, fmt.Errorf("stat previous private key: %w", err)
+	}
+	if window > 0 && time.Since(info.ModTime()) > window {
+		return false, nil
+	}
+
+	for _, name := range []string{privateKeyFile, publicKeyFile} {
+		if err := os.Rename(keyPath(keyDir, name, prevSuffix), keyPath(keyDir, name, "")); err != nil {
+			return false, fmt.Errorf("restore previous key %s: %w", name, err)
+		}
+	}
+	return true, nil
+}
+
+// PruneRotation 删除超出回滚窗口的旧密钥。
+func PruneRotation(keyDir string, window time.Duration) error {
+	info, err := os.Stat(keyPath(keyDir, privateKeyFile, prevSuffix))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("stat previous private key: %w", err)
+	}
+	if time.Since(info.ModTime()) <= window {
+		return nil
+	}
+	for _, name := range []string{privateKeyFile, publicKeyFile} {
+		if err := os.Remove(keyPath(keyDir, name, prevSuffix)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("remove previous key %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// recoverRotation 完成被中断的提交，公私钥作为一个整体处理。
+//
+// 尚未提交的待确认轮换不会缺少当前密钥文件，因此只要有当前密钥文件缺失且仍有待确认文件，就说明提交进行到
+// 一半。此时对两个文件统一补完剩余步骤：仍在的当前文件先退役，再提升待确认文件，避免留下新私钥配旧公钥。
+func recoverRotation(keyDir string) error {
+	var interrupted, staged bool
+	for _, name := range []string{privateKeyFile, publicKeyFile} {
+		if _, err := os.Stat(keyPath(keyDir, name, "")); errors.Is(err, os.ErrNotExist) {
+			interrupted = true
+		}
+		if _, err := os.Stat(keyPath(keyDir, name, nextSuffix)); err == nil {
+			staged = true
+		}
+	}
+	if !interrupted || !staged {
+		return nil
+	}
+
+	for _, name := range []string{privateKeyFile, publicKeyFile} {
+		next := keyPath(keyDir, name, nextSuffix)
+		if _, err := os.Stat(next); err != nil {
+			continue
+		}
+		if _, err := os.Stat(keyPath(keyDir, name, "")); err == nil {
+			if err := retireKey(keyDir, name); err != nil {
+				return err
+			}
+		}
+		if err := os.Rename(next, keyPath(keyDir, name, "")); err != nil {
+			return fmt.Errorf("promote staged key %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+func keyPath(keyDir, name, suffix string) string {
+	return filepath.Join(keyDir, name+suffix)
+}
@@ -0,0 +1,204 @@
+package crypto
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRotationCommitSwapsKeysAndKeepsPrevious(t *testing.T) {
+	dir := t.TempDir()
+	old := mustEnsureKeyPair(t, dir)
+
+	next, err := StageRotation(dir)
+	if err != nil {
+		t.Fatalf("StageRotation() error = %v", err)
+	}
+	again, err := StageRotation(dir)
+	if err != nil {
+		t.Fatalf("StageRotation(again) error = %v", err)
+	}
+	if !again.Public.Equal(next.Public) {
+		t.Fatalf("StageRotation() not idempotent")
+	}
+
+	if err := CommitRotation(dir); err != nil {
+		t.Fatalf("CommitRotation() error = %v", err)
+	}
+	current := mustEnsureKeyPair(t, dir)
+	if !current.Public.Equal(next.Public) {
+		t.Fatalf("current key is not the staged key after commit")
+	}
+	if _, ok, _ := PendingRotation(dir); ok {
+		t.Fatalf("pending rotation still present after commit")
+	}
+
+	restored, err := RejectRotation(dir, time.Hour)
+	if err != nil || !restored {
+		t.Fatalf("RejectRotation() = %v, %v; want true, nil", restored, err)
+	}
+	if current := mustEnsureKeyPair(t, dir); !current.Public.Equal(old.Public) {
+		t.Fatalf("current key is not the previous key after rollback")
+	}
+}
+
+func TestRecoverRotationFinishesHalfRenamedPair(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		steps int // 崩溃前完成的 rename 数：退役私钥、退役公钥、提升私钥
+	}{
+		{"private retired", 1},
+		{"both retired", 2},
+		{"private promoted", 3},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			old := mustEnsureKeyPair(t, dir)
+			next, err := StageRotation(dir)
+			if err != nil {
+				t.Fatalf("StageRotation() error = %v", err)
+			}
+			renames := [][2]string{
+				{keyPath(dir, privateKeyFile, ""), keyPath(dir, privateKeyFile, prevSuffix)},
+				{keyPath(dir, publicKeyFile, ""), keyPath(dir, publicKeyFile, prevSuffix)},
+				{keyPath(dir, privateKeyFile, nextSuffix), keyPath(dir, privateKeyFile, "")},
+			}
+			for _, r := range renames[:tc.steps] {
+				if err := os.Rename(r[0], r[1]); err != nil {
+					t.Fatalf("Rename() error = %v", err)
+				}
+			}
+
+			current := mustEnsureKeyPair(t, dir)
+			if !current.Public.Equal(next.Public) {
+				t.Fatalf("current key is not the staged key after recovery")
+			}
+			prev, err := ReadKeyPair(keyPath(dir, privateKeyFile, prevSuffix), keyPath(dir, publicKeyFile, prevSuffix))
+			if err != nil || !prev.Public.Equal(old.Public) {
+				t.Fatalf("previous key pair = %v, want the old key intact", err)
+			}
+			if _, ok, _ := PendingRotation(dir); ok {
+				t.Fatalf("pending rotation still present after recovery")
+			}
+		})
+	}
+}
+
+func TestRollbackWindowStartsAtCommitForOldKeys(t *testing.T) {
+	dir := t.TempDir()
+	old := mustEnsureKeyPair(t, dir)
+	created := time.Now().Add(-30 * 24 * time.Hour)
+	for _, name := range []string{privateKeyFile, publicKeyFile} {
+		if err := os.Chtimes(filepath.Join(dir, name), created, created); err != nil {
+			t.Fatalf("Chtimes() error = %v", err)
+		}
+	}
+	if _, err := StageRotation(dir); err != nil {
+		t.Fatalf("StageRotation() error = %v", err)
+	}
+	if err := CommitRotation(dir); err != nil {
+		t.Fatalf("CommitRotation() error = %v", err)
+	}
+
+	window := 168 * time.Hour
+	if err := PruneRotation(dir, window); err != nil {
+		t.Fatalf("PruneRotation() error = %v", err)
+	}
+	restored, err := RejectRotation(dir, window)
+	if err != nil || !restored {
+		t.Fatalf("RejectRotation() = %v, %v; want the 30-day-old key restored", restored, err)
+	}
+	if current := mustEnsureKeyPair(t, dir); !current.Public.Equal(old.Public) {
+		t.Fatalf("current key is not the previous key after rollback")
+	}
+}
+
+func TestRejectRotationDiscardsPendingKey(t *testing.T) {
+	dir := t.TempDir()
+	old := mustEnsureKeyPair(t, dir)
+	if _, err := StageRotation(dir); err != nil {
+		t.Fatalf("StageRotation() error = %v", err)
+	}
+
+	restored, err := RejectRotation(dir, time.Hour)
+	if err != nil || restored {
+		t.Fatalf("RejectRotation() = %v, %v; want false, nil", restored, err)
+	}
+	if _, ok, _ := PendingRotation(dir); ok {
+		t.Fatalf("pending rotation still present after reject")
+	}
+	if current := mustEnsureKeyPair(t, dir); !current.Public.Equal(old.Public) {
+		t.Fatalf("current key changed after rejecting a pending rotation")
+	}
+}
+
+func TestEnsureKeyPairRecoversInterruptedCommit(t *testing.T) {
+	dir := t.TempDir()
+	mustEnsureKeyPair(t, dir)
+	next, err := StageRotation(dir)
+	if err != nil {
+		t.Fatalf("StageRotation() error = %v", err)
+	}
+
+	// 模拟提交时在退役当前私钥之后崩溃。
+	if err := os.Rename(filepath.Join(dir, privateKeyFile), filepath.Join(dir, privateKeyFile+prevSuffix)); err != nil {
+		t.Fatalf("Rename() error = %v", err)
+	}
+	if err := os.Rename(filepath.Join(dir, publicKeyFile), filepath.Join(dir, publicKeyFile+prevSuffix)); err != nil {
+		t.Fatalf("Rename() error = %v", err)
+	}
+
+	if current := mustEnsureKeyPair(t, dir); !current.Public.Equal(next.Public) {
+		t.Fatalf("EnsureKeyPair() did not finish the interrupted commit")
+	}
+}
+
+func TestPruneRotationRemovesExpiredPreviousKey(t *testing.T) {
+	dir := t.TempDir()
+	mustEnsureKeyPair(t, dir)
+	if _, err := StageRotation(dir); err != nil {
+		t.Fatalf("StageRotation() error = %v", err)
+	}
+	if err := CommitRotation(dir); err != nil {
+		t.Fatalf("CommitRotation() error = %v", err)
+	}
+
+	prev := filepath.Join(dir, privateKeyFile+prevSuffix)
+	past := time.Now().Add(-48 * time.Hour)
+	if err := os.Chtimes(prev, past, past); err != nil {
+		t.Fatalf("Chtimes() error = %v", err)
+	}
+	if err := PruneRotation(dir, 24*time.Hour); err != nil {
+		t.Fatalf("PruneRotation() error = %v", err)
+	}
+	if _, err := os.Stat(prev); !os.IsNotExist(err) {
+		t.Fatalf("previous key still present after prune: err=%v", err)
+	}
+}
+
+func TestSignRotationVerifiesWithOldKey(t *testing.T) {
+	old := mustEnsureKeyPair(t, t.TempDir())
+	next := mustEnsureKeyPair(t, t.TempDir())
+
+	sig, err := SignRotation(old, next.Public, "nonce-1")
+	if err != nil {
+		t.Fatalf("SignRotation() error = %v", err)
+	}
+	msg := RotationSigningMessage(DeviceID(old.Public), base64.StdEncoding.EncodeToString(next.Public), "nonce-1")
+	raw, _ := base64.StdEncoding.DecodeString(sig)
+	if !ed25519.Verify(old.Public, msg, raw) {
+		t.Fatalf("rotation signature does not verify with old key")
+	}
+}
+
+func mustEnsureKeyPair(t *testing.T, dir string) KeyPair {
+	t.Helper()
+	kp, err := EnsureKeyPair(dir)
+	if err != nil {
+		t.Fatalf("EnsureKeyPair() error = %v", err)
+	}
+	return kp
+}
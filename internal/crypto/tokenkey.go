@@ -0,0 +1,58 @@
+package crypto
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+const (
+	tokenKeyLabel  = "devops-agent/device-token-key/v1"
+	tokenSecretLen = 32
+)
+
+// DeriveTokenKey 由设备密钥派生 deviceToken 的落盘加密密钥（32 字节）。
+//
+// Ed25519 签名是确定性的，因此对固定标签签名后再做 HMAC-SHA256 即可得到稳定密钥，
+// 且派生过程只依赖签名能力，不要求直接读取私钥字节。
+func DeriveTokenKey(store KeyStore) ([]byte, error) {
+	sig, err := store.Sign([]byte(tokenKeyLabel))
+	if err != nil {
+		return nil, fmt.Errorf("derive token key: %w", err)
+	}
+	return deriveKey(sig), nil
+}
+
+// LoadTokenSecret 读取独立于设备密钥的本地密钥文件并派生加密密钥；文件不存在时生成 32 字节随机密钥。
+func LoadTokenSecret(path string) ([]byte, error) {
+	secret, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		secret = make([]byte, tokenSecretLen)
+		if _, err := io.ReadFull(rand.Reader, secret); err != nil {
+			return nil, fmt.Errorf("generate token secret: %w", err)
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+			return nil, fmt.Errorf("create token secret dir: %w", err)
+		}
+		if err := os.WriteFile(path, secret, 0o600); err != nil {
+			return nil, fmt.Errorf("write token secret: %w", err)
+		}
+	} else if err != nil {
+		return nil, fmt.Errorf("read token secret: %w", err)
+	}
+	if len(secret) < tokenSecretLen {
+		return nil, fmt.Errorf("token secret %q too short: %d bytes, want at least %d", path, len(secret), tokenSecretLen)
+	}
+	return deriveKey(secret), nil
+}
+
+func deriveKey(material []byte) []byte {
+	mac := hmac.New(sha256.New, []byte(tokenKeyLabel))
+	mac.Write(material)
+	return mac.Sum(nil)
+}
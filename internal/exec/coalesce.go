@@ -0,0 +1,79 @@
+package exec
+
+import (
+	"sync"
+	"time"
+)
+
+// coalescer 合并单个输出流的小块输出：缓冲达到 minBytes，或首个未发送字节已等待 interval 时整体发送。
+// interval 与 minBytes 均为 0 时每次写入立即发送。send 始终在锁内调用，保证同一流的分片顺序；
+// 其 at 参数为缓冲中首个字节的读取时间。
+type coalescer struct {
+	interval time.Duration
+	minBytes int
+	send     func(p []byte, at time.Time, flush bool)
+
+	mu    sync.Mutex
+	buf   []byte
+	at    time.Time
+	timer *time.Timer
+}
+
+func newCoalescer(interval time.Duration, minBytes int, send func(p []byte, at time.Time, flush bool)) *coalescer {
+	return &coalescer{interval: interval, minBytes: minBytes, send: send}
+}
+
+// write 追加在 at 时刻读到的输出，按大小阈值或时间窗口决定何时发送。
+func (c *coalescer) write(p []byte, at time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.interval <= 0 && c.minBytes <= 0 {
+		c.send(p, at, false)
+		return
+	}
+	if len(c.buf) == 0 {
+		c.at = at
+	}
+	c.buf = append(c.buf, p...)
+	if c.minBytes > 0 && len(c.buf) >= c.minBytes {
+		c.flushLocked(false)
+		return
+	}
+	if c.interval > 0 && c.timer == nil {
+		var t *time.Timer
+		t = time.AfterFunc(c.interval, func() {
+			c.mu.Lock()
+			defer c.mu.Unlock()
+			// 定时器已被 flush 停止并替换时不再重复发送。
+			if c.timer != t {
+				return
+			}
+			c.timer = nil
+			c.flushLocked(false)
+		})
+		c.timer = t
+	}
+}
+
+// flush 立即发送缓冲内容；final 为 true 时同时清空编码器中残留的不完整字符（流结束或截断时）。
+func (c *coalescer) flush(final bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.flushLocked(final)
+}
+
+func (c *coalescer) flushLocked(final bool) {
+	if c.timer != nil {
+		c.timer.Stop()
+		c.timer = nil
+	}
+	if len(c.buf) > 0 || final {
+		at := c.at
+		if len(c.buf) == 0 {
+			at = time.Now()
+		}
+		c.send(c.buf, at, final)
+		c.buf = nil
+	}
+}
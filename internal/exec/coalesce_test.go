@@ -0,0 +1,83 @@
+package exec
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCoalescerFlushesBySizeAndInterval(t *testing.T) {
+	var (
+		mu   sync.Mutex
+		sent []string
+	)
+	record := func(p []byte, at time.Time, flush bool) {
+		mu.Lock()
+		defer mu.Unlock()
+		if len(p) > 0 {
+			sent = append(sent, string(p))
+		}
+	}
+	snapshot := func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), sent...)
+	}
+
+	bySize := newCoalescer(time.Hour, 4, record)
+	now := time.Now()
+	bySize.write([]byte("ab"), now)
+	bySize.write([]byte("cd"), now)
+	bySize.write([]byte("e"), now)
+	if got := snapshot(); len(got) != 1 || got[0] != "abcd" {
+		t.Fatalf("sent = %q, want one chunk at the size threshold", got)
+	}
+	bySize.flush(true)
+	if got := snapshot(); len(got) != 2 || got[1] != "e" {
+		t.Fatalf("sent = %q, want the remainder on final flush", got)
+	}
+
+	sent = nil
+	byTime := newCoalescer(20*time.Millisecond, 0, record)
+	byTime.write([]byte("x"), now)
+	byTime.write([]byte("y"), now)
+	deadline := time.Now().Add(2 * time.Second)
+	for len(snapshot()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if got := snapshot(); len(got) != 1 || got[0] != "xy" {
+		t.Fatalf("sent = %q, want one chunk after the interval", got)
+	}
+
+	sent = nil
+	passthrough := newCoalescer(0, 0, record)
+	passthrough.write([]byte("a"), now)
+	passthrough.write([]byte("b"), now)
+	if got := snapshot(); len(got) != 2 {
+		t.Fatalf("sent = %q, want every write forwarded when coalescing is off", got)
+	}
+}
+
+func TestRunStreamCoalescesSmallWrites(t *testing.T) {
+	executor := ShellExecutor{Enabled: true, FlushInterval: time.Hour, MinChunkBytes: 1 << 20}
+
+	var (
+		stdout strings.Builder
+		parts  int
+	)
+	chunks := executor.RunStream(context.Background(), Spec{
+		Command: "for i in 1 2 3 4 5; do echo line$i; sleep 0.01; done",
+		Timeout: 5 * time.Second,
+	})
+	for chunk := range chunks {
+		if chunk.StdoutChunk != "" {
+			stdout.WriteString(chunk.StdoutChunk)
+			parts++
+		}
+	}
+	if parts != 1 || stdout.String() != "line1\nline2\nline3\nline4\nline5\n" {
+		t.Fatalf("stdout = %q in %d chunks, want all lines flushed once on exit", stdout.String(), parts)
+	}
+}
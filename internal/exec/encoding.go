@@ -0,0 +1,86 @@
+package exec
+
+import (
+	"bytes"
+	"encoding/base64"
+	"unicode/utf8"
+)
+
+// EncodingBase64 表示分片输出为 Base64 编码的原始字节；为空表示 UTF-8 文本。
+const EncodingBase64 = "base64"
+
+// chunkEncoder 将单个输出流的字节转换为分片文本：
+//   - 文本输出在 UTF-8 字符边界处切分，跨读取边界的不完整字符留到下一块；
+//   - 一旦出现非法 UTF-8 或 NUL 字节即判定为二进制，该流此后的分片均以 Base64 编码回传；
+//   - 流结束（或截断）时仍不完整的字符不做替换，所在分片按二进制回传，保证字节无损。
+type chunkEncoder struct {
+	pending []byte
+	binary  bool
+}
+
+// encode 返回本次可回传的数据及其编码；data 为空时无需回传。flush 为 true 时不再保留不完整字符，
+// 此时残缺字符使整块按二进制回传。
+func (e *chunkEncoder) encode(p []byte, flush bool) (data, encoding string) {
+	buf := append(e.pending, p...)
+	e.pending = nil
+	if len(buf) == 0 {
+		return "", ""
+	}
+	if !e.binary {
+		if k := incompleteSuffix(buf); k > 0 && !flush {
+			e.pending = append([]byte(nil), buf[len(buf)-k:]...)
+			buf = buf[:len(buf)-k]
+		}
+		if isText(buf) {
+			return string(buf), ""
+		}
+		e.binary = true
+		buf = append(buf, e.pending...)
+		e.pending = nil
+	}
+	return base64.StdEncoding.EncodeToString(buf), EncodingBase64
+}
+
+// isText 判断 p 是否为不含 NUL 的合法 UTF-8。
+func isText(p []byte) bool {
+	return utf8.Valid(p) && bytes.IndexByte(p, 0) < 0
+}
+
+// incompleteSuffix 返回 p 末尾不完整 UTF-8 字符的字节数（0 表示以完整字符或非法字节结尾）。
+func incompleteSuffix(p []byte) int {
+	for i := len(p) - 1; i >= 0 && i >= len(p)-utf8.UTFMax; i-- {
+		if !utf8.RuneStart(p[i]) {
+			continue
+		}
+		if need := runeLen(p[i]); need > len(p)-i {
+			return len(p) - i
+		}
+		return 0
+	}
+	return 0
+}
+
+// runeLen 按首字节返回 UTF-8 字符的字节数，非法首字节返回 1。
+func runeLen(b byte) int {
+	switch {
+	case b < 0xC0:
+		return 1
+	case b < 0xE0:
+		return 2
+	case b < 0xF0:
+		return 3
+	case b < 0xF8:
+		return 4
+	default:
+		return 1
+	}
+}
+
+// skipContinuation 返回 p 开头 UTF-8 续字节的个数（至多 utf8.UTFMax-1），用于截断后从字符边界恢复。
+func skipContinuation(p []byte) int {
+	n := 0
+	for n < len(p) && n < utf8.UTFMax-1 && !utf8.RuneStart(p[n]) {
+		n++
+	}
+	return n
+}
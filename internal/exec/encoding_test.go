@@ -0,0 +1,100 @@
+package exec
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+func TestChunkEncoderSplitsOnRuneBoundaries(t *testing.T) {
+	var enc chunkEncoder
+	text := []byte("日本語")
+
+	data, encoding := enc.encode(text[:4], false)
+	if data != "日" || encoding != "" {
+		t.Fatalf("encode(partial) = %q/%q, want first rune only", data, encoding)
+	}
+	data, encoding = enc.encode(text[4:], false)
+	if data != "本語" || encoding != "" {
+		t.Fatalf("encode(rest) = %q/%q, want remaining runes", data, encoding)
+	}
+
+	data, encoding = enc.encode([]byte{0xE6, 0x97}, true)
+	if encoding != EncodingBase64 || data != base64.StdEncoding.EncodeToString([]byte{0xE6, 0x97}) {
+		t.Fatalf("encode(flush incomplete) = %q/%q, want base64 of raw bytes", data, encoding)
+	}
+}
+
+func TestChunkEncoderSwitchesToBinary(t *testing.T) {
+	var enc chunkEncoder
+	if _, encoding := enc.encode([]byte("plain "), false); encoding != "" {
+		t.Fatalf("text encoding = %q, want empty", encoding)
+	}
+	raw := []byte{'a', 0x00, 0xFF, 'b'}
+	data, encoding := enc.encode(raw, false)
+	if encoding != EncodingBase64 || data != base64.StdEncoding.EncodeToString(raw) {
+		t.Fatalf("encode(binary) = %q/%q", data, encoding)
+	}
+	if _, encoding := enc.encode([]byte("text again"), false); encoding != EncodingBase64 {
+		t.Fatalf("encoding after binary = %q, want sticky base64", encoding)
+	}
+}
+
+func TestRunStreamOutputIsLossless(t *testing.T) {
+	executor := ShellExecutor{Enabled: true}
+
+	// 4095 个 ASCII 字节后接多字节字符，使其跨越 4KB 读取边界。
+	var text, binary []byte
+	chunks := executor.RunStream(context.Background(), Spec{
+		Command: "head -c 4095 /dev/zero | tr '\\0' a; printf '€€€'; head -c 300 /dev/urandom >&2",
+		Timeout: 5 * time.Second,
+	})
+	for chunk := range chunks {
+		if chunk.StdoutChunk != "" {
+			if chunk.Encoding != "" || !utf8.ValidString(chunk.StdoutChunk) {
+				t.Fatalf("stdout chunk encoding = %q valid=%v, want utf-8 text", chunk.Encoding, utf8.ValidString(chunk.StdoutChunk))
+			}
+			text = append(text, chunk.StdoutChunk...)
+		}
+		if chunk.StderrChunk != "" {
+			if chunk.Encoding != EncodingBase64 {
+				t.Fatalf("stderr chunk encoding = %q, want base64", chunk.Encoding)
+			}
+			raw, err := base64.StdEncoding.DecodeString(chunk.StderrChunk)
+			if err != nil {
+				t.Fatalf("decode stderr chunk: %v", err)
+			}
+			binary = append(binary, raw...)
+		}
+	}
+	if want := strings.Repeat("a", 4095) + "€€€"; string(text) != want {
+		t.Fatalf("stdout = %d bytes, want %d", len(text), len(want))
+	}
+	if len(binary) != 300 {
+		t.Fatalf("stderr = %d bytes, want 300", len(binary))
+	}
+}
+
+func TestOutputCapAlignsToRuneBoundaries(t *testing.T) {
+	limit := newOutputCap(8, 4)
+	head := limit.take([]byte("ab€cd€ef€"))
+	tail, dropped := limit.finish()
+	if int64(len(head)+len(tail))+dropped != limit.total {
+		t.Fatalf("head+tail+dropped = %d, want total %d", int64(len(head)+len(tail))+dropped, limit.total)
+	}
+	if !bytes.Equal(head, []byte("ab")) || !utf8.Valid(tail) || string(tail) != "f€" {
+		t.Fatalf("head=%q tail=%q, want rune-aligned head and tail", head, tail)
+	}
+
+	// tail 恰好从多字节字符中间开始时，跳过残缺的续字节并计入丢弃。
+	limit = newOutputCap(4, 2)
+	limit.take([]byte("abcdefg€"))
+	tail, dropped = limit.finish()
+	if len(tail) != 0 || dropped != 8 {
+		t.Fatalf("tail=%q dropped=%d, want continuation bytes skipped", tail, dropped)
+	}
+}
@@ -0,0 +1,82 @@
+package exec
+
+import (
+	"os"
+	"os/exec"
+	"os/user"
+	"strconv"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// Execution 描述一次命令执行的元数据，在 cmd.Wait 之后由 ProcessState 采集，随最后一个分片回传：
+//   - StartedAt / EndedAt: 进程启动与回收的时间；
+//   - PID / WorkDir / User: 进程号、实际工作目录与运行用户；
+//   - Signal: 进程被信号终止时的信号名（如 SIGKILL），正常退出时为空；
+//   - UserCPU / SystemCPU / MaxRSSBytes / InBlocks / OutBlocks: rusage 中的 CPU 时间、峰值常驻内存与块 I/O 次数。
+type Execution struct {
+	StartedAt   time.Time
+	EndedAt     time.Time
+	PID         int
+	WorkDir     string
+	User        string
+	Signal      string
+	UserCPU     time.Duration
+	SystemCPU   time.Duration
+	MaxRSSBytes int64
+	InBlocks    int64
+	OutBlocks   int64
+}
+
+// Duration 返回进程从启动到回收的时长。
+func (e *Execution) Duration() time.Duration {
+	return e.EndedAt.Sub(e.StartedAt)
+}
+
+// collectExecution 在 cmd.Wait 返回后汇总执行元数据；ProcessState 为空（Wait 前失败）时只填写时间与身份。
+func collectExecution(cmd *exec.Cmd, startedAt, endedAt time.Time) *Execution {
+	e := &Execution{
+		StartedAt: startedAt,
+		EndedAt:   endedAt,
+		WorkDir:   cmd.Dir,
+		User:      runUser(cmd),
+	}
+	if e.WorkDir == "" {
+		e.WorkDir, _ = os.Getwd()
+	}
+	if cmd.Process != nil {
+		e.PID = cmd.Process.Pid
+	}
+	state := cmd.ProcessState
+	if state == nil {
+		return e
+	}
+	if status, ok := state.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+		e.Signal = unix.SignalName(status.Signal())
+		if e.Signal == "" {
+			e.Signal = "SIG" + strconv.Itoa(int(status.Signal()))
+		}
+	}
+	e.UserCPU, e.SystemCPU = state.UserTime(), state.SystemTime()
+	if usage, ok := state.SysUsage().(*syscall.Rusage); ok {
+		e.MaxRSSBytes = maxRSSBytes(usage)
+		e.InBlocks, e.OutBlocks = int64(usage.Inblock), int64(usage.Oublock)
+	}
+	return e
+}
+
+// runUser 返回子进程的运行用户名：指定了凭据时为该 UID 对应的用户，否则为 Agent 自身的用户。
+// 本地用户数据库中不存在时退回数字 UID。
+func runUser(cmd *exec.Cmd) string {
+	uid := os.Getuid()
+	if cmd.SysProcAttr != nil && cmd.SysProcAttr.Credential != nil {
+		uid = int(cmd.SysProcAttr.Credential.Uid)
+	}
+	id := strconv.Itoa(uid)
+	if u, err := user.LookupId(id); err == nil {
+		return u.Username
+	}
+	return id
+}
@@ -0,0 +1,8 @@
+package exec
+
+import "syscall"
+
+// maxRSSBytes 返回峰值常驻内存的字节数；macOS 上 ru_maxrss 的单位即为字节。
+func maxRSSBytes(usage *syscall.Rusage) int64 {
+	return int64(usage.Maxrss)
+}
@@ -0,0 +1,10 @@
+//go:build !darwin
+
+package exec
+
+import "syscall"
+
+// maxRSSBytes 返回峰值常驻内存的字节数；Linux 与 BSD 上 ru_maxrss 的单位为 KiB。
+func maxRSSBytes(usage *syscall.Rusage) int64 {
+	return int64(usage.Maxrss) * 1024
+}
@@ -0,0 +1,31 @@
+package exec
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestRunStreamReportsExecutionMetadata(t *testing.T) {
+	executor := ShellExecutor{Enabled: true}
+
+	_, final := runUntilFinal(t, executor, Spec{Command: "head -c 1000000 /dev/zero >/dev/null; sleep 0.05", WorkDir: "/", Timeout: 5 * time.Second})
+	e := final.Execution
+	if e == nil {
+		t.Fatal("final chunk has no execution metadata")
+	}
+	if e.PID <= 0 || e.WorkDir != "/" || e.User == "" || e.Signal != "" {
+		t.Fatalf("execution = %+v, want pid, workDir /, a user and no signal", e)
+	}
+	if e.Duration() < 50*time.Millisecond || e.EndedAt.Before(e.StartedAt) || e.MaxRSSBytes <= 0 {
+		t.Fatalf("execution = %+v, want a duration of at least 50ms and rusage", e)
+	}
+
+	_, final = runUntilFinal(t, executor, Spec{Command: "kill -9 $$", Timeout: 5 * time.Second})
+	if final.Execution == nil || final.Execution.Signal != "SIGKILL" {
+		t.Fatalf("execution = %+v, want the process reported as killed by SIGKILL", final.Execution)
+	}
+	if cwd, _ := os.Getwd(); final.Execution.WorkDir != cwd {
+		t.Fatalf("workDir = %q, want the agent's working directory %q", final.Execution.WorkDir, cwd)
+	}
+}
@@ -0,0 +1,131 @@
+package exec
+
+// 输出流名称，用于截断标记。
+const (
+	StreamStdout = "stdout"
+	StreamStderr = "stderr"
+)
+
+// OutputLimits 描述单个任务的输出上限，0 表示不限制：
+//   - MaxStdoutBytes / MaxStderrBytes：各流最多回传的字节数；
+//   - TailBytes：超出上限时保留的末尾字节数，至多为 Max 的一半。超限后流的前 (Max - TailBytes) 字节照常实时回传，
+//     中间部分被丢弃，末尾 TailBytes 字节在流结束时回传，丢弃位置由一个截断标记分片指明。
+type OutputLimits struct {
+	MaxStdoutBytes int64
+	MaxStderrBytes int64
+	TailBytes      int64
+}
+
+// Override 返回以 o 中非零字段覆盖 l 后的上限（任务级覆盖配置默认值）。
+func (l OutputLimits) Override(o OutputLimits) OutputLimits {
+	if o.MaxStdoutBytes > 0 {
+		l.MaxStdoutBytes = o.MaxStdoutBytes
+	}
+	if o.MaxStderrBytes > 0 {
+		l.MaxStderrBytes = o.MaxStderrBytes
+	}
+	if o.TailBytes > 0 {
+		l.TailBytes = o.TailBytes
+	}
+	return l
+}
+
+// Truncation 为截断标记：该分片之前已回传流的头部，之后为流的末尾，中间 DroppedBytes 字节被丢弃。
+type Truncation struct {
+	Stream       string
+	DroppedBytes int64
+}
+
+// outputCap 按 head/tail 策略裁剪单个输出流，并统计总字节数。
+type outputCap struct {
+	limited bool
+	head    int64 // 剩余可实时回传的字节数
+	tail    ringBuffer
+	total   int64
+	beyond  int64 // 超出 head 的字节数（含保留在 tail 中的部分）
+	skipped int64 // 丢弃后为对齐字符边界而额外跳过的 tail 开头字节数
+}
+
+func newOutputCap(max, tail int64) *outputCap {
+	if max <= 0 {
+		return &outputCap{}
+	}
+	if tail < 0 {
+		tail = 0
+	}
+	// 默认 tail（64 KiB）可能不小于任务覆盖的较小上限；限制为一半，保证头部仍有输出实时回传。
+	if tail > max/2 {
+		tail = max / 2
+	}
+	return &outputCap{limited: true, head: max - tail, tail: ringBuffer{buf: make([]byte, 0, tail), size: int(tail)}}
+}
+
+// take 记录读到的 p，返回应立即回传的部分；其余进入 tail 缓冲或被丢弃。
+func (c *outputCap) take(p []byte) []byte {
+	c.total += int64(len(p))
+	if !c.limited {
+		return p
+	}
+	n := int64(len(p))
+	if n >= c.head {
+		// head 用尽处若切在多字节字符中间，则把该字符整体划入末尾部分，使回传的头部止于字符边界。
+		n = c.head - int64(incompleteSuffix(p[:c.head]))
+		c.head = 0
+	} else {
+		c.head -= n
+	}
+	if rest := p[n:]; len(rest) > 0 {
+		c.beyond += int64(len(rest))
+		c.tail.write(rest)
+	}
+	return p[:n]
+}
+
+// finish 在流结束时返回待回传的末尾字节与被丢弃的字节数。
+// 有丢弃时 tail 开头残缺字符的续字节一并计入丢弃，使 tail 从字符边界开始。
+func (c *outputCap) finish() (tail []byte, dropped int64) {
+	tail = c.tail.bytes()
+	if c.beyond > int64(len(tail)) {
+		k := skipContinuation(tail)
+		c.skipped = int64(k)
+		tail = tail[k:]
+	}
+	return tail, c.dropped()
+}
+
+// dropped 返回已丢弃的字节数（finish 之后有效）。
+func (c *outputCap) dropped() int64 {
+	return c.beyond - int64(len(c.tail.buf)) + c.skipped
+}
+
+// ringBuffer 保留最近写入的 size 个字节。
+type ringBuffer struct {
+	buf   []byte
+	size  int
+	start int
+}
+
+func (r *ringBuffer) write(p []byte) {
+	if r.size == 0 {
+		return
+	}
+	if len(p) >= r.size {
+		r.buf = append(r.buf[:0], p[len(p)-r.size:]...)
+		r.start = 0
+		return
+	}
+	for _, b := range p {
+		if len(r.buf) < r.size {
+			r.buf = append(r.buf, b)
+			continue
+		}
+		r.buf[r.start] = b
+		r.start = (r.start + 1) % r.size
+	}
+}
+
+func (r *ringBuffer) bytes() []byte {
+	out := make([]byte, 0, len(r.buf))
+	out = append(out, r.buf[r.start:]...)
+	return append(out, r.buf[:r.start]...)
+}
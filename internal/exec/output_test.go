@@ -0,0 +1,83 @@
+package exec
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestOutputCapKeepsHeadAndTail(t *testing.T) {
+	limit := newOutputCap(10, 4)
+	var forwarded []byte
+	for _, part := range []string{"0123", "4567", "89abcdef", "ghij"} {
+		forwarded = append(forwarded, limit.take([]byte(part))...)
+	}
+	tail, dropped := limit.finish()
+	if string(forwarded) != "012345" || string(tail) != "ghij" || dropped != 10 || limit.total != 20 {
+		t.Fatalf("head=%q tail=%q dropped=%d total=%d, want 012345/ghij/10/20", forwarded, tail, dropped, limit.total)
+	}
+
+	unlimited := newOutputCap(0, 4)
+	if got := unlimited.take([]byte("everything")); string(got) != "everything" {
+		t.Fatalf("take(unlimited) = %q", got)
+	}
+	if tail, dropped := unlimited.finish(); len(tail) != 0 || dropped != 0 {
+		t.Fatalf("finish(unlimited) = %q, %d", tail, dropped)
+	}
+
+	// tail 不小于上限时被限制为一半，头部仍实时回传。
+	clamped := newOutputCap(10, 65536)
+	if got := clamped.take([]byte("0123456789ab")); string(got) != "01234" {
+		t.Fatalf("take(tail >= max) = %q, want 01234 forwarded live", got)
+	}
+	if tail, dropped := clamped.finish(); string(tail) != "789ab" || dropped != 2 {
+		t.Fatalf("finish(tail >= max) = %q, %d; want 789ab, 2", tail, dropped)
+	}
+
+	short := newOutputCap(10, 4)
+	short.take([]byte("01234567"))
+	if tail, dropped := short.finish(); string(tail) != "67" || dropped != 0 {
+		t.Fatalf("finish(within tail) = %q, %d; want all bytes kept", tail, dropped)
+	}
+}
+
+func TestRunStreamCapsOutput(t *testing.T) {
+	executor := ShellExecutor{Enabled: true, Output: OutputLimits{MaxStdoutBytes: 1 << 20, TailBytes: 100}}
+
+	var (
+		stdout      bytes.Buffer
+		markerAt    = -1
+		marker      *Truncation
+		final       Chunk
+		stdoutParts int
+	)
+	chunks := executor.RunStream(context.Background(), Spec{
+		Command: "head -c 100000 /dev/zero | tr '\\0' x; printf END; echo oops >&2",
+		Output:  OutputLimits{MaxStdoutBytes: 1000},
+		Timeout: 5 * time.Second,
+	})
+	for chunk := range chunks {
+		switch {
+		case chunk.Truncation != nil:
+			marker, markerAt = chunk.Truncation, stdout.Len()
+		case chunk.Final:
+			final = chunk
+		default:
+			stdout.WriteString(chunk.StdoutChunk)
+			stdoutParts++
+		}
+	}
+
+	if marker == nil || marker.Stream != StreamStdout || marker.DroppedBytes != 100003-1000 || markerAt != 900 {
+		t.Fatalf("marker = %+v at %d, want stdout gap of %d after 900 bytes", marker, markerAt, 100003-1000)
+	}
+	out := stdout.String()
+	if len(out) != 1000 || !strings.HasSuffix(out, "xEND") {
+		t.Fatalf("stdout len = %d suffix %q, want 1000 bytes ending in the tail", len(out), out[len(out)-4:])
+	}
+	if final.StdoutBytes != 100003 || final.StdoutDropped != 99003 || final.StderrBytes != 5 || final.StderrDropped != 0 {
+		t.Fatalf("final = %+v, want totals 100003/5 and 99003 dropped", final)
+	}
+}
@@ -0,0 +1,198 @@
+package exec
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"time"
+)
+
+// MaxRetryAttempts 为单个任务允许的最大尝试次数（含首次执行）。
+const MaxRetryAttempts = 10
+
+// retryMatchBytes 为每次尝试保留用于匹配 StderrPattern 的 stderr 字节数（保留开头部分）。
+const retryMatchBytes = 64 << 10
+
+// Retry 描述瞬时失败的自动重试策略：
+//   - MaxAttempts：总尝试次数（含首次），≤1 表示不重试；
+//   - Backoff / MaxBackoff：第一次重试前的等待时间，之后每次翻倍，MaxBackoff 为上限（0 表示不限制）；
+//   - ExitCodes：可重试的退出码（超时或被信号终止为 -1），为空时任何非 0 退出码均可重试；
+//   - StderrPattern：非空时 stderr（PTY 模式为终端输出）还须匹配该正则才重试。
+//
+// 只有进程实际运行过的失败才会重试，命令校验、启动失败等不会因重试而改变的错误直接结束。
+type Retry struct {
+	MaxAttempts   int
+	Backoff       time.Duration
+	MaxBackoff    time.Duration
+	ExitCodes     []int
+	StderrPattern string
+}
+
+// Enabled 报告是否配置了重试。
+func (r Retry) Enabled() bool {
+	return r.MaxAttempts > 1
+}
+
+func (r Retry) compile() (*regexp.Regexp, error) {
+	if r.MaxAttempts > MaxRetryAttempts {
+		return nil, fmt.Errorf("retry maxAttempts %d exceeds %d", r.MaxAttempts, MaxRetryAttempts)
+	}
+	if r.Backoff < 0 || r.MaxBackoff < 0 {
+		return nil, errors.New("retry backoff must not be negative")
+	}
+	if r.StderrPattern == "" {
+		return nil, nil
+	}
+	re, err := regexp.Compile(r.StderrPattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid retry stderrPattern: %w", err)
+	}
+	return re, nil
+}
+
+// delay 返回第 attempt 次尝试失败后、下一次尝试前的等待时间。
+func (r Retry) delay(attempt int) time.Duration {
+	d := r.Backoff
+	for i := 1; i < attempt && d > 0; i++ {
+		d *= 2
+		if r.MaxBackoff > 0 && d >= r.MaxBackoff {
+			break
+		}
+	}
+	if r.MaxBackoff > 0 && d > r.MaxBackoff {
+		d = r.MaxBackoff
+	}
+	return d
+}
+
+func (r Retry) retryable(final Chunk, output []byte, pattern *regexp.Regexp) bool {
+	if final.ExitCode == nil || *final.ExitCode == 0 || final.Execution == nil {
+		return false
+	}
+	if len(r.ExitCodes) > 0 {
+		matched := false
+		for _, code := range r.ExitCodes {
+			if code == *final.ExitCode {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	return pattern == nil || pattern.Match(output)
+}
+
+// RunWithRetry 按 retry 执行 spec：每次尝试调用一次 executor.RunStream，分片带 Attempt（从 1 开始），
+// Seq 在所有尝试间连续编号。将被重试的尝试以 Final=false、RetryAfter>0 的汇总分片结束（携带该次的
+// ExitCode 等结果），只有最后一次尝试的汇总分片 Final=true。各尝试的 Offset / Elapsed 各自从 0 开始。
+//
+// 重试时 spec.Stdin 须实现 io.Seeker（如内联 stdin），每次尝试前回到开头；流式 stdin 无法重放，不允许重试。
+// ctx 取消后不再开始新的尝试，以最近一次尝试的结果结束。
+func RunWithRetry(ctx context.Context, executor Executor, spec Spec, retry Retry) <-chan Chunk {
+	if !retry.Enabled() {
+		return executor.RunStream(ctx, spec)
+	}
+
+	ch := make(chan Chunk)
+	go func() {
+		defer close(ch)
+
+		pattern, err := retry.compile()
+		if err == nil && spec.Stdin != nil {
+			if _, ok := spec.Stdin.(io.Seeker); !ok {
+				err = errors.New("retry requires replayable stdin; streaming stdin cannot be retried")
+			}
+		}
+		if err != nil {
+			exitCode := -1
+			ch <- Chunk{Seq: 1, Attempt: 1, StderrChunk: err.Error(), ExitCode: &exitCode, Final: true}
+			return
+		}
+
+		seq := 0
+		for attempt := 1; ; attempt++ {
+			if seeker, ok := spec.Stdin.(io.Seeker); ok && attempt > 1 {
+				_, _ = seeker.Seek(0, io.SeekStart)
+			}
+
+			var (
+				final  Chunk
+				output []byte
+			)
+			for chunk := range executor.RunStream(ctx, spec) {
+				chunk.Attempt = attempt
+				if chunk.Final {
+					final = chunk
+					continue
+				}
+				if pattern != nil {
+					output = appendMatchOutput(output, chunk, spec.TTY != nil)
+				}
+				seq++
+				chunk.Seq = seq
+				ch <- chunk
+			}
+
+			last := attempt >= retry.MaxAttempts || !retry.retryable(final, output, pattern)
+			wait := retry.delay(attempt)
+			if !last && ctx.Err() != nil {
+				last = true
+			}
+			seq++
+			final.Seq = seq
+			if !last {
+				final.Final, final.RetryAfter = false, wait
+				if final.RetryAfter <= 0 {
+					// RetryAfter>0 标识将被重试的尝试，即使不等待也至少为 1ns。
+					final.RetryAfter = time.Nanosecond
+				}
+			}
+			ch <- final
+			if last {
+				return
+			}
+
+			timer := time.NewTimer(wait)
+			select {
+			case <-timer.C:
+			case <-ctx.Done():
+				timer.Stop()
+				// 等待期间被取消：已发出的汇总分片不是 Final，补发一个结束分片。
+				seq++
+				final.Seq, final.Final, final.RetryAfter = seq, true, 0
+				final.StdoutChunk, final.StderrChunk = "", ""
+				ch <- final
+				return
+			}
+		}
+	}()
+	return ch
+}
+
+// appendMatchOutput 累积用于匹配 StderrPattern 的输出（Base64 分片先解码），至多 retryMatchBytes 字节。
+func appendMatchOutput(buf []byte, chunk Chunk, tty bool) []byte {
+	data := chunk.StderrChunk
+	if tty {
+		data = chunk.StdoutChunk
+	}
+	if data == "" || len(buf) >= retryMatchBytes {
+		return buf
+	}
+	p := []byte(data)
+	if chunk.Encoding == EncodingBase64 {
+		decoded, err := base64.StdEncoding.DecodeString(data)
+		if err != nil {
+			return buf
+		}
+		p = decoded
+	}
+	if room := retryMatchBytes - len(buf); len(p) > room {
+		p = p[:room]
+	}
+	return append(buf, p...)
+}
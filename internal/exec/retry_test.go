@@ -0,0 +1,116 @@
+package exec
+
+import (
+	"context"
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func collectRetry(t *testing.T, spec Spec, retry Retry) []Chunk {
+	t.Helper()
+	executor := ShellExecutor{Enabled: true}
+	var chunks []Chunk
+	for chunk := range RunWithRetry(context.Background(), executor, spec, retry) {
+		chunks = append(chunks, chunk)
+	}
+	if len(chunks) == 0 || !chunks[len(chunks)-1].Final {
+		t.Fatalf("chunks = %+v, want a final chunk", chunks)
+	}
+	for i, chunk := range chunks {
+		if chunk.Seq != i+1 {
+			t.Fatalf("chunk %d seq = %d, want contiguous seq across attempts", i, chunk.Seq)
+		}
+	}
+	return chunks
+}
+
+func TestRunWithRetryRetriesUntilSuccess(t *testing.T) {
+	counter := filepath.Join(t.TempDir(), "attempts")
+	chunks := collectRetry(t, Spec{
+		// 前两次以 75 退出，第三次成功。
+		Command: "n=$(cat " + counter + " 2>/dev/null || echo 0); n=$((n+1)); echo $n > " + counter +
+			"; echo attempt$n; [ $n -ge 3 ] || exit 75",
+		Stdin:   strings.NewReader("in"),
+		Timeout: 5 * time.Second,
+	}, Retry{MaxAttempts: 5, Backoff: 10 * time.Millisecond, ExitCodes: []int{75}})
+
+	var ends []Chunk
+	for _, chunk := range chunks {
+		if chunk.StdoutChunk != "" && chunk.StdoutChunk != "attempt"+string(rune('0'+chunk.Attempt))+"\n" {
+			t.Fatalf("attempt %d stdout = %q", chunk.Attempt, chunk.StdoutChunk)
+		}
+		if chunk.ExitCode != nil {
+			ends = append(ends, chunk)
+		}
+	}
+	if len(ends) != 3 {
+		t.Fatalf("attempt summaries = %d, want 3", len(ends))
+	}
+	for i, end := range ends[:2] {
+		if end.Attempt != i+1 || end.Final || *end.ExitCode != 75 || end.RetryAfter != 10*time.Millisecond<<i || end.Execution == nil {
+			t.Fatalf("attempt %d summary = %+v, want non-final exit 75 with backoff", i+1, end)
+		}
+	}
+	if last := ends[2]; last.Attempt != 3 || !last.Final || *last.ExitCode != 0 || last.RetryAfter != 0 {
+		t.Fatalf("final summary = %+v, want attempt 3 succeeding", last)
+	}
+}
+
+func TestRunWithRetryStopsOnNonRetryableFailure(t *testing.T) {
+	cases := []struct {
+		name  string
+		retry Retry
+	}{
+		{"exit code", Retry{MaxAttempts: 3, ExitCodes: []int{75}}},
+		{"stderr pattern", Retry{MaxAttempts: 3, StderrPattern: "Connection reset"}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			chunks := collectRetry(t, Spec{Command: "echo 'disk full' >&2; exit 1", Timeout: 5 * time.Second}, tc.retry)
+			final := chunks[len(chunks)-1]
+			if final.Attempt != 1 || *final.ExitCode != 1 {
+				t.Fatalf("final = %+v, want a single attempt", final)
+			}
+		})
+	}
+
+	chunks := collectRetry(t, Spec{Command: "echo 'read: Connection reset by peer' >&2; exit 1", Timeout: 5 * time.Second},
+		Retry{MaxAttempts: 2, StderrPattern: "Connection reset"})
+	if final := chunks[len(chunks)-1]; final.Attempt != 2 || *final.ExitCode != 1 {
+		t.Fatalf("final = %+v, want the matching failure retried up to maxAttempts", final)
+	}
+}
+
+func TestRunWithRetryRejectsInvalidPolicy(t *testing.T) {
+	stream := struct{ io.Reader }{strings.NewReader("x")}
+	cases := []struct {
+		name  string
+		spec  Spec
+		retry Retry
+	}{
+		{"too many attempts", Spec{Command: "true"}, Retry{MaxAttempts: MaxRetryAttempts + 1}},
+		{"bad pattern", Spec{Command: "true"}, Retry{MaxAttempts: 2, StderrPattern: "("}},
+		{"streaming stdin", Spec{Command: "cat", Stdin: stream}, Retry{MaxAttempts: 2}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			chunks := collectRetry(t, tc.spec, tc.retry)
+			if len(chunks) != 1 || *chunks[0].ExitCode != -1 || chunks[0].StderrChunk == "" {
+				t.Fatalf("chunks = %+v, want a single error chunk", chunks)
+			}
+		})
+	}
+}
+
+func TestRetryDelayDoublesUpToMax(t *testing.T) {
+	r := Retry{Backoff: 100 * time.Millisecond, MaxBackoff: 350 * time.Millisecond}
+	want := []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 350 * time.Millisecond, 350 * time.Millisecond}
+	for i, w := range want {
+		if got := r.delay(i + 1); got != w {
+			t.Fatalf("delay(%d) = %v, want %v", i+1, got, w)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package exec
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"devops-agent/internal/proc"
+)
+
+// defaultInterpreter 为 Script.Interpreter 为空时使用的解释器。
+const defaultInterpreter = "sh"
+
+// DefaultInterpreters 为未配置 ShellExecutor.Interpreters 时允许的脚本解释器。
+var DefaultInterpreters = []string{"sh", "bash", "python3"}
+
+// resolveInterpreter 校验解释器在白名单内并解析为绝对路径：
+// 白名单中的裸名称只在受控搜索路径中查找，绝对路径须与白名单条目完全一致。
+func resolveInterpreter(name string, allowed []string, execPath string) (string, error) {
+	if name == "" {
+		name = defaultInterpreter
+	}
+	if len(allowed) == 0 {
+		allowed = DefaultInterpreters
+	}
+	if filepath.IsAbs(name) {
+		name = filepath.Clean(name)
+	}
+	for _, entry := range allowed {
+		if entry == name || (filepath.IsAbs(entry) && filepath.Clean(entry) == name) {
+			return resolveExecutable(name, execPath)
+		}
+	}
+	return "", fmt.Errorf("interpreter %q is not allowed", name)
+}
+
+// verifyScript 校验脚本内容与 sha256 摘要一致。
+func verifyScript(script *Script) error {
+	want, err := hex.DecodeString(strings.TrimSpace(script.SHA256))
+	if err != nil || len(want) != sha256.Size {
+		return fmt.Errorf("invalid script sha256 %q", script.SHA256)
+	}
+	got := sha256.Sum256(script.Body)
+	if subtle.ConstantTimeCompare(got[:], want) != 1 {
+		return fmt.Errorf("script sha256 mismatch: got %s", hex.EncodeToString(got[:]))
+	}
+	return nil
+}
+
+// writeScript 将脚本写入仅属主可访问的临时目录（目录 0700、文件 0400），返回脚本路径与清理函数。
+// identity 非空时将目录与文件属主改为该身份，使切换身份后的解释器仍能读取脚本。
+func writeScript(script *Script, identity *proc.Identity) (string, func(), error) {
+	dir, err := os.MkdirTemp("", "devops-agent-script-")
+	if err != nil {
+		return "", nil, fmt.Errorf("create script dir: %w", err)
+	}
+	cleanup := func() { _ = os.RemoveAll(dir) }
+
+	path := filepath.Join(dir, "script")
+	if err := os.WriteFile(path, script.Body, 0o400); err != nil {
+		cleanup()
+		return "", nil, fmt.Errorf("write script: %w", err)
+	}
+	if identity != nil && os.Geteuid() == 0 {
+		for _, p := range []string{dir, path} {
+			if err := os.Chown(p, int(identity.UID), int(identity.GID)); err != nil {
+				cleanup()
+				return "", nil, fmt.Errorf("chown script: %w", err)
+			}
+		}
+	}
+	return path, cleanup, nil
+}
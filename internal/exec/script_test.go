@@ -0,0 +1,66 @@
+package exec
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newScript(body, interpreter string, args ...string) *Script {
+	sum := sha256.Sum256([]byte(body))
+	return &Script{Body: []byte(body), Interpreter: interpreter, Args: args, SHA256: hex.EncodeToString(sum[:])}
+}
+
+func TestRunStreamExecutesScriptAndRemovesFile(t *testing.T) {
+	executor := ShellExecutor{Enabled: true}
+	script := newScript("echo \"$0\"\nstat -c %a \"$0\"\necho \"args: $1 $2\"\n", "sh", "one", "two words")
+
+	var stdout string
+	var final Chunk
+	for chunk := range executor.RunStream(context.Background(), Spec{Script: script, Timeout: 5 * time.Second}) {
+		stdout += chunk.StdoutChunk
+		if chunk.Final {
+			final = chunk
+		}
+	}
+	if final.ExitCode == nil || *final.ExitCode != 0 {
+		t.Fatalf("final chunk = %+v, stdout = %q; want success", final, stdout)
+	}
+	lines := strings.Split(strings.TrimSpace(stdout), "\n")
+	if len(lines) != 3 || lines[1] != "400" || lines[2] != "args: one two words" {
+		t.Fatalf("stdout = %q, want script path, mode 400 and args", stdout)
+	}
+	if _, err := os.Stat(lines[0]); !os.IsNotExist(err) {
+		t.Fatalf("script file %s still exists after run: %v", lines[0], err)
+	}
+}
+
+func TestRunRejectsInvalidScripts(t *testing.T) {
+	executor := ShellExecutor{Enabled: true, Interpreters: []string{"sh", "/bin/bash"}}
+
+	tampered := newScript("echo ok", "")
+	tampered.Body = []byte("echo pwned")
+	cases := map[string]Spec{
+		"checksum mismatch":   {Script: tampered},
+		"missing checksum":    {Script: &Script{Body: []byte("echo ok")}},
+		"interpreter denied":  {Script: newScript("print(1)", "python3")},
+		"relative path":       {Script: newScript("echo ok", "bin/sh")},
+		"mixed with command":  {Command: "true", Script: newScript("echo ok", "sh")},
+		"absolute not listed": {Script: newScript("echo ok", "/usr/bin/env")},
+	}
+	for name, spec := range cases {
+		res, err := executor.Run(context.Background(), spec)
+		if err != nil || res.ExitCode != -1 || res.Stdout != "" {
+			t.Errorf("%s: Run() = %#v, %v; want rejection", name, res, err)
+		}
+	}
+
+	res, _ := executor.Run(context.Background(), Spec{Script: newScript("echo $BASH_VERSION | cut -c1", "/bin/bash")})
+	if res.ExitCode != 0 || strings.TrimSpace(res.Stdout) == "" {
+		t.Fatalf("Run(/bin/bash) = %#v, want bash to run the script", res)
+	}
+}
@@ -12,6 +12,9 @@ import (
 	"sync/atomic"
 	"syscall"
 	"time"
+
+	"devops-agent/internal/proc"
+	"devops-agent/internal/redact"
 )
 
 // Result 描述一次命令执行的最小结果，用于封装到 result.chunk 中（汇总视角）。
@@ -19,45 +22,123 @@ type Result struct {
 	ExitCode int
 	Stdout   string
 	Stderr   string
+	// LimitExceeded 为导致进程被终止的资源限制（proc.LimitMemory 等），未触发时为空。
+	LimitExceeded string
+	// LimitMode / UnenforcedLimits 为资源限制的实际施加方式（"cgroup" / "rlimit"，未设置限制时为空）
+	// 及设置了但未能施加的限制（proc.LimitCPUQuota 等）。
+	LimitMode        string
+	UnenforcedLimits []string
+	// Timeline 按读取顺序记录 stdout / stderr 的各段输出（流、偏移与相对启动的时间）。
+	Timeline []Segment
 }
 
 // Chunk 描述流式执行过程中的单个结果分片，用于与 WS result.chunk 对齐。
 //
-// - Seq: 分片序号，从 1 开始递增；
-// - StdoutChunk / StderrChunk: 本分片携带的 stdout/stderr 文本（固定大小块）；
-// - ExitCode: 仅在 Final=true 的最后一个分片中设置退出码，其余分片为 nil；
-// - Final: 是否为最后一个分片。
+//   - Seq: 分片序号，从 1 开始递增；
+//   - StdoutChunk / StderrChunk: 本分片携带的 stdout/stderr 文本（固定大小块）；
+//   - Stream / Offset: 输出分片与截断标记所属的流（StreamStdout / StreamStderr）及首字节在该流原始输出中的
+//     字节偏移（截断标记为丢弃开始处；启用遮蔽时针对遮蔽后的输出），据此可逐字节重建各流；
+//     Stream 为空表示 Agent 生成的消息；
+//   - Elapsed: 相对进程启动的单调时间，输出分片为首字节被读到的时间，据此可合并出 stdout/stderr 的时间线；
+//   - ExitCode: 仅在 Final=true 的最后一个分片中设置退出码，其余分片为 nil；
+//   - Encoding: 本分片输出的编码，空为 UTF-8 文本，EncodingBase64 为 Base64 编码的原始字节；
+//   - Truncation: 截断标记分片（不携带输出），表示对应流在此处丢弃了部分输出，之后为流的末尾；
+//   - LimitExceeded: 仅在最后一个分片中设置，标明触发的资源限制；
+//   - LimitMode / UnenforcedLimits: 仅在最后一个分片中设置，资源限制的实际施加方式（"cgroup" / "rlimit"）
+//     及设置了但未能施加的限制（cgroup v2 不可用时的 cpuQuota / pids）；
+//   - StdoutBytes / StderrBytes: 仅在最后一个分片中设置，各流实际产生的总字节数；
+//   - StdoutDropped / StderrDropped: 仅在最后一个分片中设置，各流因输出上限被丢弃的字节数；
+//   - TimedOut / TerminationSignal / Escalated: 仅在最后一个分片中设置，命令是否超时、超时后最后发送的信号，
+//     以及首个信号的宽限期过后是否升级到了 SIGKILL；
+//   - Redactions: 仅在最后一个分片中设置，两个流中被遮蔽的密钥个数；
+//   - Execution: 仅在最后一个分片中设置（进程成功启动时），执行元数据与 rusage，见 Execution；
+//   - Attempt / RetryAfter: 由 RunWithRetry 设置，分片所属的尝试序号（从 1 开始），以及将被重试的尝试的
+//     汇总分片（Final=false，携带上述仅在最后一个分片中设置的字段）距离下一次尝试的等待时间；
+//   - Final: 是否为最后一个分片。
 type Chunk struct {
-	Seq         int
-	StdoutChunk string
-	StderrChunk string
-	ExitCode    *int
-	Final       bool
+	Seq               int
+	Stream            string
+	Offset            int64
+	Elapsed           time.Duration
+	StdoutChunk       string
+	StderrChunk       string
+	Encoding          string
+	Truncation        *Truncation
+	ExitCode          *int
+	LimitExceeded     string
+	LimitMode         string
+	UnenforcedLimits  []string
+	StdoutBytes       int64
+	StderrBytes       int64
+	StdoutDropped     int64
+	StderrDropped     int64
+	TimedOut          bool
+	TerminationSignal string
+	Escalated         bool
+	Redactions        int
+	Execution         *Execution
+	Attempt           int
+	RetryAfter        time.Duration
+	Final             bool
 }
 
 // Executor 定义命令执行接口，便于后续扩展不同执行策略（本地 shell、容器、沙箱等）。
 //
-// workDir 指定命令的工作目录：
+// Spec.WorkDir 指定命令的工作目录：
 //   - 空字符串：使用 Executor 实现的默认工作目录（ShellExecutor 为进程当前目录）；
 //   - 支持以 "~" 开头的 $HOME 展开；
 //   - 相对路径以进程当前目录为基准解析为绝对路径。
 //
 // Run 返回汇总结果；RunStream 以流式方式返回结果分片，直到通道被关闭。
+// Resolve 返回 spec 实际生效的可执行文件、工作目录与运行用户，供执行前的策略评估使用。
 type Executor interface {
-	Run(ctx context.Context, command, workDir string, timeout time.Duration) (Result, error)
-	RunStream(ctx context.Context, command, workDir string, timeout time.Duration) <-chan Chunk
+	Run(ctx context.Context, spec Spec) (Result, error)
+	RunStream(ctx context.Context, spec Spec) <-chan Chunk
+	Resolve(spec Spec) (Resolved, error)
+}
+
+// Resolved 描述命令实际执行时的身份与位置，与 Executor 构造子进程时的解析结果一致：
+//   - Executable: argv 模式为解析后的可执行文件，脚本模式为解释器（均为绝对路径），shell 模式为空；
+//   - WorkDir: 展开 "~"、解析相对路径后的绝对工作目录，未指定时为 Agent 进程当前目录；
+//   - User: RunAs 解析出的用户名（数字 UID 也会解析为用户名），未指定 RunAs 时为空。
+type Resolved struct {
+	Executable string
+	WorkDir    string
+	User       string
 }
 
 // ShellExecutor 是一个最小实现：
 // - 当 Enabled=false 时，不真正执行命令，而是返回占位结果；
-// - 当 Enabled=true 时，使用本地 shell (sh -c) 执行命令。
+// - 当 Enabled=true 时，shell 模式使用本地 shell (sh -c) 执行命令，argv 模式直接 exec。
 //
 // DefaultWorkDir 为每次命令执行的默认工作目录，当调用方未传入 workDir 时使用。
+// ExecPath 为 argv 模式的受控搜索路径，为空时使用 DefaultExecPath。
+// Env 为子进程环境策略：过滤继承的 Agent 环境（始终清除 AGENT_*），并校验任务下发的变量。
+// Users 为 Spec.RunAs 可请求的运行身份白名单。
+// Limits 为默认资源限制（可被 Spec.Limits 逐项覆盖），由 Limiter 通过 cgroup v2 或 rlimit 施加。
+// Interpreters 为脚本模式允许的解释器（名称或绝对路径），为空时使用 DefaultInterpreters。
+// Output 为 RunStream 的默认输出上限（可被 Spec.Output 逐项覆盖）。
+// FlushInterval / MinChunkBytes 控制 RunStream 的分片合并：缓冲达到 MinChunkBytes 或首个未发送字节
+// 等待满 FlushInterval 时发送，两者均为 0 时每次读取立即发送；输出流结束时总是立即发送。
+// Termination 为 RunStream 超时后的默认终止策略（可被 Spec.Termination 逐项覆盖）。
+// Redactor 在 RunStream 回传输出前遮蔽其中的密钥（在输出上限与偏移统计之前），为 nil 时不遮蔽。
+// 命令的允许 / 拒绝策略在执行前由 policy 包评估，不在 Executor 内处理。
 //
-// TODO: 后续按安全策略接入白名单、沙箱、资源限制等能力。
+// TODO: 后续按安全策略接入沙箱等能力。
 type ShellExecutor struct {
 	Enabled        bool
 	DefaultWorkDir string
+	ExecPath       string
+	Env            proc.EnvPolicy
+	Users          proc.UserPolicy
+	Limits         proc.Limits
+	Limiter        proc.Limiter
+	Interpreters   []string
+	Output         OutputLimits
+	FlushInterval  time.Duration
+	MinChunkBytes  int
+	Termination    Termination
+	Redactor       *redact.Redactor
 }
 
 // resolveWorkDir 将给定 workDir 解析为可用于 cmd.Dir 的绝对路径：
@@ -102,41 +183,163 @@ func resolveWorkDir(override, fallback string) (string, error) {
 	return abs, nil
 }
 
-func (s ShellExecutor) Run(ctx context.Context, command, workDir string, timeout time.Duration) (Result, error) {
+// Resolve 按与 command 相同的规则解析 spec 的可执行文件、工作目录与运行用户，不启动进程。
+func (s ShellExecutor) Resolve(spec Spec) (Resolved, error) {
+	if err := spec.validate(); err != nil {
+		return Resolved{}, err
+	}
+	dir, err := resolveWorkDir(spec.WorkDir, s.DefaultWorkDir)
+	if err != nil {
+		return Resolved{}, err
+	}
+	if dir == "" {
+		if dir, err = os.Getwd(); err != nil {
+			return Resolved{}, fmt.Errorf("resolve workDir: %w", err)
+		}
+	}
+	executable, err := s.executable(spec)
+	if err != nil {
+		return Resolved{}, err
+	}
+	resolved := Resolved{Executable: executable, WorkDir: dir}
+	if spec.RunAs != nil {
+		identity, err := s.Users.Resolve(*spec.RunAs)
+		if err != nil {
+			return Resolved{}, err
+		}
+		resolved.User = identity.Username
+	}
+	return resolved, nil
+}
+
+// executable 返回 argv 模式的可执行文件或脚本模式的解释器（经 ExecPath / Interpreters 解析），shell 模式返回空。
+func (s ShellExecutor) executable(spec Spec) (string, error) {
+	switch {
+	case spec.Script != nil:
+		return resolveInterpreter(spec.Script.Interpreter, s.Interpreters, s.ExecPath)
+	case spec.IsArgv():
+		name := spec.Executable
+		if name == "" {
+			name = spec.Argv[0]
+		}
+		return resolveExecutable(name, s.ExecPath)
+	default:
+		return "", nil
+	}
+}
+
+// command 按 spec 构造 *exec.Cmd：shell 模式为 `sh -c`，argv 模式经受控路径解析后直接执行，
+// 脚本模式校验摘要后写入临时文件并由解释器执行；
+// 指定 RunAs 时按 Users 白名单解析身份，并将 HOME / USER / LOGNAME 设为该身份的值。
+// 返回的 cleanup 须在命令结束后调用（删除脚本临时文件），出错时已自行清理。
+func (s ShellExecutor) command(ctx context.Context, spec Spec) (cmd *exec.Cmd, cleanup func(), err error) {
+	cleanup = func() {}
+	if err := spec.validate(); err != nil {
+		return nil, nil, err
+	}
+	if err := s.Termination.Override(spec.Termination).Validate(); err != nil {
+		return nil, nil, err
+	}
+	resolvedDir, err := resolveWorkDir(spec.WorkDir, s.DefaultWorkDir)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	extraEnv := proc.EnvList(spec.Env)
+	var identity *proc.Identity
+	if spec.RunAs != nil {
+		if identity, err = s.Users.Resolve(*spec.RunAs); err != nil {
+			return nil, nil, err
+		}
+		extraEnv = append(extraEnv, identity.Env()...)
+	}
+	env, err := s.Env.Environ(extraEnv)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	path, err := s.executable(spec)
+	if err != nil {
+		return nil, nil, err
+	}
+	switch {
+	case spec.Script != nil:
+		if err := verifyScript(spec.Script); err != nil {
+			return nil, nil, err
+		}
+		script, remove, err := writeScript(spec.Script, identity)
+		if err != nil {
+			return nil, nil, err
+		}
+		cleanup = remove
+		cmd = exec.CommandContext(ctx, path, append([]string{script}, spec.Script.Args...)...)
+	case spec.IsArgv():
+		cmd = exec.CommandContext(ctx, path)
+		cmd.Args = append([]string(nil), spec.Argv...)
+	default:
+		cmd = exec.CommandContext(ctx, "sh", "-c", spec.Command)
+	}
+	cmd.Dir = resolvedDir
+	cmd.Env = env
+	if identity != nil {
+		identity.Apply(cmd)
+	}
+	return cmd, cleanup, nil
+}
+
+func (s ShellExecutor) Run(ctx context.Context, spec Spec) (Result, error) {
 	if !s.Enabled {
 		// 默认占位逻辑，避免误执行命令。
 		return Result{
 			ExitCode: 0,
-			Stdout:   fmt.Sprintf("[shell disabled] would run: %s", command),
+			Stdout:   fmt.Sprintf("[shell disabled] would run: %s", spec.Display()),
 			Stderr:   "",
 		}, nil
 	}
 
+	if spec.TTY != nil {
+		return Result{ExitCode: -1, Stderr: "tty mode is only supported by RunStream"}, nil
+	}
+
+	timeout := spec.Timeout
 	if timeout <= 0 {
 		timeout = 30 * time.Second
 	}
 
-	resolvedDir, err := resolveWorkDir(workDir, s.DefaultWorkDir)
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	cmd, cleanup, err := s.command(ctx, spec)
 	if err != nil {
 		return Result{ExitCode: -1, Stderr: err.Error()}, nil
 	}
+	defer cleanup()
+	var stdout, stderr strings.Builder
+	output := &timeline{startedAt: time.Now()}
+	cmd.Stdout = output.writer(StreamStdout, &stdout)
+	cmd.Stderr = output.writer(StreamStderr, &stderr)
+	limits, err := s.start(cmd, spec)
+	if err != nil {
+		return Result{ExitCode: -1, Stderr: err.Error()}, nil
+	}
+	defer limits.Close()
 
-	ctx, cancel := context.WithTimeout(ctx, timeout)
-	defer cancel()
-
-	cmd := exec.CommandContext(ctx, "sh", "-c", command)
-	cmd.Dir = resolvedDir
-	out, err := cmd.Output()
+	err = cmd.Wait()
+	releaseStdin(spec)
 	res := Result{
-		ExitCode: 0,
-		Stdout:   string(out),
-		Stderr:   "",
+		ExitCode:         0,
+		Stdout:           stdout.String(),
+		Stderr:           "",
+		LimitExceeded:    limits.Exceeded(cmd.ProcessState, ctx.Err() != nil),
+		LimitMode:        limits.Mode(),
+		UnenforcedLimits: limits.Unenforced(),
+		Timeline:         output.segments,
 	}
 	if err != nil {
 		// 尝试从 ExitError 中取退出码。
 		if exitErr, ok := err.(*exec.ExitError); ok {
 			res.ExitCode = exitErr.ExitCode()
-			res.Stderr = string(exitErr.Stderr)
+			res.Stderr = stderr.String()
 		} else {
 			res.ExitCode = -1
 			res.Stderr = err.Error()
@@ -145,18 +348,86 @@ func (s ShellExecutor) Run(ctx context.Context, command, workDir string, timeout
 	return res, nil
 }
 
+// start 按合并后的资源限制启动 cmd，并把 spec.Stdin 拷贝到子进程的标准输入。
+func (s ShellExecutor) start(cmd *exec.Cmd, spec Spec) (*proc.Enforcement, error) {
+	var stdin io.WriteCloser
+	if spec.Stdin != nil {
+		pipe, err := cmd.StdinPipe()
+		if err != nil {
+			return nil, fmt.Errorf("stdin pipe error: %w", err)
+		}
+		stdin = pipe
+	}
+	limits, err := s.launch(cmd, spec, cmd.Start)
+	if err != nil {
+		return nil, err
+	}
+	if stdin != nil {
+		// 自行拷贝而不是直接设置 cmd.Stdin：后者会让 Wait 一直等待尚未结束的 stdin 流。
+		go func() {
+			_, _ = io.Copy(stdin, spec.Stdin)
+			_ = stdin.Close()
+		}()
+	}
+	return limits, nil
+}
+
+// startPiped 在新进程组中启动 cmd（确保超时后可以杀死整个进程树，包括子进程），
+// 返回其 stdout / stderr 管道。
+func (s ShellExecutor) startPiped(cmd *exec.Cmd, spec Spec) (limits *proc.Enforcement, stdout, stderr io.Reader, err error) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Setpgid = true
+
+	if stdout, err = cmd.StdoutPipe(); err != nil {
+		return nil, nil, nil, fmt.Errorf("stdout pipe error: %w", err)
+	}
+	if stderr, err = cmd.StderrPipe(); err != nil {
+		return nil, nil, nil, fmt.Errorf("stderr pipe error: %w", err)
+	}
+	if limits, err = s.start(cmd, spec); err != nil {
+		return nil, nil, nil, err
+	}
+	return limits, stdout, stderr, nil
+}
+
+// launch 按合并后的资源限制调用 startFn 启动 cmd；限制在 exec 之前即已生效。
+func (s ShellExecutor) launch(cmd *exec.Cmd, spec Spec, startFn func() error) (*proc.Enforcement, error) {
+	limits, err := s.Limiter.Prepare(cmd, s.Limits.Override(spec.Limits))
+	if err != nil {
+		return nil, fmt.Errorf("apply resource limits: %w", err)
+	}
+	if err := startFn(); err != nil {
+		limits.Close()
+		return nil, fmt.Errorf("start command error: %w", err)
+	}
+	limits.Started()
+	return limits, nil
+}
+
+// releaseStdin 在命令结束后关闭可关闭的 Spec.Stdin，使阻塞在读取上的拷贝 goroutine 退出。
+func releaseStdin(spec Spec) {
+	if closer, ok := spec.Stdin.(io.Closer); ok {
+		_ = closer.Close()
+	}
+}
+
 // RunStream 以流式方式执行命令，并通过只读通道返回 Chunk 序列。
 //
 // 行为约定：
 //   - Enabled=false 时：返回单个占位 Chunk（Seq=1, Final=true, ExitCode=0）；
-//   - Enabled=true 时：按固定大小块（默认 4KB）读取 stdout/stderr，分别生成 Chunk；
-//     命令结束后追加最后一个 Final=true 的 Chunk，并携带 ExitCode。
+//   - Enabled=true 时：按固定大小块（默认 4KB）读取 stdout/stderr，分别生成 Chunk
+//     （文本在 UTF-8 字符边界处切分，非文本以 Base64 编码，见 Chunk.Encoding）；
+//     命令结束后追加最后一个 Final=true 的 Chunk，并携带 ExitCode；
+//   - Spec.TTY 非空时命令在伪终端中运行，终端输出全部作为 stdout 流回传，超时与退出码语义不变。
 //
 // 超时处理：
 //   - 使用独立的 timer 控制超时，不依赖 parent ctx；
-//   - 超时后强制杀死整个进程组（避免 sh 子进程泄漏）；
+//   - 超时后按 Termination 先向整个进程组发送首个信号（默认 SIGTERM），宽限期内输出仍未结束
+//     再发送 SIGKILL（避免 sh 子进程泄漏），最后一个分片携带 TimedOut / TerminationSignal / Escalated；
 //   - 保证 final chunk 一定会被发送，通道一定会被关闭。
-func (s ShellExecutor) RunStream(ctx context.Context, command, workDir string, timeout time.Duration) <-chan Chunk {
+func (s ShellExecutor) RunStream(ctx context.Context, spec Spec) <-chan Chunk {
 	ch := make(chan Chunk)
 
 	go func() {
@@ -167,18 +438,24 @@ func (s ShellExecutor) RunStream(ctx context.Context, command, workDir string, t
 			exitCode := 0
 			ch <- Chunk{
 				Seq:         1,
-				StdoutChunk: fmt.Sprintf("[shell disabled] would run: %s", command),
+				StdoutChunk: fmt.Sprintf("[shell disabled] would run: %s", spec.Display()),
 				ExitCode:    &exitCode,
 				Final:       true,
 			}
 			return
 		}
 
+		timeout := spec.Timeout
 		if timeout <= 0 {
 			timeout = 30 * time.Second
 		}
 
-		resolvedDir, err := resolveWorkDir(workDir, s.DefaultWorkDir)
+		// 使用独立 context，避免 parent ctx 取消直接影响命令生命周期；超时由 terminator 处理，
+		// 不能交给 CommandContext，否则 os/exec 会在超时瞬间直接 SIGKILL。
+		cmdCtx, cmdCancel := context.WithCancel(context.Background())
+		defer cmdCancel()
+
+		cmd, cleanup, err := s.command(cmdCtx, spec)
 		if err != nil {
 			exitCode := -1
 			ch <- Chunk{
@@ -189,93 +466,59 @@ func (s ShellExecutor) RunStream(ctx context.Context, command, workDir string, t
 			}
 			return
 		}
+		defer cleanup()
 
-		// 使用独立 context，避免 parent ctx 取消直接影响命令生命周期。
-		cmdCtx, cmdCancel := context.WithTimeout(context.Background(), timeout)
-		defer cmdCancel()
-
-		cmd := exec.CommandContext(cmdCtx, "sh", "-c", command)
-		cmd.Dir = resolvedDir
-
-		// 创建新进程组，确保超时后可以杀死整个进程树（包括子进程）。
-		cmd.SysProcAttr = &syscall.SysProcAttr{
-			Setpgid: true,
-		}
-
-		stdout, err := cmd.StdoutPipe()
-		if err != nil {
-			ch <- Chunk{Seq: 1, StderrChunk: fmt.Sprintf("stdout pipe error: %v", err), Final: true}
-			return
+		startedAt := time.Now()
+		var (
+			stdout, stderr io.Reader
+			enforcement    *proc.Enforcement
+		)
+		if spec.TTY != nil {
+			// PTY 模式：stdout / stderr 合并为终端输出，全部作为 stdout 流回传。
+			var ptmx io.ReadCloser
+			if enforcement, ptmx, err = s.startTTY(cmd, spec); err == nil {
+				defer ptmx.Close()
+				stdout, stderr = ptmx, strings.NewReader("")
+			}
+		} else {
+			enforcement, stdout, stderr, err = s.startPiped(cmd, spec)
 		}
-		stderr, err := cmd.StderrPipe()
 		if err != nil {
-			ch <- Chunk{Seq: 1, StderrChunk: fmt.Sprintf("stderr pipe error: %v", err), Final: true}
+			ch <- Chunk{Seq: 1, StderrChunk: err.Error(), Final: true}
 			return
 		}
+		defer enforcement.Close()
 
-		if err := cmd.Start(); err != nil {
-			ch <- Chunk{Seq: 1, StderrChunk: fmt.Sprintf("start command error: %v", err), Final: true}
-			return
-		}
-
-		// 超时后强制杀死进程组。
-		timer := time.NewTimer(timeout)
-		defer timer.Stop()
-		go func() {
-			<-timer.C
-			if cmd.Process != nil {
-				// 杀死进程组（负 PID 表示进程组）。
-				_ = syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
-			}
-		}()
+		// 超时后逐级终止进程组。
+		term := newTerminator(cmd.Process.Pid, s.Termination.Override(spec.Termination))
+		defer term.finish()
+		go term.watch(timeout)
 
 		var (
 			seq int32
 			wg  sync.WaitGroup
 		)
+		emit := func(chunk Chunk) {
+			chunk.Seq = int(atomic.AddInt32(&seq, 1))
+			if chunk.Elapsed == 0 {
+				chunk.Elapsed = time.Since(startedAt)
+			}
+			ch <- chunk
+		}
+		limits := s.Output.Override(spec.Output)
+		stdoutCap := newOutputCap(limits.MaxStdoutBytes, limits.TailBytes)
+		stderrCap := newOutputCap(limits.MaxStderrBytes, limits.TailBytes)
+		stdoutFilter, stderrFilter := s.Redactor.NewStream(), s.Redactor.NewStream()
 
-		// 按固定大小块读取 stdout。
-		wg.Add(1)
+		// 按固定大小块读取 stdout / stderr，超出上限的部分按 head/tail 策略裁剪。
+		wg.Add(2)
 		go func() {
 			defer wg.Done()
-			buf := make([]byte, 4096)
-			for {
-				n, err := stdout.Read(buf)
-				if n > 0 {
-					chunk := string(buf[:n])
-					seqN := int(atomic.AddInt32(&seq, 1))
-					ch <- Chunk{Seq: seqN, StdoutChunk: chunk}
-				}
-				if err != nil {
-					if err != io.EOF {
-						seqN := int(atomic.AddInt32(&seq, 1))
-						ch <- Chunk{Seq: seqN, StderrChunk: fmt.Sprintf("stdout read error: %v", err)}
-					}
-					break
-				}
-			}
+			s.pumpOutput(StreamStdout, stdout, stdoutFilter, stdoutCap, startedAt, emit)
 		}()
-
-		// 按固定大小块读取 stderr。
-		wg.Add(1)
 		go func() {
 			defer wg.Done()
-			buf := make([]byte, 4096)
-			for {
-				n, err := stderr.Read(buf)
-				if n > 0 {
-					chunk := string(buf[:n])
-					seqN := int(atomic.AddInt32(&seq, 1))
-					ch <- Chunk{Seq: seqN, StderrChunk: chunk}
-				}
-				if err != nil {
-					if err != io.EOF {
-						seqN := int(atomic.AddInt32(&seq, 1))
-						ch <- Chunk{Seq: seqN, StderrChunk: fmt.Sprintf("stderr read error: %v", err)}
-					}
-					break
-				}
-			}
+			s.pumpOutput(StreamStderr, stderr, stderrFilter, stderrCap, startedAt, emit)
 		}()
 
 		// 等待所有输出读取完毕。
@@ -283,18 +526,142 @@ func (s ShellExecutor) RunStream(ctx context.Context, command, workDir string, t
 
 		// 获取退出码并发送最后一个分片。
 		exitCode := 0
-		if err := cmd.Wait(); err != nil {
+		err = cmd.Wait()
+		endedAt := time.Now()
+		timedOut, signal, escalated := term.finish()
+		releaseStdin(spec)
+		if err != nil {
 			if exitErr, ok := err.(*exec.ExitError); ok {
 				exitCode = exitErr.ExitCode()
 			} else {
-				// 包含上下文超时在内的其他错误，统一视为 -1。
+				// 其他错误统一视为 -1。
 				exitCode = -1
 			}
 		}
 
-		finalSeq := int(atomic.AddInt32(&seq, 1))
-		ch <- Chunk{Seq: finalSeq, ExitCode: &exitCode, Final: true}
+		stdoutDropped, stderrDropped := stdoutCap.dropped(), stderrCap.dropped()
+		emit(Chunk{
+			ExitCode:          &exitCode,
+			LimitExceeded:     enforcement.Exceeded(cmd.ProcessState, timedOut),
+			LimitMode:         enforcement.Mode(),
+			UnenforcedLimits:  enforcement.Unenforced(),
+			StdoutBytes:       stdoutCap.total,
+			StderrBytes:       stderrCap.total,
+			StdoutDropped:     stdoutDropped,
+			StderrDropped:     stderrDropped,
+			TimedOut:          timedOut,
+			TerminationSignal: signal,
+			Escalated:         escalated,
+			Redactions:        stdoutFilter.Total() + stderrFilter.Total(),
+			Execution:         collectExecution(cmd, startedAt, endedAt),
+			Final:             true,
+		})
 	}()
 
 	return ch
 }
+
+// redactIdleFlush 为遮蔽过滤器保留未完成的一行后、流上无新输出时回传这部分内容的等待时间，
+// 保证进度条、交互提示等不以换行结束的输出在命令结束前也能回传。
+const redactIdleFlush = 25 * time.Millisecond
+
+// pumpOutput 按固定大小块读取单个输出流：上限内的字节经合并后回传，流结束时立即发送缓冲内容；
+// 若有丢弃，先发出截断标记分片再回传保留的末尾字节。分片按 UTF-8 字符边界切分，非文本输出以 Base64 回传。
+// 每个分片携带流名、首字节在该流原始输出中的偏移，以及首字节相对 startedAt 的读取时间。
+// 读到的字节先经 filter 遮蔽密钥（未完成的一行暂留在 filter 中，空闲 redactIdleFlush 或流结束时取出），
+// 再进入输出上限。
+func (s ShellExecutor) pumpOutput(stream string, r io.Reader, filter *redact.Stream, limit *outputCap, startedAt time.Time, emit func(Chunk)) {
+	const chunkSize = 4096
+	var (
+		enc    chunkEncoder
+		offset int64 // 已回传（含编码器暂存之前）的原始字节在流中的位置
+
+		// filterMu 保护 filter 与 limit：空闲回传在定时器 goroutine 中进行。
+		filterMu  sync.Mutex
+		idleFlush *time.Timer
+		done      bool
+	)
+	send := func(p []byte, at time.Time, flush bool) {
+		held := len(enc.pending)
+		data, encoding := enc.encode(p, flush)
+		if data != "" {
+			chunk := streamChunk(stream, data, encoding)
+			chunk.Offset, chunk.Elapsed = offset, at.Sub(startedAt)
+			emit(chunk)
+		}
+		offset += int64(held + len(p) - len(enc.pending))
+	}
+	batch := newCoalescer(s.FlushInterval, s.MinChunkBytes, send)
+	forward := func(p []byte) {
+		if kept := limit.take(p); len(kept) > 0 {
+			batch.write(kept, time.Now())
+		}
+	}
+	flushHeld := func() {
+		filterMu.Lock()
+		defer filterMu.Unlock()
+		if done {
+			return
+		}
+		if rest, _ := filter.Flush(); len(rest) > 0 {
+			forward(rest)
+		}
+	}
+
+	buf := make([]byte, chunkSize)
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			filterMu.Lock()
+			redacted, _ := filter.Write(buf[:n])
+			forward(redacted)
+			if filter.Pending() {
+				if idleFlush == nil {
+					idleFlush = time.AfterFunc(redactIdleFlush, flushHeld)
+				} else {
+					idleFlush.Reset(redactIdleFlush)
+				}
+			}
+			filterMu.Unlock()
+		}
+		if err != nil {
+			flushHeld()
+			filterMu.Lock()
+			done = true
+			filterMu.Unlock()
+			if idleFlush != nil {
+				idleFlush.Stop()
+			}
+			if err != io.EOF {
+				batch.flush(false)
+				emit(Chunk{StderrChunk: fmt.Sprintf("%s read error: %v", stream, err)})
+			}
+			break
+		}
+	}
+
+	tail, dropped := limit.finish()
+	if dropped > 0 {
+		batch.flush(true)
+		emit(Chunk{Stream: stream, Offset: offset, Truncation: &Truncation{Stream: stream, DroppedBytes: dropped}})
+	}
+	// 末尾字节紧接在被丢弃部分之后，偏移从流总长减去末尾长度处继续。
+	offset = limit.total - int64(len(tail))
+	finishedAt := time.Now()
+	for len(tail) > 0 {
+		n := len(tail)
+		if n > chunkSize {
+			n = chunkSize
+		}
+		send(tail[:n], finishedAt, false)
+		tail = tail[n:]
+	}
+	batch.flush(true)
+}
+
+func streamChunk(stream, data, encoding string) Chunk {
+	if stream == StreamStderr {
+		return Chunk{Stream: stream, StderrChunk: data, Encoding: encoding}
+	}
+	return Chunk{Stream: stream, StdoutChunk: data, Encoding: encoding}
+}
@@ -0,0 +1,252 @@
+package exec
+
+import (
+	"context"
+	"io"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"devops-agent/internal/proc"
+	"devops-agent/internal/redact"
+)
+
+func TestRunArgvBypassesShell(t *testing.T) {
+	executor := ShellExecutor{Enabled: true}
+
+	res, err := executor.Run(context.Background(), Spec{Argv: []string{"echo", "$HOME", "a;b"}, Timeout: 5 * time.Second})
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if res.ExitCode != 0 || res.Stdout != "$HOME a;b\n" {
+		t.Fatalf("Run() = %#v, want literal arguments echoed", res)
+	}
+}
+
+func TestRunArgvResolvesThroughExecPath(t *testing.T) {
+	dir := t.TempDir()
+	script := filepath.Join(dir, "hello-tool")
+	if err := os.WriteFile(script, []byte("#!/bin/sh\necho \"$0 $1\"\n"), 0o755); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	executor := ShellExecutor{Enabled: true, ExecPath: dir}
+
+	res, err := executor.Run(context.Background(), Spec{Argv: []string{"hello-tool", "world"}})
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if res.ExitCode != 0 || !strings.HasSuffix(res.Stdout, "hello-tool world\n") {
+		t.Fatalf("Run() = %#v, want script output", res)
+	}
+
+	res, _ = executor.Run(context.Background(), Spec{Argv: []string{"echo", "hi"}})
+	if res.ExitCode != -1 || !strings.Contains(res.Stderr, "not found in exec path") {
+		t.Fatalf("Run(outside exec path) = %#v, want lookup failure", res)
+	}
+}
+
+func TestRunRejectsInvalidSpecs(t *testing.T) {
+	executor := ShellExecutor{Enabled: true}
+
+	for name, spec := range map[string]Spec{
+		"both modes":    {Command: "true", Argv: []string{"true"}},
+		"relative path": {Argv: []string{"./bin/tool"}},
+		"empty":         {},
+	} {
+		res, _ := executor.Run(context.Background(), spec)
+		if res.ExitCode != -1 || res.Stderr == "" {
+			t.Fatalf("Run(%s) = %#v, want rejection", name, res)
+		}
+	}
+}
+
+func TestRunStreamArgvReportsExitCode(t *testing.T) {
+	executor := ShellExecutor{Enabled: true}
+
+	var final Chunk
+	for chunk := range executor.RunStream(context.Background(), Spec{Executable: "/bin/sh", Argv: []string{"sh", "-c", "exit 3"}}) {
+		if chunk.Final {
+			final = chunk
+		}
+	}
+	if final.ExitCode == nil || *final.ExitCode != 3 {
+		t.Fatalf("final chunk = %#v, want exit code 3", final)
+	}
+}
+
+func TestRunAppliesEnvPolicy(t *testing.T) {
+	t.Setenv("AGENT_AUTH__TOKEN", "secret")
+	t.Setenv("DEVOPS_AGENT_TEST_INHERITED", "kept")
+	executor := ShellExecutor{Enabled: true, Env: proc.EnvPolicy{Inherit: []string{"PATH", "DEVOPS_AGENT_TEST_*"}}}
+
+	res, err := executor.Run(context.Background(), Spec{
+		Command: `echo "$TASK_VAR|$DEVOPS_AGENT_TEST_INHERITED|$AGENT_AUTH__TOKEN"`,
+		Env:     map[string]string{"TASK_VAR": "task"},
+	})
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if res.Stdout != "task|kept|\n" {
+		t.Fatalf("Run() stdout = %q, want task variable and inherited allowlist only", res.Stdout)
+	}
+
+	res, _ = executor.Run(context.Background(), Spec{Command: "true", Env: map[string]string{"AGENT_KEYS__DIR": "/tmp"}})
+	if res.ExitCode != -1 || !strings.Contains(res.Stderr, "denied") {
+		t.Fatalf("Run(AGENT_ env) = %#v, want policy rejection", res)
+	}
+}
+
+func TestRunDoesNotLeakAgentEnvWhenAllowlistMatchesNothing(t *testing.T) {
+	t.Setenv("AGENT_AUTH__TOKEN", "secret")
+	executor := ShellExecutor{Enabled: true, Env: proc.EnvPolicy{Inherit: []string{"NOPE_*"}}}
+
+	res, err := executor.Run(context.Background(), Spec{Argv: []string{"/usr/bin/env"}, Timeout: 5 * time.Second})
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if res.ExitCode != 0 || strings.Contains(res.Stdout, "AGENT_") {
+		t.Fatalf("Run() = %#v, want an environment without AGENT_ variables", res)
+	}
+}
+
+func TestRunAsSwitchesIdentity(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("requires root to switch identity")
+	}
+	if _, err := user.Lookup("nobody"); err != nil {
+		t.Skip("user nobody not available")
+	}
+	executor := ShellExecutor{Enabled: true, Users: proc.UserPolicy{AllowedUsers: []string{"nobody"}}}
+
+	res, err := executor.Run(context.Background(), Spec{Command: `echo "$(id -un) $USER"`, WorkDir: "/", RunAs: &proc.RunAs{User: "nobody"}})
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if res.ExitCode != 0 || res.Stdout != "nobody nobody\n" {
+		t.Fatalf("Run() = %#v, want to run as nobody", res)
+	}
+
+	res, _ = executor.Run(context.Background(), Spec{Command: "true", RunAs: &proc.RunAs{User: "root"}})
+	if res.ExitCode != -1 || !strings.Contains(res.Stderr, "not allowed") {
+		t.Fatalf("Run(root) = %#v, want runAs denial", res)
+	}
+}
+
+func TestRunStreamReportsCPUSecondsLimit(t *testing.T) {
+	executor := ShellExecutor{
+		Enabled: true,
+		Limits:  proc.Limits{CPUSeconds: 60},
+		Limiter: proc.Limiter{CgroupRoot: filepath.Join(t.TempDir(), "no-cgroup")},
+	}
+
+	var final Chunk
+	for chunk := range executor.RunStream(context.Background(), Spec{
+		Command: "while :; do :; done",
+		Limits:  proc.Limits{CPUSeconds: 1},
+		Timeout: 10 * time.Second,
+	}) {
+		if chunk.Final {
+			final = chunk
+		}
+	}
+	if final.LimitExceeded != proc.LimitCPUSeconds || final.ExitCode == nil || *final.ExitCode == 0 {
+		t.Fatalf("final chunk = %+v, want cpuSeconds limit exceeded", final)
+	}
+}
+
+func TestRunStreamTimeoutIsNotReportedAsCPUSecondsLimit(t *testing.T) {
+	executor := ShellExecutor{
+		Enabled: true,
+		Limiter: proc.Limiter{CgroupRoot: filepath.Join(t.TempDir(), "no-cgroup")},
+	}
+
+	// 忽略 SIGXCPU 后硬上限（2s）才会 SIGKILL；超时先到，CPU 时间此时已超过软上限。
+	var final Chunk
+	for chunk := range executor.RunStream(context.Background(), Spec{
+		Command: "trap '' XCPU; while :; do :; done",
+		Limits:  proc.Limits{CPUSeconds: 1},
+		Timeout: 1500 * time.Millisecond,
+	}) {
+		if chunk.Final {
+			final = chunk
+		}
+	}
+	if !final.TimedOut || final.LimitExceeded != "" {
+		t.Fatalf("final chunk = %+v, want timeout without limitExceeded", final)
+	}
+}
+
+func TestRunStreamReportsUnenforcedLimitsWithoutCgroup(t *testing.T) {
+	executor := ShellExecutor{
+		Enabled: true,
+		Limiter: proc.Limiter{CgroupRoot: filepath.Join(t.TempDir(), "no-cgroup")},
+	}
+
+	var final Chunk
+	for chunk := range executor.RunStream(context.Background(), Spec{
+		Command: "true",
+		Limits:  proc.Limits{Pids: 16, NoFile: 64},
+		Timeout: 5 * time.Second,
+	}) {
+		if chunk.Final {
+			final = chunk
+		}
+	}
+	if final.LimitMode != "rlimit" || len(final.UnenforcedLimits) != 1 || final.UnenforcedLimits[0] != proc.LimitPids {
+		t.Fatalf("final chunk = %+v, want rlimit mode with pids unenforced", final)
+	}
+}
+
+func TestRunStreamRedactsSecrets(t *testing.T) {
+	redactor, err := redact.New(redact.Options{Builtins: true})
+	if err != nil {
+		t.Fatalf("redact.New: %v", err)
+	}
+	redactor.SetValues(redact.SourceServer, []string{"pushed-secret-42"})
+	executor := ShellExecutor{Enabled: true, Redactor: redactor}
+
+	stdout, final := runUntilFinal(t, executor, Spec{
+		Command: "echo API_TOKEN=abcdef123456; printf 'value pushed-secret-42'",
+		Timeout: 5 * time.Second,
+	})
+	if stdout != "API_TOKEN=[REDACTED]\nvalue [REDACTED]" || final.Redactions != 2 {
+		t.Fatalf("stdout = %q redactions = %d, want both secrets masked", stdout, final.Redactions)
+	}
+}
+
+func TestRunStreamFlushesHeldPartialLineBeforeExit(t *testing.T) {
+	redactor, err := redact.New(redact.Options{Builtins: true})
+	if err != nil {
+		t.Fatalf("redact.New: %v", err)
+	}
+	executor := ShellExecutor{Enabled: true, Redactor: redactor}
+	stdinR, stdinW := io.Pipe()
+	defer stdinW.Close()
+
+	// 提示不以换行结束，命令等待 stdin 关闭才退出：只有空闲回传能让提示在退出前到达。
+	var (
+		stdout    string
+		sawPrompt bool
+		final     Chunk
+	)
+	for chunk := range executor.RunStream(context.Background(), Spec{
+		Command: "printf 'continue? '; cat >/dev/null",
+		Stdin:   stdinR,
+		Timeout: 3 * time.Second,
+	}) {
+		stdout += chunk.StdoutChunk
+		if !sawPrompt && stdout == "continue? " {
+			sawPrompt = true
+			_ = stdinW.Close()
+		}
+		if chunk.Final {
+			final = chunk
+		}
+	}
+	if !sawPrompt || final.TimedOut {
+		t.Fatalf("stdout = %q timedOut = %v, want the partial line before exit", stdout, final.TimedOut)
+	}
+}
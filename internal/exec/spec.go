@@ -0,0 +1,156 @@
+package exec
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"devops-agent/internal/proc"
+)
+
+// DefaultExecPath 为 argv 模式解析可执行文件时使用的受控搜索路径（不读取 Agent 进程自身的 PATH）。
+const DefaultExecPath = "/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin"
+
+// Spec 描述一次命令执行。
+//
+// 三种模式互斥：
+//   - shell 模式（默认）：Command 非空，经 `sh -c` 执行；
+//   - argv 模式：Argv 非空，不经过 shell，直接 exec。可执行文件为 Executable（为空时取 Argv[0]），
+//     含 "/" 时必须为绝对路径，否则只在受控搜索路径中查找；Argv[0] 原样作为子进程的 argv[0]；
+//   - 脚本模式：Script 非空，校验 sha256 后写入私有临时文件，由白名单内的解释器执行，结束后删除。
+type Spec struct {
+	Command    string
+	Argv       []string
+	Executable string
+	Script     *Script
+	// WorkDir 语义见 Executor。
+	WorkDir string
+	// Env 为任务级环境变量，按 Executor 的环境策略叠加在继承的环境之上。
+	Env map[string]string
+	// RunAs 非空时以指定身份运行，须在 Executor 的身份白名单内。
+	RunAs *proc.RunAs
+	// Limits 为任务级资源限制，非零字段覆盖 Executor 的默认限制。
+	Limits proc.Limits
+	// Stdin 为子进程的标准输入，为空时子进程读到 /dev/null；实现 io.Closer 时在命令结束后被关闭
+	// （见 StdinStream），以释放仍在等待数据的读取。
+	Stdin io.Reader
+	// Output 为任务级输出上限，非零字段覆盖 Executor 的默认上限。
+	Output OutputLimits
+	// Termination 为任务级超时终止策略，非零字段覆盖 Executor 的默认策略。
+	Termination Termination
+	// TTY 非空时在伪终端中运行（仅 RunStream 支持），见 TTY。
+	TTY     *TTY
+	Timeout time.Duration
+}
+
+// Script 描述脚本任务：Body 为脚本内容，SHA256 为其十六进制摘要（必填），
+// Interpreter 为解释器名称或绝对路径（为空时为 sh），Args 为传给脚本的参数。
+type Script struct {
+	Body        []byte
+	Interpreter string
+	Args        []string
+	SHA256      string
+}
+
+// IsArgv 报告是否为 argv 模式。
+func (s Spec) IsArgv() bool {
+	return len(s.Argv) > 0
+}
+
+// Display 返回用于日志与占位输出的命令文本。
+func (s Spec) Display() string {
+	if s.Script != nil {
+		interpreter := s.Script.Interpreter
+		if interpreter == "" {
+			interpreter = defaultInterpreter
+		}
+		return quoteArgs(append([]string{interpreter, "<script sha256:" + s.Script.SHA256 + ">"}, s.Script.Args...))
+	}
+	if !s.IsArgv() {
+		return s.Command
+	}
+	return quoteArgs(s.Argv)
+}
+
+func quoteArgs(args []string) string {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		if arg == "" || strings.ContainsAny(arg, " \t\n'\"\\$`") {
+			arg = "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
+		}
+		quoted[i] = arg
+	}
+	return strings.Join(quoted, " ")
+}
+
+func (s Spec) validate() error {
+	if s.TTY != nil {
+		if err := s.TTY.validate(); err != nil {
+			return err
+		}
+	}
+	if s.Script != nil {
+		if s.IsArgv() || strings.TrimSpace(s.Command) != "" || s.Executable != "" {
+			return errors.New("script is mutually exclusive with command and argv")
+		}
+		if s.Script.SHA256 == "" {
+			return errors.New("script sha256 is required")
+		}
+		return nil
+	}
+	if s.IsArgv() && strings.TrimSpace(s.Command) != "" {
+		return errors.New("command and argv are mutually exclusive")
+	}
+	if !s.IsArgv() && strings.TrimSpace(s.Command) == "" {
+		return errors.New("empty command")
+	}
+	if !s.IsArgv() && s.Executable != "" {
+		return errors.New("executable requires argv")
+	}
+	return nil
+}
+
+// resolveExecutable 在受控搜索路径 execPath（冒号分隔）中解析 argv 模式的可执行文件。
+func resolveExecutable(name, execPath string) (string, error) {
+	if name == "" {
+		return "", errors.New("empty executable")
+	}
+	if strings.Contains(name, "/") {
+		if !filepath.IsAbs(name) {
+			return "", fmt.Errorf("executable %q must be an absolute path or a bare name", name)
+		}
+		if err := checkExecutable(name); err != nil {
+			return "", err
+		}
+		return filepath.Clean(name), nil
+	}
+
+	if strings.TrimSpace(execPath) == "" {
+		execPath = DefaultExecPath
+	}
+	for _, dir := range filepath.SplitList(execPath) {
+		if !filepath.IsAbs(dir) {
+			continue // 相对目录会随 workDir 变化，忽略
+		}
+		candidate := filepath.Join(dir, name)
+		if checkExecutable(candidate) == nil {
+			return candidate, nil
+		}
+	}
+	return "", fmt.Errorf("executable %q not found in exec path %s", name, execPath)
+}
+
+func checkExecutable(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("stat executable: %w", err)
+	}
+	if info.IsDir() || info.Mode().Perm()&0o111 == 0 {
+		return fmt.Errorf("%s is not an executable file", path)
+	}
+	return nil
+}
@@ -0,0 +1,150 @@
+package exec
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// DefaultStdinBuffer 为 StdinStream 默认的缓冲上限（字节）。
+const DefaultStdinBuffer = 1 << 20
+
+var (
+	// ErrStdinBufferFull 表示缓冲已满；该 seq 未被接收，调用方可稍后重发。
+	ErrStdinBufferFull = errors.New("stdin buffer full")
+	// ErrStdinClosed 表示 stdin 已关闭（已收到关闭或命令已结束）。
+	ErrStdinClosed = errors.New("stdin closed")
+)
+
+// StdinStream 为命令的流式 stdin，作为 Spec.Stdin 传给 Executor：
+//   - 写入方按 seq（从 1 开始）调用 Write / CloseWrite，乱序到达的分片会被暂存并按 seq 顺序交付；
+//     CloseWrite 同样占用一个 seq，之前的分片全部交付后子进程读到 EOF；
+//   - 已交付与暂存的数据总量不超过 limit，超出时 Write 返回 ErrStdinBufferFull 且不消耗该 seq；
+//   - 重复的 seq 被忽略；
+//   - Executor 在命令结束后调用 Close，阻塞的读取立即返回，之后的写入返回 ErrStdinClosed。
+type StdinStream struct {
+	mu       sync.Mutex
+	cond     *sync.Cond
+	limit    int
+	next     int
+	pending  map[int][]byte
+	ready    [][]byte
+	buffered int
+	closeSeq int
+	eof      bool
+	closed   bool
+}
+
+// NewStdinStream 创建流式 stdin，initial 为推送中携带的内联数据（先于所有分片交付）。
+// limit <= 0 时使用 DefaultStdinBuffer。
+func NewStdinStream(initial []byte, limit int) *StdinStream {
+	if limit <= 0 {
+		limit = DefaultStdinBuffer
+	}
+	s := &StdinStream{limit: limit, next: 1, pending: map[int][]byte{}}
+	s.cond = sync.NewCond(&s.mu)
+	if len(initial) > 0 {
+		s.ready = append(s.ready, initial)
+		s.buffered = len(initial)
+	}
+	return s
+}
+
+// Write 接收序号为 seq 的分片。
+func (s *StdinStream) Write(seq int, data []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed || s.eof || (s.closeSeq > 0 && seq >= s.closeSeq) {
+		return ErrStdinClosed
+	}
+	if seq < 1 {
+		return fmt.Errorf("invalid stdin seq %d", seq)
+	}
+	if _, dup := s.pending[seq]; dup || seq < s.next {
+		return nil
+	}
+	if s.buffered+len(data) > s.limit {
+		return ErrStdinBufferFull
+	}
+	s.pending[seq] = append([]byte(nil), data...)
+	s.buffered += len(data)
+	s.advance()
+	return nil
+}
+
+// CloseWrite 标记 seq 为流的结束位置。
+func (s *StdinStream) CloseWrite(seq int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return ErrStdinClosed
+	}
+	if seq < s.next {
+		return fmt.Errorf("stdin close seq %d already consumed", seq)
+	}
+	for pendingSeq := range s.pending {
+		if pendingSeq >= seq {
+			return fmt.Errorf("stdin close seq %d precedes received seq %d", seq, pendingSeq)
+		}
+	}
+	s.closeSeq = seq
+	s.advance()
+	return nil
+}
+
+// advance 将连续的暂存分片移入待读取队列；调用方持有锁。
+func (s *StdinStream) advance() {
+	for {
+		if s.closeSeq > 0 && s.next == s.closeSeq {
+			s.eof = true
+			break
+		}
+		data, ok := s.pending[s.next]
+		if !ok {
+			break
+		}
+		delete(s.pending, s.next)
+		s.next++
+		if len(data) > 0 {
+			s.ready = append(s.ready, data)
+		}
+	}
+	s.cond.Broadcast()
+}
+
+// Read 实现 io.Reader，阻塞直到有数据、流结束或被 Close。
+func (s *StdinStream) Read(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for len(s.ready) == 0 && !s.eof && !s.closed {
+		s.cond.Wait()
+	}
+	if s.closed {
+		return 0, io.ErrClosedPipe
+	}
+	if len(s.ready) == 0 {
+		return 0, io.EOF
+	}
+	n := copy(p, s.ready[0])
+	if n == len(s.ready[0]) {
+		s.ready = s.ready[1:]
+	} else {
+		s.ready[0] = s.ready[0][n:]
+	}
+	s.buffered -= n
+	return n, nil
+}
+
+// Close 结束读取端并丢弃未交付的数据。
+func (s *StdinStream) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	s.ready, s.pending, s.buffered = nil, nil, 0
+	s.cond.Broadcast()
+	return nil
+}
@@ -0,0 +1,111 @@
+package exec
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestStdinStreamOrdersBySeqAndBoundsBuffer(t *testing.T) {
+	stream := NewStdinStream([]byte("head:"), 16)
+
+	if err := stream.Write(2, []byte("two,")); err != nil {
+		t.Fatalf("Write(2) error = %v", err)
+	}
+	if err := stream.Write(3, []byte("three-and-more")); !errors.Is(err, ErrStdinBufferFull) {
+		t.Fatalf("Write(3) error = %v, want %v", err, ErrStdinBufferFull)
+	}
+	if err := stream.CloseWrite(4); err != nil {
+		t.Fatalf("CloseWrite(4) error = %v", err)
+	}
+	if err := stream.Write(1, []byte("one,")); err != nil {
+		t.Fatalf("Write(1) error = %v", err)
+	}
+	if err := stream.Write(1, []byte("dup")); err != nil {
+		t.Fatalf("Write(duplicate) error = %v", err)
+	}
+
+	buf := make([]byte, 9)
+	if n, err := io.ReadFull(stream, buf); err != nil || string(buf[:n]) != "head:one," {
+		t.Fatalf("Read() = %q, %v; want %q", buf[:n], err, "head:one,")
+	}
+	// 读取释放缓冲后，被拒绝的 seq 可以重发。
+	if err := stream.Write(3, []byte("three")); err != nil {
+		t.Fatalf("Write(3) retry error = %v", err)
+	}
+	if err := stream.Write(4, []byte("late")); !errors.Is(err, ErrStdinClosed) {
+		t.Fatalf("Write(4) error = %v, want %v", err, ErrStdinClosed)
+	}
+	rest, err := io.ReadAll(stream)
+	if err != nil || string(rest) != "two,three" {
+		t.Fatalf("ReadAll() = %q, %v; want %q", rest, err, "two,three")
+	}
+}
+
+func TestStdinStreamCloseUnblocksReader(t *testing.T) {
+	stream := NewStdinStream(nil, 0)
+	done := make(chan error, 1)
+	go func() {
+		_, err := stream.Read(make([]byte, 1))
+		done <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+	_ = stream.Close()
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Fatalf("Read() after Close error = nil")
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Read() still blocked after Close")
+	}
+	if err := stream.Write(1, []byte("x")); !errors.Is(err, ErrStdinClosed) {
+		t.Fatalf("Write() after Close error = %v, want %v", err, ErrStdinClosed)
+	}
+}
+
+func TestRunStreamPipesStdin(t *testing.T) {
+	executor := ShellExecutor{Enabled: true}
+	stream := NewStdinStream([]byte("b\n"), 0)
+
+	chunks := executor.RunStream(context.Background(), Spec{Command: "sort", Stdin: stream, Timeout: 5 * time.Second})
+	_ = stream.Write(2, []byte("a\n"))
+	_ = stream.Write(1, []byte("c\n"))
+	_ = stream.CloseWrite(3)
+
+	var stdout string
+	var final Chunk
+	for chunk := range chunks {
+		stdout += chunk.StdoutChunk
+		if chunk.Final {
+			final = chunk
+		}
+	}
+	if stdout != "a\nb\nc\n" || final.ExitCode == nil || *final.ExitCode != 0 {
+		t.Fatalf("stdout = %q final = %+v, want sorted input", stdout, final)
+	}
+}
+
+func TestRunDoesNotWaitForOpenStdin(t *testing.T) {
+	executor := ShellExecutor{Enabled: true}
+	stream := NewStdinStream(nil, 0)
+
+	done := make(chan Result, 1)
+	go func() {
+		res, _ := executor.Run(context.Background(), Spec{Command: "echo ok", Stdin: stream, Timeout: 5 * time.Second})
+		done <- res
+	}()
+	select {
+	case res := <-done:
+		if res.ExitCode != 0 || res.Stdout != "ok\n" {
+			t.Fatalf("Run() = %#v, want ok", res)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatalf("Run() blocked on a stdin stream that was never closed")
+	}
+	if err := stream.Write(1, []byte("x")); !errors.Is(err, ErrStdinClosed) {
+		t.Fatalf("Write() after command exit error = %v, want %v", err, ErrStdinClosed)
+	}
+}
@@ -0,0 +1,130 @@
+package exec
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// DefaultTerminationSignal 为超时后首先发送给进程组的信号。
+const DefaultTerminationSignal = "SIGTERM"
+
+// terminationSignals 为超时首个信号允许的取值。
+var terminationSignals = map[string]syscall.Signal{
+	"SIGTERM": syscall.SIGTERM,
+	"SIGINT":  syscall.SIGINT,
+	"SIGHUP":  syscall.SIGHUP,
+	"SIGQUIT": syscall.SIGQUIT,
+	"SIGKILL": syscall.SIGKILL,
+}
+
+// Termination 描述超时后的终止策略：先向进程组发送 Signal（为空时为 SIGTERM），
+// 等待 Grace 后若输出仍未结束再发送 SIGKILL。Grace 为 0 时发送 Signal 后立即升级。
+type Termination struct {
+	Signal string
+	Grace  time.Duration
+}
+
+// Override 返回以 o 中非零字段覆盖 t 后的策略（任务级覆盖配置默认值）。
+func (t Termination) Override(o Termination) Termination {
+	if o.Signal != "" {
+		t.Signal = o.Signal
+	}
+	if o.Grace > 0 {
+		t.Grace = o.Grace
+	}
+	return t
+}
+
+// Validate 校验 Signal 是否为允许的信号名（大小写不敏感，可省略 "SIG" 前缀）。
+func (t Termination) Validate() error {
+	_, _, err := t.signal()
+	return err
+}
+
+func (t Termination) signal() (string, syscall.Signal, error) {
+	name := strings.ToUpper(strings.TrimSpace(t.Signal))
+	if name == "" {
+		name = DefaultTerminationSignal
+	}
+	if !strings.HasPrefix(name, "SIG") {
+		name = "SIG" + name
+	}
+	sig, ok := terminationSignals[name]
+	if !ok {
+		return "", 0, fmt.Errorf("unsupported termination signal %q", t.Signal)
+	}
+	return name, sig, nil
+}
+
+// terminator 在超时后按 Termination 逐级终止进程组，并记录实际发送的信号。
+// finish 须在 cmd.Wait 返回后调用，此后不再向（可能已被复用的）进程组发送信号。
+type terminator struct {
+	pgid   int
+	policy Termination
+
+	mu        sync.Mutex
+	finished  bool
+	timedOut  bool
+	escalated bool
+	sent      string
+	done      chan struct{}
+}
+
+func newTerminator(pgid int, policy Termination) *terminator {
+	return &terminator{pgid: pgid, policy: policy, done: make(chan struct{})}
+}
+
+// watch 在 timeout 后发送首个信号，grace 内输出仍未结束则发送 SIGKILL；finish 后提前返回。
+func (t *terminator) watch(timeout time.Duration) {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-t.done:
+		return
+	}
+
+	name, sig, err := t.policy.signal()
+	if err != nil {
+		name, sig = "SIGKILL", syscall.SIGKILL
+	}
+	if !t.kill(name, sig, false) || sig == syscall.SIGKILL {
+		return
+	}
+
+	grace := time.NewTimer(t.policy.Grace)
+	defer grace.Stop()
+	select {
+	case <-grace.C:
+		t.kill("SIGKILL", syscall.SIGKILL, true)
+	case <-t.done:
+	}
+}
+
+// kill 向进程组发送信号（负 PID 表示进程组），进程已回收时返回 false。
+func (t *terminator) kill(name string, sig syscall.Signal, escalate bool) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.finished {
+		return false
+	}
+	_ = syscall.Kill(-t.pgid, sig)
+	t.timedOut = true
+	t.escalated = t.escalated || escalate
+	t.sent = name
+	return true
+}
+
+// finish 停止监视并返回是否超时、最后发送的信号以及是否升级到了 SIGKILL。
+func (t *terminator) finish() (timedOut bool, signal string, escalated bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.finished {
+		t.finished = true
+		close(t.done)
+	}
+	return t.timedOut, t.sent, t.escalated
+}
@@ -0,0 +1,68 @@
+package exec
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func runUntilFinal(t *testing.T, executor ShellExecutor, spec Spec) (string, Chunk) {
+	t.Helper()
+	var (
+		stdout strings.Builder
+		final  Chunk
+	)
+	for chunk := range executor.RunStream(context.Background(), spec) {
+		stdout.WriteString(chunk.StdoutChunk)
+		if chunk.Final {
+			final = chunk
+		}
+	}
+	return stdout.String(), final
+}
+
+func TestRunStreamTimeoutGivesGracePeriod(t *testing.T) {
+	executor := ShellExecutor{Enabled: true, Termination: Termination{Grace: 5 * time.Second}}
+
+	stdout, final := runUntilFinal(t, executor, Spec{
+		Command: "trap 'echo cleanup; exit 3' TERM; echo ready; while :; do sleep 0.05; done",
+		Timeout: 300 * time.Millisecond,
+	})
+	if !strings.Contains(stdout, "cleanup") {
+		t.Fatalf("stdout = %q, want the TERM handler to run", stdout)
+	}
+	if !final.TimedOut || final.TerminationSignal != "SIGTERM" || final.Escalated || final.ExitCode == nil || *final.ExitCode != 3 {
+		t.Fatalf("final = %+v, want a SIGTERM timeout without escalation and exit code 3", final)
+	}
+}
+
+func TestRunStreamTimeoutEscalatesToKill(t *testing.T) {
+	executor := ShellExecutor{Enabled: true}
+
+	start := time.Now()
+	_, final := runUntilFinal(t, executor, Spec{
+		Command:     "trap '' INT; while :; do sleep 0.05; done",
+		Termination: Termination{Signal: "int", Grace: 200 * time.Millisecond},
+		Timeout:     200 * time.Millisecond,
+	})
+	if elapsed := time.Since(start); elapsed > 3*time.Second {
+		t.Fatalf("command took %v, want SIGKILL after the grace period", elapsed)
+	}
+	if !final.TimedOut || final.TerminationSignal != "SIGKILL" || !final.Escalated {
+		t.Fatalf("final = %+v, want escalation to SIGKILL", final)
+	}
+
+	_, final = runUntilFinal(t, executor, Spec{Command: "true", Timeout: time.Second})
+	if final.TimedOut || final.TerminationSignal != "" || final.Escalated {
+		t.Fatalf("final = %+v, want no termination metadata for a normal exit", final)
+	}
+}
+
+func TestTerminationRejectsUnknownSignal(t *testing.T) {
+	executor := ShellExecutor{Enabled: true}
+	stdout, final := runUntilFinal(t, executor, Spec{Command: "echo hi", Termination: Termination{Signal: "SIGSTOP"}})
+	if stdout != "" || final.ExitCode == nil || *final.ExitCode != -1 || !strings.Contains(final.StderrChunk, "SIGSTOP") {
+		t.Fatalf("stdout = %q final = %+v, want the command rejected before start", stdout, final)
+	}
+}
@@ -0,0 +1,46 @@
+package exec
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// Segment 描述 Run 汇总结果中的一段输出：Stream 中从 Offset 开始的 Length 个字节，
+// 在进程启动后 Elapsed 时被读到。按 Result.Timeline 顺序拼接各段即可还原 stdout/stderr 的交错输出。
+type Segment struct {
+	Stream  string
+	Offset  int64
+	Length  int
+	Elapsed time.Duration
+}
+
+// timeline 在两个输出流之间共享，按写入顺序记录每段输出。
+type timeline struct {
+	mu        sync.Mutex
+	startedAt time.Time
+	segments  []Segment
+}
+
+// writer 返回记录到 buf 的 stream 输出写入器。
+func (t *timeline) writer(stream string, buf *strings.Builder) *timelineWriter {
+	return &timelineWriter{timeline: t, stream: stream, buf: buf}
+}
+
+type timelineWriter struct {
+	timeline *timeline
+	stream   string
+	buf      *strings.Builder
+	offset   int64
+}
+
+func (w *timelineWriter) Write(p []byte) (int, error) {
+	t := w.timeline
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(p) > 0 {
+		t.segments = append(t.segments, Segment{Stream: w.stream, Offset: w.offset, Length: len(p), Elapsed: time.Since(t.startedAt)})
+		w.offset += int64(len(p))
+	}
+	return w.buf.Write(p)
+}
@@ -0,0 +1,70 @@
+package exec
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRunStreamTracksPerStreamOffsets(t *testing.T) {
+	executor := ShellExecutor{Enabled: true, Output: OutputLimits{MaxStdoutBytes: 100, TailBytes: 10}}
+
+	var (
+		next    = map[string]int64{}
+		elapsed time.Duration
+		gap     *Chunk
+	)
+	chunks := executor.RunStream(context.Background(), Spec{
+		Command: "printf aaa; sleep 0.05; printf bb >&2; sleep 0.05; head -c 1000 /dev/zero | tr '\\0' x",
+		Timeout: 5 * time.Second,
+	})
+	for chunk := range chunks {
+		if chunk.Truncation != nil {
+			c := chunk
+			gap = &c
+			next[chunk.Stream] += chunk.Truncation.DroppedBytes
+			continue
+		}
+		data := chunk.StdoutChunk + chunk.StderrChunk
+		if chunk.Stream == "" || data == "" {
+			continue
+		}
+		if chunk.Offset != next[chunk.Stream] {
+			t.Fatalf("%s chunk at offset %d, want %d", chunk.Stream, chunk.Offset, next[chunk.Stream])
+		}
+		if chunk.Elapsed < elapsed {
+			t.Fatalf("chunk %d elapsed %v went backwards from %v", chunk.Seq, chunk.Elapsed, elapsed)
+		}
+		next[chunk.Stream] += int64(len(data))
+		elapsed = chunk.Elapsed
+	}
+	if next[StreamStdout] != 1003 || next[StreamStderr] != 2 {
+		t.Fatalf("rebuilt lengths = %v, want stdout 1003 and stderr 2", next)
+	}
+	if gap == nil || gap.Stream != StreamStdout || gap.Offset != 90 {
+		t.Fatalf("truncation = %+v, want a stdout gap starting at offset 90", gap)
+	}
+}
+
+func TestRunRecordsTimeline(t *testing.T) {
+	executor := ShellExecutor{Enabled: true}
+	res, err := executor.Run(context.Background(), Spec{Command: "printf one; sleep 0.05; printf two >&2; sleep 0.05; printf three", Timeout: 5 * time.Second})
+	if err != nil {
+		t.Fatalf("Run error: %v", err)
+	}
+
+	var streams []string
+	for i, seg := range res.Timeline {
+		streams = append(streams, seg.Stream)
+		if i > 0 && seg.Elapsed < res.Timeline[i-1].Elapsed {
+			t.Fatalf("timeline not in order: %+v", res.Timeline)
+		}
+	}
+	if got := strings.Join(streams, ","); got != "stdout,stderr,stdout" {
+		t.Fatalf("timeline streams = %s, want stdout,stderr,stdout", got)
+	}
+	if last := res.Timeline[2]; last.Offset != 3 || last.Length != 5 || res.Stdout != "onethree" {
+		t.Fatalf("last segment = %+v stdout = %q, want offset 3 length 5", last, res.Stdout)
+	}
+}
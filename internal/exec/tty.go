@@ -0,0 +1,100 @@
+package exec
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"regexp"
+	"syscall"
+
+	"devops-agent/internal/proc"
+	"devops-agent/internal/terminal"
+)
+
+// PTY 模式的默认终端类型与窗口大小。
+const (
+	DefaultTerm = "xterm-256color"
+	defaultCols = 80
+	defaultRows = 24
+	maxTTYSize  = 1000
+)
+
+// eot 为 Ctrl-D：stdin 结束时写入 PTY，使规范模式下读取终端的进程读到 EOF。
+const eot = 0x04
+
+var termPattern = regexp.MustCompile(`^[A-Za-z0-9._+-]{1,64}$`)
+
+// TTY 描述 PTY 模式：命令在 Cols×Rows 的伪终端中运行，环境变量 TERM 为 Term。字段为零值时使用默认值
+// （80×24、DefaultTerm）。stdout / stderr 合并为终端输出，作为 stdout 流回传。
+type TTY struct {
+	Cols int
+	Rows int
+	Term string
+}
+
+func (t TTY) validate() error {
+	if t.Cols < 0 || t.Rows < 0 || t.Cols > maxTTYSize || t.Rows > maxTTYSize {
+		return fmt.Errorf("invalid tty size %dx%d", t.Cols, t.Rows)
+	}
+	if t.Term != "" && !termPattern.MatchString(t.Term) {
+		return fmt.Errorf("invalid tty term %q", t.Term)
+	}
+	return nil
+}
+
+func (t TTY) size() (cols, rows int) {
+	cols, rows = t.Cols, t.Rows
+	if cols == 0 {
+		cols = defaultCols
+	}
+	if rows == 0 {
+		rows = defaultRows
+	}
+	return cols, rows
+}
+
+func (t TTY) term() string {
+	if t.Term == "" {
+		return DefaultTerm
+	}
+	return t.Term
+}
+
+// startTTY 按资源限制在新会话与 PTY 中启动 cmd（进程组 ID 即 PID，超时终止逻辑不变），
+// 返回 PTY 主端的读取端。spec.Stdin 被转发到 PTY，结束时写入 Ctrl-D。
+func (s ShellExecutor) startTTY(cmd *exec.Cmd, spec Spec) (*proc.Enforcement, io.ReadCloser, error) {
+	cmd.Env = append(cmd.Env, "TERM="+spec.TTY.term())
+	cols, rows := spec.TTY.size()
+
+	var pt *terminal.PtyProcess
+	limits, err := s.launch(cmd, spec, func() error {
+		var err error
+		pt, err = terminal.StartCommand(cmd, cols, rows)
+		return err
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	if spec.Stdin != nil {
+		go func() {
+			if _, err := io.Copy(pt.File, spec.Stdin); err == nil {
+				_, _ = pt.File.Write([]byte{eot})
+			}
+		}()
+	}
+	return limits, ptyReader{pt.File}, nil
+}
+
+// ptyReader 将 PTY 从端全部关闭后主端读取返回的 EIO 视为 EOF。
+type ptyReader struct {
+	terminal.PtyFile
+}
+
+func (r ptyReader) Read(p []byte) (int, error) {
+	n, err := r.PtyFile.Read(p)
+	if errors.Is(err, syscall.EIO) {
+		err = io.EOF
+	}
+	return n, err
+}
@@ -0,0 +1,53 @@
+package exec
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRunStreamTTY(t *testing.T) {
+	executor := ShellExecutor{Enabled: true}
+
+	stdout, final := runUntilFinal(t, executor, Spec{
+		Command: "test -t 0 && test -t 1 && echo is-a-tty; echo term=$TERM; stty size; echo oops >&2; exit 4",
+		TTY:     &TTY{Cols: 100, Rows: 30, Term: "vt100"},
+		Timeout: 5 * time.Second,
+	})
+	for _, want := range []string{"is-a-tty\r\n", "term=vt100\r\n", "30 100\r\n", "oops\r\n"} {
+		if !strings.Contains(stdout, want) {
+			t.Fatalf("stdout = %q, want it to contain %q", stdout, want)
+		}
+	}
+	if final.ExitCode == nil || *final.ExitCode != 4 {
+		t.Fatalf("final = %+v, want exit code 4", final)
+	}
+
+	stdout, final = runUntilFinal(t, executor, Spec{
+		Command: "read line; echo got:$line; cat",
+		TTY:     &TTY{},
+		Stdin:   strings.NewReader("hello\n"),
+		Timeout: 5 * time.Second,
+	})
+	if !strings.Contains(stdout, "got:hello") || final.TimedOut || final.ExitCode == nil || *final.ExitCode != 0 {
+		t.Fatalf("stdout = %q final = %+v, want stdin delivered and Ctrl-D ending cat", stdout, final)
+	}
+}
+
+func TestRunStreamTTYTimeout(t *testing.T) {
+	executor := ShellExecutor{Enabled: true}
+
+	_, final := runUntilFinal(t, executor, Spec{
+		Command: "sleep 30",
+		TTY:     &TTY{},
+		Timeout: 200 * time.Millisecond,
+	})
+	if !final.TimedOut || final.ExitCode == nil || *final.ExitCode != -1 {
+		t.Fatalf("final = %+v, want the tty command killed on timeout", final)
+	}
+
+	_, final = runUntilFinal(t, executor, Spec{Command: "true", TTY: &TTY{Term: "bad term"}})
+	if final.ExitCode == nil || *final.ExitCode != -1 || !strings.Contains(final.StderrChunk, "invalid tty term") {
+		t.Fatalf("final = %+v, want an invalid TERM rejected", final)
+	}
+}
@@ -0,0 +1,45 @@
+package metrics
+
+import (
+	"net"
+	"os"
+	"runtime"
+
+	"github.com/shirou/gopsutil/v3/host"
+)
+
+// HostFacts 描述主机的静态信息，用于注册时供管理员核对。
+type HostFacts struct {
+	Hostname        string
+	OS              string
+	Arch            string
+	Platform        string
+	PlatformVersion string
+	KernelVersion   string
+	MachineID       string
+	IPs             []string
+}
+
+// CollectHostFacts 采集主机信息；单项采集失败时对应字段留空，不影响注册。
+func CollectHostFacts() HostFacts {
+	facts := HostFacts{OS: runtime.GOOS, Arch: runtime.GOARCH}
+	facts.Hostname, _ = os.Hostname()
+
+	if info, err := host.Info(); err == nil {
+		facts.Platform = info.Platform
+		facts.PlatformVersion = info.PlatformVersion
+		facts.KernelVersion = info.KernelVersion
+		facts.MachineID = info.HostID
+	}
+
+	if addrs, err := net.InterfaceAddrs(); err == nil {
+		for _, addr := range addrs {
+			ipNet, ok := addr.(*net.IPNet)
+			if !ok || ipNet.IP.IsLoopback() || ipNet.IP.IsLinkLocalUnicast() {
+				continue
+			}
+			facts.IPs = append(facts.IPs, ipNet.IP.String())
+		}
+	}
+	return facts
+}
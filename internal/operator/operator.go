@@ -0,0 +1,324 @@
+// Package operator 实现运维人员（operator）级别的命令签名校验。
+//
+// 网关签名只能证明命令来自可信网关；高危命令还需携带某个运维人员私钥的签名，
+// Agent 依据本地白名单（keys.dir/operators.yaml）校验签名与权限范围，
+// 使得单独攻破网关也无法在整个集群上执行高危命令。
+package operator
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	agentcrypto "devops-agent/internal/crypto"
+	"devops-agent/internal/protocol"
+)
+
+const (
+	// ScopeExec 允许授权普通命令。
+	ScopeExec = "command.exec"
+	// ScopeHighRisk 允许授权匹配高危模式的命令。
+	ScopeHighRisk = "command.high-risk"
+	// ScopeAll 通配所有权限范围。
+	ScopeAll = "*"
+)
+
+var (
+	ErrSignatureRequired = errors.New("operator signature required")
+	ErrUnknownOperator   = errors.New("operator not in allowlist")
+	ErrSignatureInvalid  = errors.New("operator signature invalid")
+	ErrScopeDenied       = errors.New("operator scope denied")
+	ErrTargetMismatch    = errors.New("operator signature does not target this device")
+)
+
+// DefaultHighRiskPatterns 为未配置 operators.highRiskPatterns 时使用的内置高危命令模式。
+var DefaultHighRiskPatterns = []string{
+	`\brm\s+(-[a-zA-Z]*[rf][a-zA-Z]*\s+)+`,
+	`\bmkfs(\.\w+)?\b`,
+	`\bdd\s+.*\bof=`,
+	`\b(shutdown|reboot|halt|poweroff)\b`,
+	`\bwipefs\b`,
+	`>\s*/dev/(sd|nvme|vd|xvd)`,
+	`:\(\)\s*\{\s*:\|:&\s*\};:`,
+}
+
+// Operator 描述白名单中的一个运维人员公钥及其权限范围。
+type Operator struct {
+	ID        string   `yaml:"id"`
+	PublicKey string   `yaml:"publicKey"`
+	Scopes    []string `yaml:"scopes"`
+
+	key ed25519.PublicKey
+}
+
+// HasScope 判断该运维人员是否拥有指定权限范围。
+func (o Operator) HasScope(scope string) bool {
+	for _, s := range o.Scopes {
+		if s == scope || s == ScopeAll {
+			return true
+		}
+	}
+	return false
+}
+
+type allowlistFile struct {
+	Operators []Operator `yaml:"operators"`
+}
+
+// LoadAllowlist 读取并校验运维人员白名单文件。
+func LoadAllowlist(path string) (map[string]Operator, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var file allowlistFile
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("parse operator allowlist %q: %w", path, err)
+	}
+
+	operators := make(map[string]Operator, len(file.Operators))
+	for i, op := range file.Operators {
+		if op.ID == "" {
+			return nil, fmt.Errorf("operator allowlist %q: entry %d has empty id", path, i)
+		}
+		if _, dup := operators[op.ID]; dup {
+			return nil, fmt.Errorf("operator allowlist %q: duplicate id %q", path, op.ID)
+		}
+		key, err := agentcrypto.ParsePublicKey(op.PublicKey)
+		if err != nil {
+			return nil, fmt.Errorf("operator allowlist %q: operator %q: %w", path, op.ID, err)
+		}
+		op.key = key
+		operators[op.ID] = op
+	}
+	return operators, nil
+}
+
+// SigningMessage 构造运维人员签名的消息：
+//
+//	command.push|<canonical>
+//
+// 其中 canonical 为帧中原始 payload 去掉顶层 operator 字段后的规范形式：各层对象的键按字典序排列、
+// 无多余空白的紧凑 JSON，数字保留原文，字符串按 encoding/json 的规则转义（不转义 HTML 字符）。
+// 签名覆盖原始负载中的全部字段（包括 Agent 尚不认识的字段），且与字段顺序无关。
+func SigningMessage(raw []byte) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	var payload map[string]any
+	if err := dec.Decode(&payload); err != nil {
+		return nil, fmt.Errorf("decode command for operator signature: %w", err)
+	}
+	if dec.More() {
+		return nil, errors.New("decode command for operator signature: trailing data after payload")
+	}
+	delete(payload, "operator")
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(payload); err != nil {
+		return nil, fmt.Errorf("encode command for operator signature: %w", err)
+	}
+	return agentcrypto.FrameSigningMessage(protocol.EventCommandPush, bytes.TrimSuffix(buf.Bytes(), []byte("\n"))), nil
+}
+
+// Options 配置 Verifier。
+type Options struct {
+	AllowlistPath    string
+	Required         bool
+	HighRiskPatterns []string
+}
+
+// Verifier 校验 command.push 上的运维人员签名。
+//
+// 白名单文件在修改时间变化时自动重新加载；文件不存在视为空白名单。
+type Verifier struct {
+	path     string
+	required bool
+	highRisk []*regexp.Regexp
+
+	mu        sync.Mutex
+	modTime   time.Time
+	operators map[string]Operator
+}
+
+func NewVerifier(opts Options) (*Verifier, error) {
+	patterns := opts.HighRiskPatterns
+	if len(patterns) == 0 {
+		patterns = DefaultHighRiskPatterns
+	}
+
+	highRisk := make([]*regexp.Regexp, 0, len(patterns))
+	for _, pattern := range patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("compile high-risk pattern %q: %w", pattern, err)
+		}
+		highRisk = append(highRisk, re)
+	}
+
+	return &Verifier{
+		path:     opts.AllowlistPath,
+		required: opts.Required,
+		highRisk: highRisk,
+	}, nil
+}
+
+// IsHighRisk 判断命令是否匹配高危模式。流式 stdin（stdinStream=true）的内容在推送时未知，
+// 可能被 sh、python3 等当作代码执行，因此总视为高危。
+func (v *Verifier) IsHighRisk(p protocol.CommandPushPayload) bool {
+	if p.StdinStream {
+		return true
+	}
+	text := CommandText(p)
+	for _, re := range v.highRisk {
+		if re.MatchString(text) {
+			return true
+		}
+	}
+	return false
+}
+
+// Authorize 校验命令的运维人员签名，返回授权该命令的运维人员 ID。
+//
+//   - 高危命令或 operators.required=true 时必须携带签名，且运维人员须拥有对应 scope；
+//   - 携带了签名的命令无论是否必需，签名都必须有效，且签名内的 targets 须包含本机 deviceID；
+//   - 未签名且无需签名时返回空 ID。
+//
+// raw 为帧中原样传输的 payload 字节，签名按 SigningMessage 对其校验；p 为其解码结果，用于高危判定与目标检查。
+func (v *Verifier) Authorize(p protocol.CommandPushPayload, raw []byte, deviceID string) (string, error) {
+	highRisk := v.IsHighRisk(p)
+	if p.Operator == nil || p.Operator.ID == "" {
+		if highRisk || v.required {
+			return "", ErrSignatureRequired
+		}
+		return "", nil
+	}
+
+	operators, err := v.allowlist()
+	if err != nil {
+		return "", err
+	}
+	op, ok := operators[p.Operator.ID]
+	if !ok {
+		return "", fmt.Errorf("%w: %s", ErrUnknownOperator, p.Operator.ID)
+	}
+
+	msg, err := SigningMessage(raw)
+	if err != nil {
+		return "", fmt.Errorf("%w: %s: %v", ErrSignatureInvalid, op.ID, err)
+	}
+	if err := agentcrypto.Verify(op.key, msg, p.Operator.Signature); err != nil {
+		return "", fmt.Errorf("%w: %s: %v", ErrSignatureInvalid, op.ID, err)
+	}
+	if !targets(p.Targets, deviceID) {
+		return "", fmt.Errorf("%w: %s signed for %v", ErrTargetMismatch, op.ID, p.Targets)
+	}
+
+	scope := ScopeExec
+	if highRisk {
+		scope = ScopeHighRisk
+	}
+	if !op.HasScope(scope) {
+		return "", fmt.Errorf("%w: %s lacks %s", ErrScopeDenied, op.ID, scope)
+	}
+	return op.ID, nil
+}
+
+// targets 报告 deviceID 是否在签名的目标设备列表中。
+func targets(list []string, deviceID string) bool {
+	for _, id := range list {
+		if id != "" && id == deviceID {
+			return true
+		}
+	}
+	return false
+}
+
+// ErrorCode 将授权错误映射为 frame.rejected 中的错误码。
+func ErrorCode(err error) string {
+	switch {
+	case errors.Is(err, ErrSignatureRequired):
+		return "OPERATOR_SIGNATURE_REQUIRED"
+	case errors.Is(err, ErrUnknownOperator):
+		return "OPERATOR_UNKNOWN"
+	case errors.Is(err, ErrSignatureInvalid):
+		return "OPERATOR_SIGNATURE_INVALID"
+	case errors.Is(err, ErrScopeDenied):
+		return "OPERATOR_SCOPE_DENIED"
+	case errors.Is(err, ErrTargetMismatch):
+		return "OPERATOR_TARGET_MISMATCH"
+	default:
+		return "OPERATOR_ALLOWLIST_ERROR"
+	}
+}
+
+// CommandText 返回用于高危模式匹配的命令文本；argv 模式下为可执行文件与参数以空格拼接的结果，
+// 脚本任务为解释器与参数拼接后换行再接脚本内容，使高危模式同样作用于脚本正文。
+// 携带内联 stdin 时其内容换行后追加在末尾（sh、psql 等会把 stdin 当作命令执行）。
+func CommandText(p protocol.CommandPushPayload) string {
+	text := commandLine(p)
+	if p.Stdin != "" {
+		stdin, err := base64.StdEncoding.DecodeString(p.Stdin)
+		if err != nil {
+			stdin = []byte(p.Stdin)
+		}
+		text += "\n" + string(stdin)
+	}
+	return text
+}
+
+func commandLine(p protocol.CommandPushPayload) string {
+	if p.Script != nil {
+		interpreter := p.Script.Interpreter
+		if interpreter == "" {
+			interpreter = "sh"
+		}
+		return strings.Join(append([]string{interpreter}, p.Script.Args...), " ") + "\n" + p.Script.Body
+	}
+	if len(p.Argv) == 0 {
+		return p.Command
+	}
+	args := p.Argv
+	if p.Executable != "" {
+		args = append([]string{p.Executable}, p.Argv[1:]...)
+	}
+	return strings.Join(args, " ")
+}
+
+func (v *Verifier) allowlist() (map[string]Operator, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	info, err := os.Stat(v.path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			v.operators = nil
+			v.modTime = time.Time{}
+			return nil, nil
+		}
+		return nil, fmt.Errorf("stat operator allowlist: %w", err)
+	}
+	if v.operators != nil && info.ModTime().Equal(v.modTime) {
+		return v.operators, nil
+	}
+
+	operators, err := LoadAllowlist(v.path)
+	if err != nil {
+		return nil, err
+	}
+	v.operators = operators
+	v.modTime = info.ModTime()
+	return operators, nil
+}
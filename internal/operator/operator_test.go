@@ -0,0 +1,274 @@
+package operator
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"devops-agent/internal/protocol"
+)
+
+func TestAuthorizeAllowsUnsignedLowRiskCommand(t *testing.T) {
+	verifier := newTestVerifier(t, filepath.Join(t.TempDir(), "operators.yaml"), false)
+
+	id, err := authorize(t, verifier, protocol.CommandPushPayload{TaskUUID: "t-1", Command: "uptime"})
+	if err != nil {
+		t.Fatalf("Authorize() error = %v", err)
+	}
+	if id != "" {
+		t.Fatalf("operator id = %q, want empty", id)
+	}
+}
+
+func TestAuthorizeRequiresSignatureForHighRiskCommand(t *testing.T) {
+	verifier := newTestVerifier(t, filepath.Join(t.TempDir(), "operators.yaml"), false)
+
+	_, err := authorize(t, verifier, protocol.CommandPushPayload{TaskUUID: "t-1", Command: "rm -rf /var/lib/app"})
+	if !errors.Is(err, ErrSignatureRequired) {
+		t.Fatalf("Authorize() error = %v, want %v", err, ErrSignatureRequired)
+	}
+	if got := ErrorCode(err); got != "OPERATOR_SIGNATURE_REQUIRED" {
+		t.Fatalf("ErrorCode() = %q, want OPERATOR_SIGNATURE_REQUIRED", got)
+	}
+}
+
+func TestAuthorizeTreatsArgvAsCommandText(t *testing.T) {
+	verifier := newTestVerifier(t, filepath.Join(t.TempDir(), "operators.yaml"), false)
+
+	argv := protocol.CommandPushPayload{TaskUUID: "t-1", Argv: []string{"rm", "-rf", "/var/lib/app"}}
+	if _, err := authorize(t, verifier, argv); !errors.Is(err, ErrSignatureRequired) {
+		t.Fatalf("Authorize(argv) error = %v, want %v", err, ErrSignatureRequired)
+	}
+
+	disguised := protocol.CommandPushPayload{TaskUUID: "t-1", Executable: "/bin/rm", Argv: []string{"ls", "-rf", "/var/lib/app"}}
+	if got := CommandText(disguised); got != "/bin/rm -rf /var/lib/app" {
+		t.Fatalf("CommandText() = %q, want executable to replace argv[0]", got)
+	}
+}
+
+func TestAuthorizeMatchesHighRiskPatternsInScriptBody(t *testing.T) {
+	verifier := newTestVerifier(t, filepath.Join(t.TempDir(), "operators.yaml"), false)
+
+	script := protocol.CommandPushPayload{TaskUUID: "t-1", Script: &protocol.ScriptPayload{
+		Body:   "set -e\ncd /var/lib\nrm -rf app\n",
+		SHA256: "unused",
+	}}
+	if _, err := authorize(t, verifier, script); !errors.Is(err, ErrSignatureRequired) {
+		t.Fatalf("Authorize(script) error = %v, want %v", err, ErrSignatureRequired)
+	}
+	if got := CommandText(script); !strings.HasPrefix(got, "sh\nset -e") {
+		t.Fatalf("CommandText(script) = %q, want interpreter followed by body", got)
+	}
+}
+
+func TestAuthorizeCoversStdin(t *testing.T) {
+	verifier := newTestVerifier(t, filepath.Join(t.TempDir(), "operators.yaml"), false)
+
+	inline := protocol.CommandPushPayload{TaskUUID: "t-1", Argv: []string{"sh"}, Stdin: base64.StdEncoding.EncodeToString([]byte("rm -rf /var/lib/app\n"))}
+	if _, err := authorize(t, verifier, inline); !errors.Is(err, ErrSignatureRequired) {
+		t.Fatalf("Authorize(inline stdin) error = %v, want %v", err, ErrSignatureRequired)
+	}
+
+	streamed := protocol.CommandPushPayload{TaskUUID: "t-1", Argv: []string{"sh"}, StdinStream: true}
+	if _, err := authorize(t, verifier, streamed); !errors.Is(err, ErrSignatureRequired) {
+		t.Fatalf("Authorize(stdinStream) error = %v, want %v", err, ErrSignatureRequired)
+	}
+}
+
+func TestAuthorizeChecksSignatureAndScopes(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "operators.yaml")
+	alicePub, alicePriv := mustKey(t)
+	bobPub, bobPriv := mustKey(t)
+	writeAllowlist(t, path, map[string]ed25519.PublicKey{"alice": alicePub, "bob": bobPub}, map[string]string{
+		"alice": `["command.exec", "command.high-risk"]`,
+		"bob":   `["command.exec"]`,
+	})
+	verifier := newTestVerifier(t, path, false)
+
+	cmd := protocol.CommandPushPayload{TaskUUID: "t-1", Command: "rm -rf /var/lib/app"}
+
+	id, err := authorize(t, verifier, sign(t, cmd, "alice", alicePriv))
+	if err != nil {
+		t.Fatalf("Authorize(alice) error = %v", err)
+	}
+	if id != "alice" {
+		t.Fatalf("operator id = %q, want alice", id)
+	}
+
+	if _, err := authorize(t, verifier, sign(t, cmd, "bob", bobPriv)); !errors.Is(err, ErrScopeDenied) {
+		t.Fatalf("Authorize(bob) error = %v, want %v", err, ErrScopeDenied)
+	}
+
+	tampered := sign(t, cmd, "alice", alicePriv)
+	tampered.WorkDir = "/"
+	if _, err := authorize(t, verifier, tampered); !errors.Is(err, ErrSignatureInvalid) {
+		t.Fatalf("Authorize(tampered) error = %v, want %v", err, ErrSignatureInvalid)
+	}
+
+	if _, err := authorize(t, verifier, sign(t, cmd, "mallory", alicePriv)); !errors.Is(err, ErrUnknownOperator) {
+		t.Fatalf("Authorize(unknown) error = %v, want %v", err, ErrUnknownOperator)
+	}
+}
+
+func TestAuthorizeRejectsSignatureForOtherDevices(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "operators.yaml")
+	pub, priv := mustKey(t)
+	writeAllowlist(t, path, map[string]ed25519.PublicKey{"alice": pub}, map[string]string{"alice": `["*"]`})
+	verifier := newTestVerifier(t, path, false)
+	cmd := protocol.CommandPushPayload{TaskUUID: "t-1", Command: "rm -rf /var/lib/app"}
+
+	fleet := cmd
+	fleet.Targets = []string{"device-2", testDevice}
+	if id, err := authorize(t, verifier, sign(t, fleet, "alice", priv)); err != nil || id != "alice" {
+		t.Fatalf("Authorize(listed) = %q, %v; want alice, nil", id, err)
+	}
+
+	other := cmd
+	other.Targets = []string{"device-2"}
+	if _, err := authorize(t, verifier, sign(t, other, "alice", priv)); !errors.Is(err, ErrTargetMismatch) {
+		t.Fatalf("Authorize(other device) error = %v, want %v", err, ErrTargetMismatch)
+	}
+
+	untargeted := cmd
+	untargeted.Targets = []string{}
+	if _, err := authorize(t, verifier, sign(t, untargeted, "alice", priv)); !errors.Is(err, ErrTargetMismatch) {
+		t.Fatalf("Authorize(no targets) error = %v, want %v", err, ErrTargetMismatch)
+	}
+
+	// 签名后改写 targets 会使签名失效。
+	retargeted := sign(t, other, "alice", priv)
+	retargeted.Targets = []string{testDevice}
+	if _, err := authorize(t, verifier, retargeted); !errors.Is(err, ErrSignatureInvalid) {
+		t.Fatalf("Authorize(retargeted) error = %v, want %v", err, ErrSignatureInvalid)
+	}
+}
+
+func TestAuthorizeRequiredModeAndAllowlistReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "operators.yaml")
+	verifier := newTestVerifier(t, path, true)
+	cmd := protocol.CommandPushPayload{TaskUUID: "t-1", Command: "uptime"}
+
+	if _, err := authorize(t, verifier, cmd); !errors.Is(err, ErrSignatureRequired) {
+		t.Fatalf("Authorize(unsigned) error = %v, want %v", err, ErrSignatureRequired)
+	}
+
+	pub, priv := mustKey(t)
+	if _, err := authorize(t, verifier, sign(t, cmd, "carol", priv)); !errors.Is(err, ErrUnknownOperator) {
+		t.Fatalf("Authorize(before reload) error = %v, want %v", err, ErrUnknownOperator)
+	}
+
+	writeAllowlist(t, path, map[string]ed25519.PublicKey{"carol": pub}, map[string]string{"carol": `["*"]`})
+	if id, err := authorize(t, verifier, sign(t, cmd, "carol", priv)); err != nil || id != "carol" {
+		t.Fatalf("Authorize(after reload) = %q, %v; want carol, nil", id, err)
+	}
+}
+
+func TestAuthorizeVerifiesRawPayloadBytes(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "operators.yaml")
+	pub, priv := mustKey(t)
+	writeAllowlist(t, path, map[string]ed25519.PublicKey{"alice": pub}, map[string]string{"alice": `["*"]`})
+	verifier := newTestVerifier(t, path, true)
+
+	// 签名方的字段顺序与 agent 不同，且带有 agent 不认识的字段。
+	signed := `{"command":"uptime","targets":["device-1"],"taskUUID":"t-1","future":{"b":1,"a":2.50}}`
+	msg, err := SigningMessage([]byte(signed))
+	if err != nil {
+		t.Fatalf("SigningMessage() error = %v", err)
+	}
+	sig := base64.StdEncoding.EncodeToString(ed25519.Sign(priv, msg))
+	frame := func(future string) []byte {
+		return []byte(`{"taskUUID":"t-1","operator":{"id":"alice","signature":"` + sig + `"},"future":` + future + `,"command":"uptime","targets":["device-1"]}`)
+	}
+	p := protocol.CommandPushPayload{TaskUUID: "t-1", Command: "uptime", Targets: []string{testDevice}, Operator: &protocol.OperatorAuth{ID: "alice", Signature: sig}}
+
+	if id, err := verifier.Authorize(p, frame(`{"a":2.50,"b":1}`), testDevice); err != nil || id != "alice" {
+		t.Fatalf("Authorize(reordered) = %q, %v; want alice, nil", id, err)
+	}
+	if _, err := verifier.Authorize(p, frame(`{"a":3,"b":1}`), testDevice); !errors.Is(err, ErrSignatureInvalid) {
+		t.Fatalf("Authorize(unknown field tampered) error = %v, want %v", err, ErrSignatureInvalid)
+	}
+	if _, err := verifier.Authorize(p, append(frame(`{"a":2.50,"b":1}`), `{}`...), testDevice); !errors.Is(err, ErrSignatureInvalid) {
+		t.Fatalf("Authorize(trailing data) error = %v, want %v", err, ErrSignatureInvalid)
+	}
+}
+
+func TestLoadAllowlistRejectsInvalidEntries(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "operators.yaml")
+	content := "operators:\n  - id: alice\n    publicKey: not-base64!\n"
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+
+	if _, err := LoadAllowlist(path); err == nil {
+		t.Fatalf("LoadAllowlist() error = nil, want error for invalid key")
+	}
+}
+
+func newTestVerifier(t *testing.T, path string, required bool) *Verifier {
+	t.Helper()
+	verifier, err := NewVerifier(Options{AllowlistPath: path, Required: required})
+	if err != nil {
+		t.Fatalf("NewVerifier() error = %v", err)
+	}
+	return verifier
+}
+
+func mustKey(t *testing.T) (ed25519.PublicKey, ed25519.PrivateKey) {
+	t.Helper()
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey() error = %v", err)
+	}
+	return pub, priv
+}
+
+// testDevice 为测试中 Agent 的设备 ID，sign 未指定 targets 时以它为目标。
+const testDevice = "device-1"
+
+func sign(t *testing.T, p protocol.CommandPushPayload, id string, priv ed25519.PrivateKey) protocol.CommandPushPayload {
+	t.Helper()
+	if p.Targets == nil {
+		p.Targets = []string{testDevice}
+	}
+	raw, err := json.Marshal(p)
+	if err != nil {
+		t.Fatalf("Marshal() error = %v", err)
+	}
+	msg, err := SigningMessage(raw)
+	if err != nil {
+		t.Fatalf("SigningMessage() error = %v", err)
+	}
+	p.Operator = &protocol.OperatorAuth{
+		ID:        id,
+		Signature: base64.StdEncoding.EncodeToString(ed25519.Sign(priv, msg)),
+	}
+	return p
+}
+
+// authorize 以 p 的 JSON 编码作为帧中原样传输的 payload 调用 Authorize。
+func authorize(t *testing.T, v *Verifier, p protocol.CommandPushPayload) (string, error) {
+	t.Helper()
+	raw, err := json.Marshal(p)
+	if err != nil {
+		t.Fatalf("Marshal() error = %v", err)
+	}
+	return v.Authorize(p, raw, testDevice)
+}
+
+func writeAllowlist(t *testing.T, path string, keys map[string]ed25519.PublicKey, scopes map[string]string) {
+	t.Helper()
+	content := "operators:\n"
+	for id, key := range keys {
+		content += fmt.Sprintf("  - id: %s\n    publicKey: %s\n    scopes: %s\n", id, base64.StdEncoding.EncodeToString(key), scopes[id])
+	}
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+}
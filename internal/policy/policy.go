@@ -0,0 +1,326 @@
+// Package policy 实现 Agent 本地的命令策略：按顺序匹配的允许 / 拒绝 / 需审批规则。
+//
+// 策略文件（默认 keys.dir/policy.yaml）修改后自动生效；文件不存在时不做限制，
+// 文件存在但无法解析时拒绝所有命令（fail closed）。
+package policy
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"os"
+	"os/user"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Action 为规则命中后的处理方式。
+type Action string
+
+const (
+	ActionAllow Action = "allow"
+	ActionDeny  Action = "deny"
+	// ActionRequireApproval 要求命令携带有效的运维人员签名（见 operator 包）。
+	ActionRequireApproval Action = "require-approval"
+)
+
+// 拒绝时回传给 server 的错误码。
+const (
+	ErrorCodeDenied           = "POLICY_DENIED"
+	ErrorCodeApprovalRequired = "POLICY_APPROVAL_REQUIRED"
+	ErrorCodeInvalid          = "POLICY_INVALID"
+)
+
+// DefaultRuleID 为没有规则命中、按 default 处理时回传的规则 ID。
+const DefaultRuleID = "default"
+
+// Request 描述待评估的命令。
+type Request struct {
+	// Command 为用于正则匹配的命令文本（argv 模式为可执行文件与参数以空格拼接）。
+	Command string
+	// Executable 为 argv 模式解析后的可执行文件或脚本模式的解释器（绝对路径），shell 模式为空。
+	Executable string
+	// WorkDir 为命令实际生效的绝对工作目录。
+	WorkDir string
+	// RunAs 为解析后的运行用户名，为空时按 Agent 自身用户名匹配。
+	RunAs string
+	// Operator 为已校验签名的运维人员 ID，未签名时为空。
+	Operator string
+}
+
+// Rule 为策略文件中的一条规则。同一规则内的各匹配条件需同时满足，未填写的条件视为匹配；
+// 列表类条件命中其中任一项即可。
+//
+//   - command：对命令文本的正则（RE2 语法，未锚定）；
+//   - executable：argv 可执行文件的名称或绝对路径模式（path.Match，如 "/usr/bin/*"），shell 模式命令不匹配；
+//   - workDir：工作目录前缀（按路径分段比较，"/srv" 匹配 "/srv/app" 但不匹配 "/srvx"）；
+//   - runAs：运行用户模式（path.Match）；
+//   - operator：运维人员 ID 模式，"*" 匹配任意已签名的运维人员。
+type Rule struct {
+	ID         string   `yaml:"id"`
+	Action     Action   `yaml:"action"`
+	Command    string   `yaml:"command"`
+	Executable []string `yaml:"executable"`
+	WorkDir    []string `yaml:"workDir"`
+	RunAs      []string `yaml:"runAs"`
+	Operator   []string `yaml:"operator"`
+	// Reason 为拒绝时回传的说明，可选。
+	Reason string `yaml:"reason"`
+
+	command *regexp.Regexp
+}
+
+// Policy 为解析后的策略文件：规则按顺序匹配，首条命中的规则决定结果；均未命中时按 Default 处理。
+type Policy struct {
+	// Default 为未命中任何规则时的动作，未填写时为 deny（白名单语义）。
+	Default Action `yaml:"default"`
+	Rules   []Rule `yaml:"rules"`
+}
+
+// Decision 为策略评估结果。
+type Decision struct {
+	Action Action
+	// RuleID 为命中的规则 ID；未命中任何规则时为 DefaultRuleID，未配置策略时为空。
+	RuleID string
+	Reason string
+}
+
+// Load 读取并校验策略文件。
+func Load(path string) (*Policy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(data)
+}
+
+// Parse 解析并校验策略内容。
+func Parse(data []byte) (*Policy, error) {
+	var p Policy
+	if err := yaml.Unmarshal(data, &p); err != nil {
+		return nil, fmt.Errorf("parse policy: %w", err)
+	}
+	if p.Default == "" {
+		p.Default = ActionDeny
+	}
+	if !validAction(p.Default) {
+		return nil, fmt.Errorf("policy: invalid default action %q", p.Default)
+	}
+
+	seen := make(map[string]bool, len(p.Rules))
+	for i := range p.Rules {
+		rule := &p.Rules[i]
+		if rule.ID == "" {
+			return nil, fmt.Errorf("policy: rule %d has empty id", i)
+		}
+		if rule.ID == DefaultRuleID {
+			return nil, fmt.Errorf("policy: rule id %q is reserved", rule.ID)
+		}
+		if seen[rule.ID] {
+			return nil, fmt.Errorf("policy: duplicate rule id %q", rule.ID)
+		}
+		seen[rule.ID] = true
+		if !validAction(rule.Action) {
+			return nil, fmt.Errorf("policy: rule %q: invalid action %q", rule.ID, rule.Action)
+		}
+		if rule.Command != "" {
+			re, err := regexp.Compile(rule.Command)
+			if err != nil {
+				return nil, fmt.Errorf("policy: rule %q: compile command pattern: %w", rule.ID, err)
+			}
+			rule.command = re
+		}
+		for _, patterns := range [][]string{rule.Executable, rule.RunAs, rule.Operator} {
+			for _, pattern := range patterns {
+				if _, err := path.Match(pattern, ""); err != nil {
+					return nil, fmt.Errorf("policy: rule %q: invalid pattern %q: %w", rule.ID, pattern, err)
+				}
+			}
+		}
+		for j, prefix := range rule.WorkDir {
+			if !filepath.IsAbs(prefix) {
+				return nil, fmt.Errorf("policy: rule %q: workDir %q must be absolute", rule.ID, prefix)
+			}
+			rule.WorkDir[j] = filepath.Clean(prefix)
+		}
+	}
+	return &p, nil
+}
+
+// Evaluate 返回首条命中规则的结果，均未命中时返回 Default。
+func (p *Policy) Evaluate(req Request) Decision {
+	for _, rule := range p.Rules {
+		if rule.matches(req) {
+			return Decision{Action: rule.Action, RuleID: rule.ID, Reason: rule.Reason}
+		}
+	}
+	return Decision{Action: p.Default, RuleID: DefaultRuleID}
+}
+
+func (r Rule) matches(req Request) bool {
+	if r.command != nil && !r.command.MatchString(req.Command) {
+		return false
+	}
+	if len(r.Executable) > 0 && !matchExecutable(r.Executable, req.Executable) {
+		return false
+	}
+	if len(r.WorkDir) > 0 && !matchWorkDir(r.WorkDir, req.WorkDir) {
+		return false
+	}
+	if len(r.RunAs) > 0 && !matchAny(r.RunAs, req.RunAs) {
+		return false
+	}
+	if len(r.Operator) > 0 && (req.Operator == "" || !matchAny(r.Operator, req.Operator)) {
+		return false
+	}
+	return true
+}
+
+func matchExecutable(patterns []string, executable string) bool {
+	if executable == "" {
+		return false
+	}
+	for _, pattern := range patterns {
+		name := executable
+		if !strings.Contains(pattern, "/") {
+			name = path.Base(executable)
+		}
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func matchWorkDir(prefixes []string, dir string) bool {
+	if dir == "" {
+		return false
+	}
+	dir = filepath.Clean(dir)
+	for _, prefix := range prefixes {
+		if dir == prefix || prefix == "/" || strings.HasPrefix(dir, prefix+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+func matchAny(patterns []string, value string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, value); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func validAction(a Action) bool {
+	return a == ActionAllow || a == ActionDeny || a == ActionRequireApproval
+}
+
+// Engine 按策略文件评估命令，文件内容变化时自动重新加载。每次评估都会读取文件并比较摘要，
+// 同一时间戳内的多次修改（修改时间精度不足或被还原）同样会生效。
+type Engine struct {
+	path string
+
+	mu     sync.Mutex
+	digest [sha256.Size]byte
+	policy *Policy
+	self   string
+}
+
+// NewEngine 创建读取 path 的策略引擎。
+func NewEngine(path string) *Engine {
+	return &Engine{path: path}
+}
+
+// Evaluate 评估命令：
+//   - 策略文件不存在时放行（Decision.RuleID 为空）；
+//   - 策略文件无法读取或解析时返回错误，调用方应拒绝命令；
+//   - require-approval 规则在命令已携带有效运维人员签名（req.Operator 非空）时视为放行。
+func (e *Engine) Evaluate(req Request) (Decision, error) {
+	p, err := e.load()
+	if err != nil {
+		return Decision{}, err
+	}
+	if p == nil {
+		return Decision{Action: ActionAllow}, nil
+	}
+	if req.RunAs == "" {
+		req.RunAs = e.selfUser()
+	}
+	decision := p.Evaluate(req)
+	if decision.Action == ActionRequireApproval && req.Operator != "" {
+		decision.Action = ActionAllow
+	}
+	return decision, nil
+}
+
+// ErrorCode 返回拒绝结果对应的错误码；放行时返回空。
+func (d Decision) ErrorCode() string {
+	switch d.Action {
+	case ActionDeny:
+		return ErrorCodeDenied
+	case ActionRequireApproval:
+		return ErrorCodeApprovalRequired
+	default:
+		return ""
+	}
+}
+
+// Message 返回拒绝结果的说明文本。
+func (d Decision) Message() string {
+	msg := fmt.Sprintf("command denied by policy rule %q", d.RuleID)
+	if d.Action == ActionRequireApproval {
+		msg = fmt.Sprintf("policy rule %q requires operator approval", d.RuleID)
+	}
+	if d.Reason != "" {
+		msg += ": " + d.Reason
+	}
+	return msg
+}
+
+func (e *Engine) load() (*Policy, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.path == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(e.path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			e.policy = nil
+			return nil, nil
+		}
+		return nil, fmt.Errorf("read policy: %w", err)
+	}
+	digest := sha256.Sum256(data)
+	if e.policy != nil && digest == e.digest {
+		return e.policy, nil
+	}
+
+	p, err := Parse(data)
+	if err != nil {
+		return nil, err
+	}
+	e.policy = p
+	e.digest = digest
+	return p, nil
+}
+
+func (e *Engine) selfUser() string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.self == "" {
+		if u, err := user.Current(); err == nil {
+			e.self = u.Username
+		}
+	}
+	return e.self
+}
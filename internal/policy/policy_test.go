@@ -0,0 +1,126 @@
+package policy
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const testPolicy = `
+default: deny
+rules:
+  - id: no-root-rm
+    action: deny
+    command: '\brm\s+-rf\b'
+    reason: destructive
+  - id: systemctl-approval
+    action: require-approval
+    executable: ["systemctl"]
+  - id: app-dir
+    action: allow
+    workDir: ["/srv/app"]
+    runAs: ["deploy", "svc-*"]
+  - id: oncall
+    action: allow
+    operator: ["*"]
+`
+
+func TestPolicyEvaluateFirstMatchWins(t *testing.T) {
+	p, err := Parse([]byte(testPolicy))
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+
+	cases := []struct {
+		name string
+		req  Request
+		want Decision
+	}{
+		{"regex deny before allow", Request{Command: "rm -rf /srv/app/cache", WorkDir: "/srv/app", RunAs: "deploy"}, Decision{Action: ActionDeny, RuleID: "no-root-rm", Reason: "destructive"}},
+		{"executable basename", Request{Command: "systemctl restart nginx", Executable: "/usr/bin/systemctl"}, Decision{Action: ActionRequireApproval, RuleID: "systemctl-approval"}},
+		{"shell mode ignores executable rule", Request{Command: "systemctl restart nginx", Operator: "alice"}, Decision{Action: ActionAllow, RuleID: "oncall"}},
+		{"workDir prefix and runAs", Request{Command: "make", WorkDir: "/srv/app/releases/", RunAs: "svc-web"}, Decision{Action: ActionAllow, RuleID: "app-dir"}},
+		{"workDir prefix is path aware", Request{Command: "make", WorkDir: "/srv/application", RunAs: "deploy"}, Decision{Action: ActionDeny, RuleID: DefaultRuleID}},
+		{"runAs mismatch", Request{Command: "make", WorkDir: "/srv/app", RunAs: "root"}, Decision{Action: ActionDeny, RuleID: DefaultRuleID}},
+	}
+	for _, tc := range cases {
+		if got := p.Evaluate(tc.req); got != tc.want {
+			t.Errorf("%s: Evaluate() = %+v, want %+v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestParseRejectsInvalidPolicy(t *testing.T) {
+	for name, doc := range map[string]string{
+		"bad action":       "rules: [{id: a, action: maybe}]",
+		"missing id":       "rules: [{action: allow}]",
+		"duplicate id":     "rules: [{id: a, action: allow}, {id: a, action: deny}]",
+		"reserved id":      "rules: [{id: default, action: allow}]",
+		"bad regex":        "rules: [{id: a, action: deny, command: '('}]",
+		"relative workDir": "rules: [{id: a, action: allow, workDir: [srv]}]",
+		"bad default":      "default: maybe",
+	} {
+		if _, err := Parse([]byte(doc)); err == nil {
+			t.Errorf("%s: Parse() error = nil, want error", name)
+		}
+	}
+}
+
+func TestEngineReloadsAndFailsClosed(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "policy.yaml")
+	engine := NewEngine(path)
+
+	decision, err := engine.Evaluate(Request{Command: "uptime"})
+	if err != nil || decision.Action != ActionAllow || decision.RuleID != "" {
+		t.Fatalf("Evaluate(no policy) = %+v, %v; want allow", decision, err)
+	}
+
+	writePolicy(t, path, testPolicy, time.Now().Add(-time.Minute))
+	decision, err = engine.Evaluate(Request{Command: "systemctl stop nginx", Executable: "systemctl"})
+	if err != nil || decision.ErrorCode() != ErrorCodeApprovalRequired || decision.RuleID != "systemctl-approval" {
+		t.Fatalf("Evaluate(unsigned) = %+v, %v; want approval required", decision, err)
+	}
+	decision, err = engine.Evaluate(Request{Command: "systemctl stop nginx", Executable: "systemctl", Operator: "alice"})
+	if err != nil || decision.Action != ActionAllow || decision.RuleID != "systemctl-approval" {
+		t.Fatalf("Evaluate(signed) = %+v, %v; want allow", decision, err)
+	}
+
+	writePolicy(t, path, "default: allow\n", time.Now())
+	decision, err = engine.Evaluate(Request{Command: "systemctl stop nginx", Executable: "systemctl"})
+	if err != nil || decision.Action != ActionAllow || decision.RuleID != DefaultRuleID {
+		t.Fatalf("Evaluate(reloaded) = %+v, %v; want default allow", decision, err)
+	}
+
+	writePolicy(t, path, "rules: [", time.Now().Add(time.Minute))
+	if _, err := engine.Evaluate(Request{Command: "uptime"}); err == nil {
+		t.Fatalf("Evaluate(invalid policy) error = nil, want error")
+	}
+}
+
+func TestEngineReloadsEditsWithinSameTimestamp(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "policy.yaml")
+	engine := NewEngine(path)
+	stamp := time.Now().Truncate(time.Second)
+
+	writePolicy(t, path, "default: deny \n", stamp)
+	if decision, err := engine.Evaluate(Request{Command: "uptime"}); err != nil || decision.Action != ActionDeny {
+		t.Fatalf("Evaluate() = %+v, %v; want deny", decision, err)
+	}
+
+	// 长度与修改时间均不变的修改同样须重新加载。
+	writePolicy(t, path, "default: allow\n", stamp)
+	if decision, err := engine.Evaluate(Request{Command: "uptime"}); err != nil || decision.Action != ActionAllow {
+		t.Fatalf("Evaluate(edited) = %+v, %v; want allow", decision, err)
+	}
+}
+
+func writePolicy(t *testing.T, path, content string, modTime time.Time) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("write policy: %v", err)
+	}
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatalf("chtimes policy: %v", err)
+	}
+}
@@ -0,0 +1,104 @@
+// Package proc 提供命令执行与终端会话共用的子进程构造策略。
+package proc
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+)
+
+// scrubPrefix 为无论策略如何都不会传给子进程的 Agent 自身变量前缀（AGENT_AUTH__TOKEN 等配置与密钥）。
+const scrubPrefix = "AGENT_"
+
+// EnvPolicy 描述子进程的环境变量策略：
+//   - Inherit：允许从 Agent 进程继承的变量名模式（path.Match 语法，如 "LC_*"），为空时继承全部；
+//   - Deny：禁止出现在子进程中的变量名模式，同时作用于继承变量与任务下发的变量；
+//   - AGENT_* 变量始终被清除，任务也不能下发。
+type EnvPolicy struct {
+	Inherit []string
+	Deny    []string
+}
+
+// Environ 按策略构造子进程环境：先过滤继承的 Agent 环境，再叠加 extra（"KEY=VALUE"，后者覆盖前者）。
+// extra 中包含被禁止或格式非法的变量时返回错误，而不是静默丢弃。
+// 结果总是非 nil：exec.Cmd.Env 为 nil 时子进程会继承 Agent 的全部环境（含 AGENT_*），过滤后为空时须返回空切片。
+func (p EnvPolicy) Environ(extra []string) ([]string, error) {
+	return p.build(os.Environ(), extra)
+}
+
+func (p EnvPolicy) build(base, extra []string) ([]string, error) {
+	values := make(map[string]string, len(base)+len(extra))
+	for _, kv := range base {
+		key, value, ok := strings.Cut(kv, "=")
+		if !ok || key == "" || p.scrubbed(key) || !p.inherited(key) {
+			continue
+		}
+		values[key] = value
+	}
+
+	for _, kv := range extra {
+		key, value, ok := strings.Cut(kv, "=")
+		if err := validateName(key); !ok || err != nil {
+			return nil, fmt.Errorf("invalid env entry %q", kv)
+		}
+		if strings.ContainsRune(value, 0) {
+			return nil, fmt.Errorf("env %s contains a NUL byte", key)
+		}
+		if p.scrubbed(key) {
+			return nil, fmt.Errorf("env %s is denied by policy", key)
+		}
+		values[key] = value
+	}
+	if env := EnvList(values); env != nil {
+		return env, nil
+	}
+	return []string{}, nil
+}
+
+// EnvList 将变量表转为按名称排序的 "KEY=VALUE" 列表。
+func EnvList(values map[string]string) []string {
+	if len(values) == 0 {
+		return nil
+	}
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	out := make([]string, 0, len(keys))
+	for _, key := range keys {
+		out = append(out, key+"="+values[key])
+	}
+	return out
+}
+
+func (p EnvPolicy) scrubbed(key string) bool {
+	return strings.HasPrefix(strings.ToUpper(key), scrubPrefix) || matchAny(p.Deny, key)
+}
+
+func (p EnvPolicy) inherited(key string) bool {
+	return len(p.Inherit) == 0 || matchAny(p.Inherit, key)
+}
+
+func matchAny(patterns []string, key string) bool {
+	for _, pattern := range patterns {
+		pattern = strings.TrimSpace(pattern)
+		if pattern == "" {
+			continue
+		}
+		if ok, err := path.Match(pattern, key); err == nil && ok {
+			return true
+		}
+	}
+	return false
+}
+
+func validateName(key string) error {
+	if key == "" || strings.ContainsAny(key, "=\x00") {
+		return fmt.Errorf("invalid env name %q", key)
+	}
+	return nil
+}
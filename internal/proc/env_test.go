@@ -0,0 +1,61 @@
+package proc
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestEnvironFiltersInheritedVariables(t *testing.T) {
+	policy := EnvPolicy{Inherit: []string{"PATH", "LC_*", "AGENT_*"}, Deny: []string{"LD_PRELOAD"}}
+	base := []string{
+		"PATH=/usr/bin",
+		"LC_ALL=C",
+		"HOME=/root",
+		"AGENT_AUTH__TOKEN=secret",
+		"agent_lowercase=secret",
+	}
+
+	got, err := policy.build(base, []string{"FOO=bar", "PATH=/opt/bin"})
+	if err != nil {
+		t.Fatalf("build() error = %v", err)
+	}
+	want := []string{"FOO=bar", "LC_ALL=C", "PATH=/opt/bin"}
+	if !slices.Equal(got, want) {
+		t.Fatalf("build() = %v, want %v", got, want)
+	}
+}
+
+func TestEnvironInheritsAllWhenAllowlistEmpty(t *testing.T) {
+	got, err := EnvPolicy{Deny: []string{"LD_*"}}.build([]string{"HOME=/root", "LD_PRELOAD=/x.so", "AGENT_KEYS__DIR=/k"}, nil)
+	if err != nil {
+		t.Fatalf("build() error = %v", err)
+	}
+	if !slices.Equal(got, []string{"HOME=/root"}) {
+		t.Fatalf("build() = %v, want only HOME", got)
+	}
+}
+
+func TestEnvironReturnsEmptyNotNilWhenNothingMatches(t *testing.T) {
+	got, err := EnvPolicy{Inherit: []string{"NOPE_*"}}.build([]string{"HOME=/root", "AGENT_AUTH__TOKEN=secret"}, nil)
+	if err != nil {
+		t.Fatalf("build() error = %v", err)
+	}
+	// nil 会让 exec.Cmd 继承 Agent 的全部环境。
+	if got == nil || len(got) != 0 {
+		t.Fatalf("build() = %#v, want a non-nil empty environment", got)
+	}
+}
+
+func TestEnvironRejectsDeniedOrInvalidRequests(t *testing.T) {
+	policy := EnvPolicy{Deny: []string{"LD_PRELOAD"}}
+
+	for _, extra := range []string{"LD_PRELOAD=/tmp/x.so", "AGENT_AUTH__TOKEN=x", "=value", "NOVALUE", "BAD=a\x00b"} {
+		if _, err := policy.build(nil, []string{extra}); err == nil {
+			t.Fatalf("build(%q) error = nil, want rejection", extra)
+		}
+	}
+	if _, err := policy.build(nil, []string{"LD_PRELOAD=/tmp/x.so"}); !strings.Contains(err.Error(), "denied") {
+		t.Fatalf("build() error = %v, want denied", err)
+	}
+}
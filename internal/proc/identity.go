@@ -0,0 +1,172 @@
+package proc
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/user"
+	"strconv"
+	"syscall"
+)
+
+// ErrRunAsDenied 表示请求的运行身份不在白名单中。
+var ErrRunAsDenied = errors.New("runAs identity not allowed")
+
+// RunAs 描述请求的子进程运行身份；各字段可为名称或数字 ID。
+//   - Group 为空时使用用户的主组；
+//   - Groups 为 nil 时使用用户在本地组数据库中的全部附加组，非 nil（含空切片）时按请求设置。
+type RunAs struct {
+	User   string
+	Group  string
+	Groups []string
+}
+
+// Identity 是经本地用户数据库解析后的运行身份。
+type Identity struct {
+	Username string
+	Home     string
+	UID      uint32
+	GID      uint32
+	Groups   []uint32
+}
+
+// UserPolicy 为 server 可请求的运行身份白名单（名称或数字 ID，支持 path.Match 模式）。
+// 白名单为空时拒绝所有 runAs 请求；用户自身的主组与附加组无需出现在 AllowedGroups 中。
+type UserPolicy struct {
+	AllowedUsers  []string
+	AllowedGroups []string
+}
+
+// Resolve 解析并校验 r。
+func (p UserPolicy) Resolve(r RunAs) (*Identity, error) {
+	if r.User == "" {
+		return nil, errors.New("runAs.user is required")
+	}
+	u, err := lookupUser(r.User)
+	if err != nil {
+		return nil, err
+	}
+	if !matchAny(p.AllowedUsers, u.Username) && !matchAny(p.AllowedUsers, u.Uid) {
+		return nil, fmt.Errorf("%w: user %s", ErrRunAsDenied, u.Username)
+	}
+
+	uid, err := parseID(u.Uid)
+	if err != nil {
+		return nil, err
+	}
+	ownGroups, err := u.GroupIds()
+	if err != nil {
+		return nil, fmt.Errorf("list groups of %s: %w", u.Username, err)
+	}
+	own := map[string]bool{u.Gid: true}
+	for _, gid := range ownGroups {
+		own[gid] = true
+	}
+
+	gidStr := u.Gid
+	if r.Group != "" {
+		if gidStr, err = p.resolveGroup(r.Group, own); err != nil {
+			return nil, err
+		}
+	}
+	gid, err := parseID(gidStr)
+	if err != nil {
+		return nil, err
+	}
+
+	groupIDs := ownGroups
+	if r.Groups != nil {
+		groupIDs = make([]string, 0, len(r.Groups))
+		for _, name := range r.Groups {
+			resolved, err := p.resolveGroup(name, own)
+			if err != nil {
+				return nil, err
+			}
+			groupIDs = append(groupIDs, resolved)
+		}
+	}
+	groups := make([]uint32, 0, len(groupIDs))
+	for _, id := range groupIDs {
+		n, err := parseID(id)
+		if err != nil {
+			return nil, err
+		}
+		groups = append(groups, n)
+	}
+
+	return &Identity{Username: u.Username, Home: u.HomeDir, UID: uid, GID: gid, Groups: groups}, nil
+}
+
+func (p UserPolicy) resolveGroup(name string, own map[string]bool) (string, error) {
+	g, err := lookupGroup(name)
+	if err != nil {
+		return "", err
+	}
+	if !own[g.Gid] && !matchAny(p.AllowedGroups, g.Name) && !matchAny(p.AllowedGroups, g.Gid) {
+		return "", fmt.Errorf("%w: group %s", ErrRunAsDenied, g.Name)
+	}
+	return g.Gid, nil
+}
+
+// Apply 以该身份运行 cmd，保留 cmd.SysProcAttr 中已有的其他设置。
+//
+// Agent 非 root 且请求的正是自身 uid/gid 时无需切换身份，不设置 Credential（否则 setgroups 会因权限不足失败）。
+func (id *Identity) Apply(cmd *exec.Cmd) {
+	if os.Geteuid() != 0 && id.UID == uint32(os.Geteuid()) && id.GID == uint32(os.Getegid()) {
+		return
+	}
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Credential = &syscall.Credential{
+		Uid:    id.UID,
+		Gid:    id.GID,
+		Groups: append([]uint32(nil), id.Groups...),
+	}
+}
+
+// Env 返回与该身份匹配的 HOME / USER / LOGNAME。
+func (id *Identity) Env() []string {
+	return []string{"HOME=" + id.Home, "USER=" + id.Username, "LOGNAME=" + id.Username}
+}
+
+func lookupUser(name string) (*user.User, error) {
+	var (
+		u   *user.User
+		err error
+	)
+	if _, numErr := strconv.ParseUint(name, 10, 32); numErr == nil {
+		u, err = user.LookupId(name)
+	} else {
+		u, err = user.Lookup(name)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("lookup runAs user %q: %w", name, err)
+	}
+	return u, nil
+}
+
+func lookupGroup(name string) (*user.Group, error) {
+	var (
+		g   *user.Group
+		err error
+	)
+	if _, numErr := strconv.ParseUint(name, 10, 32); numErr == nil {
+		g, err = user.LookupGroupId(name)
+	} else {
+		g, err = user.LookupGroup(name)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("lookup runAs group %q: %w", name, err)
+	}
+	return g, nil
+}
+
+func parseID(s string) (uint32, error) {
+	n, err := strconv.ParseUint(s, 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("parse id %q: %w", s, err)
+	}
+	return uint32(n), nil
+}
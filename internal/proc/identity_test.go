@@ -0,0 +1,75 @@
+package proc
+
+import (
+	"errors"
+	"os/exec"
+	"os/user"
+	"strconv"
+	"testing"
+)
+
+func TestResolveRequiresAllowlistedUser(t *testing.T) {
+	current := mustCurrentUser(t)
+
+	if _, err := (UserPolicy{}).Resolve(RunAs{User: current.Username}); !errors.Is(err, ErrRunAsDenied) {
+		t.Fatalf("Resolve(empty allowlist) error = %v, want %v", err, ErrRunAsDenied)
+	}
+
+	id, err := UserPolicy{AllowedUsers: []string{current.Uid}}.Resolve(RunAs{User: current.Username})
+	if err != nil {
+		t.Fatalf("Resolve(by uid allowlist) error = %v", err)
+	}
+	if strconv.FormatUint(uint64(id.UID), 10) != current.Uid || id.Home != current.HomeDir {
+		t.Fatalf("Resolve() = %#v, want uid %s home %s", id, current.Uid, current.HomeDir)
+	}
+	if got := id.Env(); got[0] != "HOME="+current.HomeDir || got[1] != "USER="+current.Username {
+		t.Fatalf("Env() = %v, want HOME/USER of %s", got, current.Username)
+	}
+
+	if _, err := (UserPolicy{AllowedUsers: []string{"*"}}).Resolve(RunAs{User: "no-such-user-devops-agent"}); err == nil {
+		t.Fatalf("Resolve(unknown user) error = nil, want lookup error")
+	}
+}
+
+func TestResolveChecksRequestedGroups(t *testing.T) {
+	current := mustCurrentUser(t)
+	other, err := user.LookupGroup("nogroup")
+	if err != nil || other.Gid == current.Gid {
+		t.Skip("no foreign group available")
+	}
+	policy := UserPolicy{AllowedUsers: []string{current.Username}}
+
+	if _, err := policy.Resolve(RunAs{User: current.Username, Group: other.Name}); !errors.Is(err, ErrRunAsDenied) {
+		t.Fatalf("Resolve(foreign group) error = %v, want %v", err, ErrRunAsDenied)
+	}
+
+	policy.AllowedGroups = []string{other.Name}
+	id, err := policy.Resolve(RunAs{User: current.Username, Group: other.Gid, Groups: []string{}})
+	if err != nil {
+		t.Fatalf("Resolve(allowed group) error = %v", err)
+	}
+	if strconv.FormatUint(uint64(id.GID), 10) != other.Gid || len(id.Groups) != 0 {
+		t.Fatalf("Resolve() = %#v, want gid %s and no supplementary groups", id, other.Gid)
+	}
+}
+
+func TestApplyPreservesSysProcAttr(t *testing.T) {
+	id := &Identity{UID: 65534, GID: 65534}
+	cmd := exec.Command("true")
+	id.Apply(cmd)
+	if cmd.SysProcAttr == nil || cmd.SysProcAttr.Credential == nil {
+		t.Skip("credential switch skipped for unprivileged agent")
+	}
+	if cmd.SysProcAttr.Credential.Uid != 65534 {
+		t.Fatalf("Credential.Uid = %d, want 65534", cmd.SysProcAttr.Credential.Uid)
+	}
+}
+
+func mustCurrentUser(t *testing.T) *user.User {
+	t.Helper()
+	current, err := user.Current()
+	if err != nil {
+		t.Fatalf("user.Current() error = %v", err)
+	}
+	return current
+}
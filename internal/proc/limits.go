@@ -0,0 +1,225 @@
+package proc
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"os/exec"
+	"syscall"
+	"time"
+)
+
+// 资源限制名称，用于在结果中标明被触发的限制。
+const (
+	LimitMemory     = "memory"
+	LimitCPUQuota   = "cpuQuota"
+	LimitPids       = "pids"
+	LimitCPUSeconds = "cpuSeconds"
+)
+
+// DefaultCgroupRoot 为任务 cgroup 的默认父目录（cgroup v2 统一层级）。
+const DefaultCgroupRoot = "/sys/fs/cgroup/devops-agent"
+
+// Limits 描述单个任务的资源限制，零值字段表示不限制：
+//   - MemoryBytes：内存上限（cgroup memory.max；无 cgroup 时退化为 RLIMIT_AS）；
+//   - CPUQuota：CPU 配额（核数，如 0.5 表示半个核；仅 cgroup cpu.max 可用）；
+//   - Pids：进程/线程数上限（仅 cgroup pids.max 可用）；
+//   - NoFile：打开文件数上限（RLIMIT_NOFILE）；
+//   - CPUSeconds：累计 CPU 时间上限（RLIMIT_CPU，超出后进程被信号终止）。
+type Limits struct {
+	MemoryBytes int64
+	CPUQuota    float64
+	Pids        int64
+	NoFile      uint64
+	CPUSeconds  uint64
+}
+
+// IsZero 报告是否未设置任何限制。
+func (l Limits) IsZero() bool {
+	return l == Limits{}
+}
+
+// Override 返回以 o 中非零字段覆盖 l 后的限制（任务级覆盖配置默认值）。
+func (l Limits) Override(o Limits) Limits {
+	if o.MemoryBytes > 0 {
+		l.MemoryBytes = o.MemoryBytes
+	}
+	if o.CPUQuota > 0 {
+		l.CPUQuota = o.CPUQuota
+	}
+	if o.Pids > 0 {
+		l.Pids = o.Pids
+	}
+	if o.NoFile > 0 {
+		l.NoFile = o.NoFile
+	}
+	if o.CPUSeconds > 0 {
+		l.CPUSeconds = o.CPUSeconds
+	}
+	return l
+}
+
+func (l Limits) validate() error {
+	if l.MemoryBytes < 0 || l.Pids < 0 || l.CPUQuota < 0 || math.IsNaN(l.CPUQuota) || math.IsInf(l.CPUQuota, 0) {
+		return fmt.Errorf("invalid resource limits %+v", l)
+	}
+	return nil
+}
+
+func (l Limits) needsCgroup() bool {
+	return l.MemoryBytes > 0 || l.CPUQuota > 0 || l.Pids > 0
+}
+
+// Limiter 将 Limits 施加到子进程上：优先把进程放入 CgroupRoot 下的临时 cgroup（随进程组内所有子进程生效），
+// cgroup v2 不可用时退化为对子进程设置 rlimit。CgroupRoot 为空时使用 DefaultCgroupRoot。
+type Limiter struct {
+	CgroupRoot string
+}
+
+// Enforcement 为一次执行施加的限制。调用顺序：Limiter.Prepare → cmd.Start → Started →
+// cmd.Wait → Exceeded → Close。nil 表示未施加任何限制，所有方法均可安全调用。
+//
+// rlimit 在目标程序 exec 之前生效：Prepare 把 cmd 改为经由 rlimit 垫片（Agent 自身的可执行文件）启动，
+// 垫片在同一进程中设置 rlimit 后再 exec 原命令，见 limits_linux.go。
+type Enforcement struct {
+	limits Limits
+	cgroup *cgroup
+	// cgroupFD 为传给 clone3 的 cgroup 目录句柄，进程启动后即可关闭。
+	cgroupFD *os.File
+}
+
+// Prepare 在 cmd.Start 之前调用：按需创建任务 cgroup 并让子进程在其中启动，并让需要的 rlimit 在 exec 前生效。
+func (l Limiter) Prepare(cmd *exec.Cmd, limits Limits) (*Enforcement, error) {
+	if limits.IsZero() {
+		return nil, nil
+	}
+	if err := limits.validate(); err != nil {
+		return nil, err
+	}
+	e := &Enforcement{limits: limits}
+	if limits.needsCgroup() {
+		root := l.CgroupRoot
+		if root == "" {
+			root = DefaultCgroupRoot
+		}
+		cg, err := newCgroup(root, limits)
+		if err == nil {
+			fd, err := cg.attach(cmd)
+			if err != nil {
+				cg.remove()
+				return nil, err
+			}
+			e.cgroup, e.cgroupFD = cg, fd
+		}
+		// cgroup 不可用时退化为 rlimit，实际方式与未能施加的限制由 Mode / Unenforced 报告。
+	}
+	if err := applyRlimits(cmd, e.rlimits()); err != nil {
+		e.Close()
+		return nil, err
+	}
+	return e, nil
+}
+
+// Mode 返回实际使用的限制方式："cgroup"、"rlimit" 或空（未施加）。
+func (e *Enforcement) Mode() string {
+	switch {
+	case e == nil:
+		return ""
+	case e.cgroup != nil:
+		return "cgroup"
+	default:
+		return "rlimit"
+	}
+}
+
+// Unenforced 返回设置了但实际未施加的限制名称（LimitCPUQuota / LimitPids）：二者只能通过 cgroup 施加，
+// cgroup v2 不可用时不生效。内存此时以 RLIMIT_AS 近似，不计入其中。
+func (e *Enforcement) Unenforced() []string {
+	if e == nil || e.cgroup != nil {
+		return nil
+	}
+	var out []string
+	if e.limits.CPUQuota > 0 {
+		out = append(out, LimitCPUQuota)
+	}
+	if e.limits.Pids > 0 {
+		out = append(out, LimitPids)
+	}
+	return out
+}
+
+// Started 在子进程启动后调用，释放只在启动时需要的 cgroup 句柄。
+func (e *Enforcement) Started() {
+	if e == nil {
+		return
+	}
+	if e.cgroupFD != nil {
+		_ = e.cgroupFD.Close()
+		e.cgroupFD = nil
+	}
+}
+
+// rlimits 返回需要通过 setrlimit 施加的限制；内存仅在没有 cgroup 时以地址空间上限近似。
+func (e *Enforcement) rlimits() []rlimit {
+	var out []rlimit
+	if e.limits.NoFile > 0 {
+		out = append(out, rlimit{resource: rlimitNoFile, soft: e.limits.NoFile, hard: e.limits.NoFile})
+	}
+	if e.limits.CPUSeconds > 0 {
+		// 软限制触发 SIGXCPU，硬限制再多 1 秒后 SIGKILL，防止进程忽略 SIGXCPU。
+		out = append(out, rlimit{resource: rlimitCPU, soft: e.limits.CPUSeconds, hard: e.limits.CPUSeconds + 1})
+	}
+	if e.cgroup == nil && e.limits.MemoryBytes > 0 {
+		out = append(out, rlimit{resource: rlimitAS, soft: uint64(e.limits.MemoryBytes), hard: uint64(e.limits.MemoryBytes)})
+	}
+	return out
+}
+
+// Exceeded 在 cmd.Wait 之后调用，返回被触发的限制名称（LimitMemory 等），未触发时返回空。
+//
+// terminated 表示 Agent 已因超时或取消向进程发送过终止信号。RLIMIT_CPU 在软上限发送 SIGXCPU、
+// 在硬上限发送 SIGKILL；SIGKILL 只在 Agent 未终止进程且实测 CPU 时间达到上限时才归因于 cpuSeconds，
+// 超时终止的进程即便 CPU 时间已接近上限也不会被误报。
+func (e *Enforcement) Exceeded(state *os.ProcessState, terminated bool) string {
+	if e == nil {
+		return ""
+	}
+	if e.cgroup != nil {
+		if name := e.cgroup.exceeded(); name != "" {
+			return name
+		}
+	}
+	if e.limits.CPUSeconds > 0 && state != nil {
+		if ws, ok := state.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+			used := state.UserTime() + state.SystemTime()
+			switch ws.Signal() {
+			case syscall.SIGXCPU:
+				return LimitCPUSeconds
+			case syscall.SIGKILL:
+				if !terminated && used >= time.Duration(e.limits.CPUSeconds)*time.Second {
+					return LimitCPUSeconds
+				}
+			}
+		}
+	}
+	return ""
+}
+
+// Close 结束限制：杀死 cgroup 中残留的进程并删除 cgroup。
+func (e *Enforcement) Close() {
+	if e == nil {
+		return
+	}
+	if e.cgroupFD != nil {
+		_ = e.cgroupFD.Close()
+		e.cgroupFD = nil
+	}
+	if e.cgroup != nil {
+		e.cgroup.remove()
+	}
+}
+
+type rlimit struct {
+	resource   int
+	soft, hard uint64
+}
@@ -0,0 +1,271 @@
+//go:build linux
+
+package proc
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	rlimitNoFile = unix.RLIMIT_NOFILE
+	rlimitCPU    = unix.RLIMIT_CPU
+	rlimitAS     = unix.RLIMIT_AS
+)
+
+// cpuPeriod 为 cpu.max 的调度周期（微秒）。
+const cpuPeriod = 100000
+
+// cgroup 为单个任务的临时 cgroup v2 目录。
+type cgroup struct {
+	dir string
+}
+
+// newCgroup 在 root 下创建任务 cgroup 并写入限制；root 不在 cgroup v2 层级或控制器不可用时返回错误。
+func newCgroup(root string, limits Limits) (*cgroup, error) {
+	controllers := map[string]bool{}
+	if limits.MemoryBytes > 0 {
+		controllers["memory"] = true
+	}
+	if limits.CPUQuota > 0 {
+		controllers["cpu"] = true
+	}
+	if limits.Pids > 0 {
+		controllers["pids"] = true
+	}
+	if err := prepareCgroupRoot(root, controllers); err != nil {
+		return nil, err
+	}
+
+	suffix := make([]byte, 6)
+	if _, err := rand.Read(suffix); err != nil {
+		return nil, fmt.Errorf("generate cgroup name: %w", err)
+	}
+	dir := filepath.Join(root, "task-"+hex.EncodeToString(suffix))
+	if err := os.Mkdir(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("create cgroup: %w", err)
+	}
+	cg := &cgroup{dir: dir}
+
+	var writes [][2]string
+	if limits.MemoryBytes > 0 {
+		writes = append(writes, [2]string{"memory.max", strconv.FormatInt(limits.MemoryBytes, 10)})
+	}
+	if limits.CPUQuota > 0 {
+		quota := int64(limits.CPUQuota * cpuPeriod)
+		if quota < 1000 {
+			quota = 1000 // 内核要求 quota 不小于 1ms
+		}
+		writes = append(writes, [2]string{"cpu.max", fmt.Sprintf("%d %d", quota, cpuPeriod)})
+	}
+	if limits.Pids > 0 {
+		writes = append(writes, [2]string{"pids.max", strconv.FormatInt(limits.Pids, 10)})
+	}
+	for _, w := range writes {
+		if err := writeCgroupFile(dir, w[0], w[1]); err != nil {
+			cg.remove()
+			return nil, err
+		}
+	}
+	if limits.MemoryBytes > 0 {
+		// 禁用 swap，使超出 memory.max 时直接 OOM 而不是换出；未启用 swap 记账时忽略。
+		_ = writeCgroupFile(dir, "memory.swap.max", "0")
+	}
+	return cg, nil
+}
+
+// prepareCgroupRoot 确保 root 存在且其子 cgroup 启用了所需控制器。
+func prepareCgroupRoot(root string, controllers map[string]bool) error {
+	parent := filepath.Dir(root)
+	if _, err := os.Stat(filepath.Join(parent, "cgroup.controllers")); err != nil {
+		return fmt.Errorf("cgroup v2 not available at %s: %w", parent, err)
+	}
+	if err := os.MkdirAll(root, 0o755); err != nil {
+		return fmt.Errorf("create cgroup root: %w", err)
+	}
+	enabled, err := readCgroupFields(filepath.Join(root, "cgroup.subtree_control"))
+	if err != nil {
+		return err
+	}
+	for name := range controllers {
+		if enabled[name] {
+			continue
+		}
+		available, err := readCgroupFields(filepath.Join(root, "cgroup.controllers"))
+		if err != nil {
+			return err
+		}
+		if !available[name] {
+			// 先在父级启用，root 才能把控制器继续下放给任务 cgroup。
+			if err := writeCgroupFile(parent, "cgroup.subtree_control", "+"+name); err != nil {
+				return err
+			}
+		}
+		if err := writeCgroupFile(root, "cgroup.subtree_control", "+"+name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// attach 让 cmd 通过 clone3(CLONE_INTO_CGROUP) 直接在任务 cgroup 中启动，避免启动后再迁移的竞态。
+func (c *cgroup) attach(cmd *exec.Cmd) (*os.File, error) {
+	fd, err := os.Open(c.dir)
+	if err != nil {
+		return nil, fmt.Errorf("open cgroup: %w", err)
+	}
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.UseCgroupFD = true
+	cmd.SysProcAttr.CgroupFD = int(fd.Fd())
+	return fd, nil
+}
+
+// exceeded 根据 memory.events / pids.events 判断是否触发了限制。
+func (c *cgroup) exceeded() string {
+	if events, err := readCgroupKeyed(filepath.Join(c.dir, "memory.events")); err == nil && events["oom_kill"] > 0 {
+		return LimitMemory
+	}
+	if events, err := readCgroupKeyed(filepath.Join(c.dir, "pids.events")); err == nil && events["max"] > 0 {
+		return LimitPids
+	}
+	return ""
+}
+
+// remove 杀死 cgroup 中残留的进程（如脱离进程组的后台进程）后删除目录。
+func (c *cgroup) remove() {
+	_ = writeCgroupFile(c.dir, "cgroup.kill", "1")
+	for i := 0; i < 50; i++ {
+		err := os.Remove(c.dir)
+		if err == nil || errors.Is(err, os.ErrNotExist) {
+			return
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+// rlimit 垫片通过以下环境变量接收参数，exec 目标程序前会将其删除。
+const (
+	rlimitExecEnv   = "DEVOPS_AGENT_RLIMIT_EXEC"
+	rlimitLimitsEnv = "DEVOPS_AGENT_RLIMITS"
+)
+
+// rlimitShimPath 为垫片的可执行文件：子进程 fork 后 exec 之前，/proc/self/exe 仍指向 Agent 自身，
+// 即使其文件已在升级中被替换或删除。
+const rlimitShimPath = "/proc/self/exe"
+
+// applyRlimits 让 cmd 经由 rlimit 垫片启动：cmd.Path 换为 Agent 自身，原路径与限制经环境变量传递，
+// 参数（含 argv[0]）、工作目录、进程组与运行用户均不变。垫片在 exec 原命令前设置 rlimit，
+// 因此限制从目标程序的第一条指令起即生效，不存在启动后再 prlimit 的时间窗口。
+func applyRlimits(cmd *exec.Cmd, limits []rlimit) error {
+	if len(limits) == 0 {
+		return nil
+	}
+	encoded := make([]string, 0, len(limits))
+	for _, l := range limits {
+		encoded = append(encoded, fmt.Sprintf("%d:%d:%d", l.resource, l.soft, l.hard))
+	}
+	env := cmd.Env
+	if env == nil {
+		env = os.Environ()
+	}
+	cmd.Env = append(append([]string(nil), env...),
+		rlimitExecEnv+"="+cmd.Path,
+		rlimitLimitsEnv+"="+strings.Join(encoded, ","))
+	cmd.Path = rlimitShimPath
+	return nil
+}
+
+// init 在进程作为 rlimit 垫片启动时（见 applyRlimits）设置 rlimit 并 exec 原命令，不会返回；
+// 其他情况下什么也不做。放在 init 中可使依赖本包的任何程序（包括测试二进制）都能充当垫片。
+func init() {
+	target, ok := os.LookupEnv(rlimitExecEnv)
+	if !ok {
+		return
+	}
+	if err := execWithRlimits(target, os.Getenv(rlimitLimitsEnv)); err != nil {
+		fmt.Fprintf(os.Stderr, "devops-agent: rlimit shim: %v\n", err)
+		os.Exit(127)
+	}
+}
+
+func execWithRlimits(target, encoded string) error {
+	env := make([]string, 0, len(os.Environ()))
+	for _, kv := range os.Environ() {
+		if !strings.HasPrefix(kv, rlimitExecEnv+"=") && !strings.HasPrefix(kv, rlimitLimitsEnv+"=") {
+			env = append(env, kv)
+		}
+	}
+	for _, item := range strings.Split(encoded, ",") {
+		var (
+			resource   int
+			soft, hard uint64
+		)
+		if _, err := fmt.Sscanf(item, "%d:%d:%d", &resource, &soft, &hard); err != nil {
+			return fmt.Errorf("parse limit %q: %w", item, err)
+		}
+		// 使用 syscall.Setrlimit：它会清除 Go 运行时保存的原始 RLIMIT_NOFILE，避免 Exec 时被恢复。
+		if err := syscall.Setrlimit(resource, &syscall.Rlimit{Cur: soft, Max: hard}); err != nil {
+			return fmt.Errorf("set rlimit %d: %w", resource, err)
+		}
+	}
+	if err := syscall.Exec(target, os.Args, env); err != nil {
+		return fmt.Errorf("exec %s: %w", target, err)
+	}
+	return nil
+}
+
+func writeCgroupFile(dir, name, value string) error {
+	if err := os.WriteFile(filepath.Join(dir, name), []byte(value), 0o644); err != nil {
+		return fmt.Errorf("write %s: %w", name, err)
+	}
+	return nil
+}
+
+// readCgroupFields 读取以空格分隔的控制器列表（cgroup.controllers / cgroup.subtree_control）。
+func readCgroupFields(path string) (map[string]bool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read %s: %w", filepath.Base(path), err)
+	}
+	out := map[string]bool{}
+	for _, f := range strings.Fields(string(data)) {
+		out[f] = true
+	}
+	return out, nil
+}
+
+// readCgroupKeyed 读取 "key value" 格式的 cgroup 文件（memory.events 等）。
+func readCgroupKeyed(path string) (map[string]int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	out := map[string]int64{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(scanner.Text(), " ")
+		if !ok {
+			continue
+		}
+		n, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+		if err == nil {
+			out[key] = n
+		}
+	}
+	return out, scanner.Err()
+}
@@ -0,0 +1,33 @@
+//go:build !linux
+
+package proc
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+)
+
+// 非 Linux 平台没有 cgroup 与 rlimit 垫片，资源限制不生效。
+const (
+	rlimitNoFile = iota
+	rlimitCPU
+	rlimitAS
+)
+
+type cgroup struct{}
+
+func newCgroup(string, Limits) (*cgroup, error) {
+	return nil, errors.New("cgroup not supported on this platform")
+}
+
+func (c *cgroup) attach(*exec.Cmd) (*os.File, error) { return nil, errors.ErrUnsupported }
+func (c *cgroup) exceeded() string                   { return "" }
+func (c *cgroup) remove()                            {}
+
+func applyRlimits(_ *exec.Cmd, limits []rlimit) error {
+	if len(limits) > 0 {
+		return errors.New("resource limits not supported on this platform")
+	}
+	return nil
+}
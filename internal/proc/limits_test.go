@@ -0,0 +1,95 @@
+package proc
+
+import (
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+func TestLimitsOverride(t *testing.T) {
+	defaults := Limits{MemoryBytes: 512 << 20, NoFile: 1024, CPUSeconds: 60}
+	got := defaults.Override(Limits{MemoryBytes: 64 << 20, Pids: 32})
+	want := Limits{MemoryBytes: 64 << 20, Pids: 32, NoFile: 1024, CPUSeconds: 60}
+	if got != want {
+		t.Fatalf("Override() = %+v, want %+v", got, want)
+	}
+	if !(Limits{}).IsZero() || got.IsZero() {
+		t.Fatalf("IsZero() mismatch")
+	}
+}
+
+func TestLimiterFallsBackToRlimit(t *testing.T) {
+	limiter := Limiter{CgroupRoot: filepath.Join(t.TempDir(), "not-a-cgroup", "agent")}
+
+	e, err := limiter.Prepare(exec.Command("true"), Limits{})
+	if err != nil || e != nil || e.Mode() != "" {
+		t.Fatalf("Prepare(zero) = %v, %v; want no enforcement", e, err)
+	}
+
+	cmd := exec.Command("sh", "-c", "ulimit -n")
+	e, err = limiter.Prepare(cmd, Limits{MemoryBytes: 1 << 30, NoFile: 64})
+	if err != nil {
+		t.Fatalf("Prepare() error = %v", err)
+	}
+	defer e.Close()
+	if e.Mode() != "rlimit" {
+		t.Fatalf("Mode() = %q, want rlimit", e.Mode())
+	}
+	if got := e.Unenforced(); len(got) != 0 {
+		t.Fatalf("Unenforced() = %v, want none", got)
+	}
+	out, err := startAndOutput(t, cmd, e)
+	if err != nil || out != "64\n" {
+		t.Fatalf("ulimit -n = %q, %v; want 64", out, err)
+	}
+	if got := e.Exceeded(cmd.ProcessState, false); got != "" {
+		t.Fatalf("Exceeded() = %q, want none", got)
+	}
+
+	e, err = limiter.Prepare(exec.Command("true"), Limits{CPUQuota: 0.5, Pids: 16, NoFile: 64})
+	if err != nil {
+		t.Fatalf("Prepare(cgroup-only) error = %v", err)
+	}
+	defer e.Close()
+	if got := e.Unenforced(); len(got) != 2 || got[0] != LimitCPUQuota || got[1] != LimitPids {
+		t.Fatalf("Unenforced() = %v, want [cpuQuota pids]", got)
+	}
+
+	if _, err := limiter.Prepare(exec.Command("true"), Limits{Pids: -1}); err == nil {
+		t.Fatalf("Prepare(negative) error = nil, want error")
+	}
+}
+
+func TestRlimitShimPreservesArgvAndEnv(t *testing.T) {
+	limiter := Limiter{CgroupRoot: filepath.Join(t.TempDir(), "not-a-cgroup", "agent")}
+	cmd := exec.Command("sh", "-c", `ulimit -n; echo "$0 $1"; echo "$KEEP"; env | grep -c '^DEVOPS_AGENT_' || true`, "shell-name", "arg1")
+	cmd.Env = []string{"KEEP=yes"}
+	e, err := limiter.Prepare(cmd, Limits{NoFile: 32})
+	if err != nil {
+		t.Fatalf("Prepare() error = %v", err)
+	}
+	defer e.Close()
+
+	out, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("Output() error = %v", err)
+	}
+	if want := "32\nshell-name arg1\nyes\n0\n"; string(out) != want {
+		t.Fatalf("output = %q, want %q", out, want)
+	}
+}
+
+func startAndOutput(t *testing.T, cmd *exec.Cmd, e *Enforcement) (string, error) {
+	t.Helper()
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatalf("StdoutPipe() error = %v", err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	e.Started()
+	buf := make([]byte, 64)
+	n, _ := stdout.Read(buf)
+	return string(buf[:n]), cmd.Wait()
+}
@@ -12,6 +12,8 @@ const (
 	EventCommandPush           = "command.push"
 	EventResultChunk           = "result.chunk"
 	EventResultAck             = "result.ack"
+	EventCommandStdinWrite     = "command.stdin.write"
+	EventCommandStdinClose     = "command.stdin.close"
 	EventTerminalSessionOpen   = "terminal.session.open"
 	EventTerminalSessionOpened = "terminal.session.opened"
 	EventTerminalStdinWrite    = "terminal.stdin.write"
@@ -22,8 +24,12 @@ const (
 	EventTerminalSessionClose  = "terminal.session.close"
 	EventTerminalSessionClosed = "terminal.session.closed"
 	EventTerminalSessionError  = "terminal.session.error"
+	EventFrameRejected         = "frame.rejected"
+	EventAuthTokenRotate       = "auth.token.rotate"
+	EventRedactionUpdate       = "redaction.update"
 
-	MethodConnect = "connect"
+	MethodConnect          = "connect"
+	MethodAuthTokenRefresh = "auth.token.refresh"
 )
 
 // EventFrame 表示服务端或客户端发送的事件帧，例如 connect.challenge、agent.tick、command.push。
@@ -32,11 +38,15 @@ const (
 //   - result.chunk: Agent → Server，流式回传执行结果分片；
 //   - result.ack:   Server → Agent，确认已持久化特定分片。
 //
+// 对于 command.push、terminal.session.open 等高危事件，网关需在 sig 字段中携带
+// 对 "event|payload" 的 Ed25519 签名（Base64），payload 为帧中原样传输的 JSON 字节。
+//
 // type 字段固定为 "event"。
 type EventFrame struct {
-	Type    string      `json:"type"`
-	Event   string      `json:"event"`
-	Payload interface{} `json:"payload"`
+	Type      string      `json:"type"`
+	Event     string      `json:"event"`
+	Payload   interface{} `json:"payload"`
+	Signature string      `json:"sig,omitempty"`
 }
 
 // RequestFrame 表示请求帧，例如 connect、后续可扩展的 RPC 方法。
@@ -52,12 +62,16 @@ type RequestFrame struct {
 }
 
 // ResponseFrame 表示响应帧，对应某个请求 ID。
+//
+// 会改变 Agent 凭据的响应（auth.token.refresh）须在 sig 字段中携带网关对 "method|payload" 的签名，
+// 格式与事件帧相同。
 type ResponseFrame struct {
-	Type    string      `json:"type"`
-	ID      string      `json:"id"`
-	OK      bool        `json:"ok"`
-	Payload interface{} `json:"payload,omitempty"`
-	Error   *ErrorBody  `json:"error,omitempty"`
+	Type      string      `json:"type"`
+	ID        string      `json:"id"`
+	OK        bool        `json:"ok"`
+	Payload   interface{} `json:"payload,omitempty"`
+	Error     *ErrorBody  `json:"error,omitempty"`
+	Signature string      `json:"sig,omitempty"`
 }
 
 // ErrorBody 是最小错误结构，MVP 中仅保留 code/message。
@@ -65,8 +79,13 @@ type ResponseFrame struct {
 type ErrorBody struct {
 	Code    string `json:"code"`
 	Message string `json:"message"`
+	// RetryAfterMs 为服务端建议的重试间隔（毫秒），目前用于注册待审批状态。
+	RetryAfterMs int64 `json:"retryAfterMs,omitempty"`
 }
 
+// ErrorCodeEnrollmentPending 表示注册请求已受理但尚待管理员审批。
+const ErrorCodeEnrollmentPending = "ENROLLMENT_PENDING"
+
 // ChallengePayload 对应 connect.challenge 事件的负载。
 type ChallengePayload struct {
 	Nonce string `json:"nonce"`
@@ -93,6 +112,20 @@ type DeviceInfo struct {
 // AuthInfo 包装静态网关 Token 或后续扩展的鉴权信息。
 type AuthInfo struct {
 	Token string `json:"token"`
+	// EnrollmentToken 为首次注册使用的一次性令牌，与 Token 互斥。
+	EnrollmentToken string `json:"enrollmentToken,omitempty"`
+}
+
+// HostFacts 是注册时上报的主机信息，供管理员审批时核对。
+type HostFacts struct {
+	Hostname        string   `json:"hostname"`
+	OS              string   `json:"os"`
+	Arch            string   `json:"arch"`
+	Platform        string   `json:"platform,omitempty"`
+	PlatformVersion string   `json:"platformVersion,omitempty"`
+	KernelVersion   string   `json:"kernelVersion,omitempty"`
+	MachineID       string   `json:"machineId,omitempty"`
+	IPs             []string `json:"ips,omitempty"`
 }
 
 // ConnectParams 是 connect 请求的参数结构。
@@ -104,6 +137,20 @@ type ConnectParams struct {
 	Scopes      []string   `json:"scopes"`
 	Device      DeviceInfo `json:"device"`
 	Auth        AuthInfo   `json:"auth"`
+	// Rotation 仅在 Agent 轮换设备密钥时携带：device 字段使用新密钥，Rotation 由旧密钥证明连续性。
+	Rotation *KeyRotation `json:"rotation,omitempty"`
+	// Host 仅在使用 enrollmentToken 注册时携带。
+	Host *HostFacts `json:"host,omitempty"`
+}
+
+// KeyRotation 描述设备密钥轮换的连续性证明。
+//
+// Signature 为旧私钥对 "rotate|previousDeviceId|newPublicKey|nonce" 的签名（Base64），
+// 服务端校验后将 previousDeviceId 对应的设备记录重新绑定到新公钥。
+type KeyRotation struct {
+	PreviousDeviceID  string `json:"previousDeviceId"`
+	PreviousPublicKey string `json:"previousPublicKey"`
+	Signature         string `json:"signature"`
 }
 
 // HelloPolicy 在 hello-ok 中返回的策略信息。
@@ -112,18 +159,55 @@ type HelloPolicy struct {
 }
 
 // HelloAuth 描述服务端下发的设备令牌等信息。
+//
+// ExpiresAt 为当前生效 deviceToken 的过期时间（毫秒时间戳）；即使本次未下发新 token，
+// 服务端也应返回正在使用的 token 的过期时间，供 Agent 提前续期。
 type HelloAuth struct {
 	DeviceToken string   `json:"deviceToken"`
+	ExpiresAt   int64    `json:"expiresAt,omitempty"`
 	Role        string   `json:"role"`
 	Scopes      []string `json:"scopes"`
 }
 
+// TokenRefreshParams 是 auth.token.refresh 请求的参数。
+type TokenRefreshParams struct {
+	DeviceID string `json:"deviceId"`
+}
+
+// TokenRotatePayload 对应 auth.token.rotate 事件负载，同时也是 auth.token.refresh 响应负载。
+type TokenRotatePayload struct {
+	DeviceToken string `json:"deviceToken"`
+	ExpiresAt   int64  `json:"expiresAt,omitempty"`
+}
+
+// RedactionUpdatePayload 对应 redaction.update 事件负载：Values 为 server 要求遮蔽的字面值
+// （如下发给命令的密钥），整体替换此前下发的集合，空列表表示清空。
+type RedactionUpdatePayload struct {
+	Values []string `json:"values"`
+}
+
+// HelloGateway 描述网关身份：Ed25519 公钥及其对本次握手的签名。
+//
+// Signature 为网关私钥对 "connectRequestId|deviceId|nonce" 的签名（Base64），
+// 其中 connectRequestId 由 Agent 随机生成，保证签名无法跨连接重放。
+type HelloGateway struct {
+	PublicKey string `json:"publicKey"`
+	Signature string `json:"signature"`
+}
+
 // HelloOkPayload 是 hello-ok 响应负载。
 type HelloOkPayload struct {
-	Type     string      `json:"type"`
-	Protocol int         `json:"protocol"`
-	Policy   HelloPolicy `json:"policy"`
-	Auth     *HelloAuth  `json:"auth,omitempty"`
+	Type     string         `json:"type"`
+	Protocol int            `json:"protocol"`
+	Policy   HelloPolicy    `json:"policy"`
+	Auth     *HelloAuth     `json:"auth,omitempty"`
+	Gateway  *HelloGateway  `json:"gateway,omitempty"`
+	Rotation *HelloRotation `json:"rotation,omitempty"`
+}
+
+// HelloRotation 为服务端对密钥轮换请求的确认，Status 为 "accepted" 时 Agent 提交轮换。
+type HelloRotation struct {
+	Status string `json:"status"`
 }
 
 // HeartbeatPayload 对应 agent.tick 心跳事件负载。
@@ -145,12 +229,128 @@ type MetricsSnapshot struct {
 
 // CommandPushPayload 对应 command.push.
 type CommandPushPayload struct {
-	TaskUUID       string `json:"task_uuid"`
-	Command        string `json:"command"`
-	CorrelationID  string `json:"correlationId"`
-	TimeoutSeconds int    `json:"timeoutSeconds"`
+	TaskUUID string `json:"task_uuid"`
+	// Command 为 shell 模式（sh -c）的命令文本，与 Argv 互斥。
+	Command string `json:"command"`
+	// Argv 非空时以 argv 模式直接执行、不经过 shell；Executable 可指定可执行文件（默认 Argv[0]），
+	// 裸名称只在 Agent 的 shell.execPath 中查找。
+	Argv       []string `json:"argv,omitempty"`
+	Executable string   `json:"executable,omitempty"`
+	// Script 非空时为脚本任务，与 Command / Argv 互斥。
+	Script         *ScriptPayload `json:"script,omitempty"`
+	CorrelationID  string         `json:"correlationId"`
+	TimeoutSeconds int            `json:"timeoutSeconds"`
 	// WorkDir 为本次命令执行的工作目录；为空时使用 Agent 侧默认（shell.workDir 或进程当前目录）。
 	WorkDir string `json:"workDir,omitempty"`
+	// Env 为任务级环境变量，受 Agent 的 env.deny 与 AGENT_* 清除策略约束。
+	Env map[string]string `json:"env,omitempty"`
+	// RunAs 为命令的运行身份，须在 Agent 的 runAs 白名单内；为空时以 Agent 自身身份运行。
+	RunAs *RunAs `json:"runAs,omitempty"`
+	// Limits 为任务级资源限制，非零字段覆盖 Agent 的 limits 配置。
+	Limits *ResourceLimits `json:"limits,omitempty"`
+	// Output 为任务级输出上限，非零字段覆盖 Agent 的 output 配置。
+	Output *OutputLimits `json:"output,omitempty"`
+	// Termination 为任务级超时终止策略，非零字段覆盖 Agent 的 termination 配置。
+	Termination *TerminationPolicy `json:"termination,omitempty"`
+	// Retry 为瞬时失败的自动重试策略；为空或 maxAttempts≤1 时只执行一次。
+	Retry *RetryPolicy `json:"retry,omitempty"`
+	// Stdin 为内联的标准输入数据（Base64）。StdinStream=true 时 stdin 保持打开，
+	// 后续数据通过 command.stdin.write / command.stdin.close 事件送达；否则内联数据写完即 EOF。
+	Stdin       string `json:"stdin,omitempty"`
+	StdinStream bool   `json:"stdinStream,omitempty"`
+	// TTY 为 true 时命令在伪终端中运行（窗口 Cols×Rows，默认 80×24；TERM 默认 xterm-256color），
+	// stdout / stderr 合并为终端输出，以 stdoutChunk 回传；stdin（内联或流式）写入终端，结束时发送 Ctrl-D。
+	TTY  bool   `json:"tty,omitempty"`
+	Cols int    `json:"cols,omitempty"`
+	Rows int    `json:"rows,omitempty"`
+	Term string `json:"term,omitempty"`
+	// IssuedAt / ExpiresAt 为推送的签发与过期时间（毫秒时间戳），Nonce 为一次性随机串，
+	// 用于 Agent 拒绝过期或重复（重放）的推送。
+	IssuedAt  int64  `json:"issuedAt,omitempty"`
+	ExpiresAt int64  `json:"expiresAt,omitempty"`
+	Nonce     string `json:"nonce,omitempty"`
+	// Targets 为运维人员授权执行该命令的设备 ID 列表，包含在运维人员签名内；
+	// 携带运维人员签名的命令只在列出的设备上执行，防止截获的签名命令被转发到集群中的其他 Agent。
+	Targets []string `json:"targets,omitempty"`
+	// Operator 为授权该命令的运维人员签名；高危命令必须携带。
+	Operator *OperatorAuth `json:"operator,omitempty"`
+}
+
+// OutputLimits 描述命令输出上限（字节），字段为 0 表示沿用 Agent 默认值；
+// 超出上限时保留各流的头部与末尾 TailBytes 字节，中间部分丢弃。
+type OutputLimits struct {
+	MaxStdoutBytes int64 `json:"maxStdoutBytes,omitempty"`
+	MaxStderrBytes int64 `json:"maxStderrBytes,omitempty"`
+	TailBytes      int64 `json:"tailBytes,omitempty"`
+}
+
+// TerminationPolicy 描述命令超时后的终止策略：先向进程组发送 Signal（SIGTERM / SIGINT / SIGHUP / SIGQUIT / SIGKILL），
+// GraceSeconds 秒后输出仍未结束再发送 SIGKILL。字段为零值表示沿用 Agent 默认值。
+type TerminationPolicy struct {
+	Signal       string `json:"signal,omitempty"`
+	GraceSeconds int    `json:"graceSeconds,omitempty"`
+}
+
+// RetryPolicy 描述命令失败后的自动重试策略（同一 task_uuid 内的多次尝试，每次尝试的分片带 attempt 序号）：
+//   - MaxAttempts: 总尝试次数（含首次，至多 10）；
+//   - BackoffMs / MaxBackoffMs: 第一次重试前的等待时间（毫秒），之后每次翻倍，MaxBackoffMs 为上限（0 表示不限制）；
+//   - ExitCodes: 可重试的退出码（超时或被信号终止为 -1），为空时任何非 0 退出码均重试；
+//   - StderrPattern: 非空时 stderr（PTY 模式为终端输出）还须匹配该正则（RE2 语法）才重试。
+//
+// 重试要求 stdin 可重放：内联 stdin 每次尝试重新写入，stdinStream=true 时不允许重试。
+type RetryPolicy struct {
+	MaxAttempts   int    `json:"maxAttempts"`
+	BackoffMs     int    `json:"backoffMs,omitempty"`
+	MaxBackoffMs  int    `json:"maxBackoffMs,omitempty"`
+	ExitCodes     []int  `json:"exitCodes,omitempty"`
+	StderrPattern string `json:"stderrPattern,omitempty"`
+}
+
+// ScriptPayload 描述脚本任务：Body 为脚本内容，SHA256 为其十六进制摘要（必填，Agent 校验后才执行），
+// Interpreter 为解释器名称或绝对路径（须在 Agent 的 shell.interpreters 白名单内，默认 sh），Args 为脚本参数。
+type ScriptPayload struct {
+	Body        string   `json:"body"`
+	Interpreter string   `json:"interpreter,omitempty"`
+	Args        []string `json:"args,omitempty"`
+	SHA256      string   `json:"sha256"`
+}
+
+// CommandStdinWritePayload 对应 command.stdin.write 事件负载。
+//
+// Seq 从 1 开始，与 command.stdin.close 共用序号空间；Agent 按 seq 顺序写入子进程，
+// Data 为 Base64 编码的原始字节。
+type CommandStdinWritePayload struct {
+	TaskUUID string `json:"task_uuid"`
+	Seq      int    `json:"seq"`
+	Data     string `json:"data"`
+}
+
+// CommandStdinClosePayload 对应 command.stdin.close 事件负载，Seq 之前的分片写完后关闭子进程 stdin。
+type CommandStdinClosePayload struct {
+	TaskUUID string `json:"task_uuid"`
+	Seq      int    `json:"seq"`
+}
+
+// ResourceLimits 描述命令的资源限制，字段为 0 表示沿用 Agent 默认值：
+//   - MemoryMB：内存上限（MiB）；CPUQuota：CPU 配额（核数，可为小数）；Pids：进程/线程数上限；
+//   - NoFile：打开文件数上限；CPUSeconds：累计 CPU 时间上限（秒）。
+type ResourceLimits struct {
+	MemoryMB   int64   `json:"memoryMb,omitempty"`
+	CPUQuota   float64 `json:"cpuQuota,omitempty"`
+	Pids       int64   `json:"pids,omitempty"`
+	NoFile     uint64  `json:"noFile,omitempty"`
+	CPUSeconds uint64  `json:"cpuSeconds,omitempty"`
+}
+
+// OperatorAuth 描述运维人员对命令的签名。
+//
+// Signature 为运维人员私钥对 "command.push|<payload>" 的签名（Base64），
+// 其中 payload 为帧中原始负载去掉顶层 operator 字段后的规范 JSON：各层对象的键按字典序排列、
+// 无多余空白、数字保留原文、字符串不转义 HTML 字符（见 operator.SigningMessage）。
+// 签名覆盖包括 targets 与 Agent 不认识的字段在内的全部字段。
+type OperatorAuth struct {
+	ID        string `json:"id"`
+	Signature string `json:"signature"`
 }
 
 // ResultChunkPayload 对应 result.chunk 事件的负载。
@@ -158,17 +358,88 @@ type CommandPushPayload struct {
 // Agent 侧会将执行结果切分为多个分片按顺序回传：
 //   - Seq: 从 1 开始递增的分片序号；
 //   - StdoutChunk / StderrChunk: 本分片携带的 stdout/stderr 内容（二选一或都为空）；
+//   - Stream / Offset: 输出分片与截断标记所属的流（stdout / stderr）及首字节在该流原始输出中的字节偏移，
+//     Server 可按偏移逐字节重建各流（偏移不连续处即为截断）；Stream 为空表示 Agent 生成的消息；
+//   - ElapsedUs: 相对进程启动的单调时间（微秒），输出分片为首字节被读到的时间，可用于合并 stdout/stderr 时间线；
 //   - Final: 是否为最后一个分片；
-//   - ExitCode: 仅在 Final=true 的最后一个分片中填写退出码，其余分片为空。
+//   - ExitCode: 仅在 Final=true 的最后一个分片中填写退出码，其余分片为空；
+//   - LimitExceeded: 仅在最后一个分片中填写，标明导致进程被终止的资源限制（memory / pids / cpuSeconds）；
+//   - LimitMode / UnenforcedLimits: 仅在最后一个分片中填写（命令设置了资源限制时），限制的实际施加方式
+//     （cgroup / rlimit）及设置了但未生效的限制（cgroup v2 不可用时的 cpuQuota / pids）；
+//   - Encoding: 本分片 stdoutChunk / stderrChunk 的编码，省略时为 UTF-8 文本（分片在字符边界处切分），
+//     "base64" 表示 Base64 编码的原始字节（Agent 检测到非文本输出后，该流的后续分片均为 base64）；
+//   - Truncated: 截断标记分片（不携带输出），表示该流在此处丢弃了 droppedBytes 字节，之后为流的末尾；
+//   - StdoutBytes / StderrBytes / StdoutDroppedBytes / StderrDroppedBytes / OutputDropped: 仅在最后一个分片中填写，
+//     为各流产生的总字节数、因输出上限丢弃的字节数以及是否有输出被丢弃；
+//   - TimedOut / TerminationSignal / Escalated: 仅在最后一个分片中填写，命令是否因超时被终止、Agent 最后发送的信号
+//     （如 SIGTERM / SIGKILL），以及宽限期过后是否升级到了 SIGKILL；
+//   - Redactions: 仅在最后一个分片中填写，Agent 在两个流中遮蔽的密钥个数（输出中替换为 "[REDACTED]"）；
+//   - Execution: 仅在最后一个分片中填写（进程成功启动时），执行元数据与资源用量，见 ExecutionInfo；
+//   - Attempt / RetryAfterMs: 命令携带 retry 策略时填写，分片所属的尝试序号（从 1 开始）；将被重试的尝试以
+//     isFinal=false、retryAfterMs 为下一次尝试前等待时间的汇总分片结束，该分片同样填写上述"仅在最后一个分片中"
+//     的字段（exitCode 等），只有最后一次尝试的汇总分片 isFinal=true，即任务的最终结果；
+//   - ErrorCode / PolicyRuleID: 命令未执行即被拒绝时填写（如本地策略拒绝：POLICY_DENIED 与命中的规则 ID）；
+//   - OperatorID: 授权该命令的运维人员（命令携带了有效运维人员签名时）。
 type ResultChunkPayload struct {
-	TaskUUID      string `json:"task_uuid"`
-	CorrelationID string `json:"correlationId"`
-	AgentID       string `json:"agentId"`
-	Seq           int    `json:"seq"`
-	StdoutChunk   string `json:"stdoutChunk,omitempty"`
-	StderrChunk   string `json:"stderrChunk,omitempty"`
-	ExitCode      *int   `json:"exitCode,omitempty"`
-	Final         bool   `json:"isFinal"`
+	TaskUUID           string            `json:"task_uuid"`
+	CorrelationID      string            `json:"correlationId"`
+	AgentID            string            `json:"agentId"`
+	OperatorID         string            `json:"operatorId,omitempty"`
+	Seq                int               `json:"seq"`
+	Stream             string            `json:"stream,omitempty"`
+	Offset             int64             `json:"offset,omitempty"`
+	ElapsedUs          int64             `json:"elapsedUs,omitempty"`
+	StdoutChunk        string            `json:"stdoutChunk,omitempty"`
+	StderrChunk        string            `json:"stderrChunk,omitempty"`
+	Encoding           string            `json:"encoding,omitempty"`
+	ExitCode           *int              `json:"exitCode,omitempty"`
+	LimitExceeded      string            `json:"limitExceeded,omitempty"`
+	LimitMode          string            `json:"limitMode,omitempty"`
+	UnenforcedLimits   []string          `json:"unenforcedLimits,omitempty"`
+	Truncated          *OutputTruncation `json:"truncated,omitempty"`
+	StdoutBytes        int64             `json:"stdoutBytes,omitempty"`
+	StderrBytes        int64             `json:"stderrBytes,omitempty"`
+	StdoutDroppedBytes int64             `json:"stdoutDroppedBytes,omitempty"`
+	StderrDroppedBytes int64             `json:"stderrDroppedBytes,omitempty"`
+	OutputDropped      bool              `json:"outputDropped,omitempty"`
+	TimedOut           bool              `json:"timedOut,omitempty"`
+	TerminationSignal  string            `json:"terminationSignal,omitempty"`
+	Escalated          bool              `json:"escalated,omitempty"`
+	Redactions         int               `json:"redactions,omitempty"`
+	Execution          *ExecutionInfo    `json:"execution,omitempty"`
+	Attempt            int               `json:"attempt,omitempty"`
+	RetryAfterMs       int64             `json:"retryAfterMs,omitempty"`
+	ErrorCode          string            `json:"errorCode,omitempty"`
+	PolicyRuleID       string            `json:"policyRuleId,omitempty"`
+	Final              bool              `json:"isFinal"`
+}
+
+// ExecutionInfo 描述命令执行的元数据，由 Agent 在进程回收后采集：
+//   - StartedAt / EndedAt: 进程启动与回收时间（毫秒时间戳），DurationMs 为两者之差；
+//   - PID / WorkDir / User: 进程号、实际工作目录与运行用户；
+//   - Signaled / Signal: 进程是否被信号终止及信号名（如 SIGKILL）；
+//   - UserCPUMs / SystemCPUMs: 用户态 / 内核态 CPU 时间（毫秒），MaxRSSBytes: 峰值常驻内存；
+//   - InBlocks / OutBlocks: 块设备读 / 写次数。
+type ExecutionInfo struct {
+	StartedAt   int64  `json:"startedAt"`
+	EndedAt     int64  `json:"endedAt"`
+	DurationMs  int64  `json:"durationMs"`
+	PID         int    `json:"pid"`
+	WorkDir     string `json:"workDir,omitempty"`
+	User        string `json:"user,omitempty"`
+	Signaled    bool   `json:"signaled,omitempty"`
+	Signal      string `json:"signal,omitempty"`
+	UserCPUMs   int64  `json:"userCpuMs"`
+	SystemCPUMs int64  `json:"systemCpuMs"`
+	MaxRSSBytes int64  `json:"maxRssBytes"`
+	InBlocks    int64  `json:"inBlocks"`
+	OutBlocks   int64  `json:"outBlocks"`
+}
+
+// OutputTruncation 描述截断标记：Stream 为 stdout / stderr，DroppedBytes 为丢弃的字节数。
+type OutputTruncation struct {
+	Stream       string `json:"stream"`
+	DroppedBytes int64  `json:"droppedBytes"`
 }
 
 // ResultAckPayload 对应 result.ack 事件的负载。
@@ -189,11 +460,20 @@ type TerminalSessionOpenPayload struct {
 	Shell     string            `json:"shell"`
 	Cwd       string            `json:"cwd"`
 	Env       map[string]string `json:"env,omitempty"`
+	RunAs     *RunAs            `json:"runAs,omitempty"`
 	Cols      int               `json:"cols"`
 	Rows      int               `json:"rows"`
 	Title     string            `json:"title"`
 }
 
+// RunAs 描述子进程的运行身份，各字段可为名称或数字 ID。
+// Group 为空时使用用户主组；Groups 省略时使用用户的全部附加组。
+type RunAs struct {
+	User   string   `json:"user"`
+	Group  string   `json:"group,omitempty"`
+	Groups []string `json:"groups,omitempty"`
+}
+
 // TerminalSessionOpenedPayload 对应 terminal.session.opened 事件负载。
 type TerminalSessionOpenedPayload struct {
 	RequestID       string `json:"requestId"`
@@ -238,6 +518,8 @@ type TerminalStdoutChunkPayload struct {
 	Cwd       string `json:"cwd,omitempty"`
 	Title     string `json:"title,omitempty"`
 	IsBinary  bool   `json:"isBinary"`
+	// Redactions 为本分片中被遮蔽的密钥个数。
+	Redactions int `json:"redactions,omitempty"`
 }
 
 // TerminalSessionStatePayload 对应 terminal.session.state 事件负载。
@@ -265,3 +547,17 @@ type TerminalSessionErrorPayload struct {
 	Code      string `json:"code"`
 	Message   string `json:"message"`
 }
+
+// FrameRejectedPayload 对应 frame.rejected 事件负载。
+//
+// Agent 拒绝执行某个入站帧（例如签名缺失或校验失败）时回发，
+// TaskUUID / SessionID 按被拒绝帧的类型择一填写，便于服务端关联。
+type FrameRejectedPayload struct {
+	Event     string `json:"event"`
+	TaskUUID  string `json:"task_uuid,omitempty"`
+	SessionID string `json:"sessionId,omitempty"`
+	// Seq 为被拒绝的 command.stdin.write / command.stdin.close 的序号。
+	Seq     int    `json:"seq,omitempty"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
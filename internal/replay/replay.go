@@ -0,0 +1,228 @@
+// Package replay 实现 command.push 的抗重放校验：时间窗 + 持久化的 nonce 缓存。
+package replay
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+var (
+	ErrMissingNonce  = errors.New("push nonce missing")
+	ErrMissingExpiry = errors.New("push issuedAt/expiresAt missing")
+	ErrExpired       = errors.New("push expired")
+	ErrNotYetValid   = errors.New("push issued in the future")
+	ErrDuplicate     = errors.New("push nonce already seen")
+	ErrCacheFull     = errors.New("replay cache full of unexpired nonces")
+)
+
+const (
+	defaultCapacity = 4096
+	defaultMaxSkew  = 5 * time.Minute
+	defaultMaxAge   = 10 * time.Minute
+)
+
+// Options 配置 Guard。
+//
+//   - Path 为 nonce 缓存的持久化路径，为空时仅保存在内存中；
+//   - Capacity 为缓存上限；未过期的 nonce 从不淘汰，缓存已满时拒绝新推送直到有记录过期；
+//   - MaxSkew 为时钟偏移容忍窗口；
+//   - MaxAge 为推送的最长有效期：仅携带 issuedAt 时有效期为 issuedAt + MaxAge，
+//     expiresAt 也不能超过 issuedAt（缺省时为当前时间）+ MaxAge。
+type Options struct {
+	Path     string
+	Capacity int
+	MaxSkew  time.Duration
+	MaxAge   time.Duration
+	Now      func() time.Time
+}
+
+// Guard 校验推送的时间窗并拒绝重复 nonce。
+type Guard struct {
+	path     string
+	capacity int
+	maxSkew  time.Duration
+	maxAge   time.Duration
+	now      func() time.Time
+
+	mu      sync.Mutex
+	entries []entry
+	seen    map[string]int64
+}
+
+type entry struct {
+	Nonce     string `json:"nonce"`
+	ExpiresAt int64  `json:"expiresAt"`
+}
+
+// Open 创建 Guard，并从 Path 加载历史 nonce（文件不存在时从空缓存开始）。
+func Open(opts Options) (*Guard, error) {
+	g := &Guard{
+		path:     opts.Path,
+		capacity: opts.Capacity,
+		maxSkew:  opts.MaxSkew,
+		maxAge:   opts.MaxAge,
+		now:      opts.Now,
+		seen:     make(map[string]int64),
+	}
+	if g.capacity <= 0 {
+		g.capacity = defaultCapacity
+	}
+	if g.maxSkew <= 0 {
+		g.maxSkew = defaultMaxSkew
+	}
+	if g.maxAge <= 0 {
+		g.maxAge = defaultMaxAge
+	}
+	if g.now == nil {
+		g.now = time.Now
+	}
+
+	if g.path == "" {
+		return g, nil
+	}
+	data, err := os.ReadFile(g.path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return g, nil
+		}
+		return nil, fmt.Errorf("read replay cache: %w", err)
+	}
+	var entries []entry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("decode replay cache: %w", err)
+	}
+	for _, e := range entries {
+		g.add(e)
+	}
+	g.prune(g.now().UnixMilli())
+	return g, nil
+}
+
+// Check 校验一次推送；通过后记录 nonce 并持久化。
+//
+// issuedAt / expiresAt 为毫秒时间戳，至少需提供其一；有效期至多为 issuedAt（缺省时为当前时间）+ MaxAge，
+// 更晚的 expiresAt 被截断，使 nonce 的保留时长有界。所有比较均放宽 MaxSkew 以容忍 Agent 与网关的时钟漂移。
+func (g *Guard) Check(nonce string, issuedAt, expiresAt int64) error {
+	if nonce == "" {
+		return ErrMissingNonce
+	}
+	if issuedAt <= 0 && expiresAt <= 0 {
+		return ErrMissingExpiry
+	}
+
+	now := g.now().UnixMilli()
+	skew := g.maxSkew.Milliseconds()
+	if issuedAt > 0 && issuedAt > now+skew {
+		return fmt.Errorf("%w: issuedAt=%d now=%d", ErrNotYetValid, issuedAt, now)
+	}
+	start := issuedAt
+	if start <= 0 {
+		start = now
+	}
+	if limit := start + g.maxAge.Milliseconds(); expiresAt <= 0 || expiresAt > limit {
+		expiresAt = limit
+	}
+	if now > expiresAt+skew {
+		return fmt.Errorf("%w: expiresAt=%d now=%d", ErrExpired, expiresAt, now)
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.prune(now)
+	if _, dup := g.seen[nonce]; dup {
+		return fmt.Errorf("%w: %s", ErrDuplicate, nonce)
+	}
+	// 淘汰未过期的 nonce 会让对应推送可被重放，因此缓存满时拒绝而不是淘汰。
+	if len(g.entries) >= g.capacity {
+		return fmt.Errorf("%w: capacity %d", ErrCacheFull, g.capacity)
+	}
+	// 过期判定放宽了 skew，缓存也需保留到同一时刻之后，避免窗口内重放。
+	g.add(entry{Nonce: nonce, ExpiresAt: expiresAt + skew})
+	return g.save()
+}
+
+// Release 撤销 Check 记录的 nonce 并持久化，用于推送在执行前被拒绝（运维人员签名或本地策略未通过）的情形：
+// Check 先记录 nonce 可避免并发的重复推送同时通过，被拒绝的推送不应因此占用 nonce，
+// 以便服务端在补签或审批通过后以同一推送重发。nonce 未被记录时什么也不做。
+func (g *Guard) Release(nonce string) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if _, ok := g.seen[nonce]; !ok {
+		return nil
+	}
+	delete(g.seen, nonce)
+	for i, e := range g.entries {
+		if e.Nonce == nonce {
+			g.entries = append(g.entries[:i], g.entries[i+1:]...)
+			break
+		}
+	}
+	return g.save()
+}
+
+// ErrorCode 将校验错误映射为 frame.rejected 中的错误码。
+func ErrorCode(err error) string {
+	switch {
+	case errors.Is(err, ErrMissingNonce), errors.Is(err, ErrMissingExpiry):
+		return "REPLAY_FIELDS_MISSING"
+	case errors.Is(err, ErrExpired):
+		return "PUSH_EXPIRED"
+	case errors.Is(err, ErrNotYetValid):
+		return "PUSH_NOT_YET_VALID"
+	case errors.Is(err, ErrDuplicate):
+		return "PUSH_REPLAYED"
+	case errors.Is(err, ErrCacheFull):
+		return "REPLAY_CACHE_FULL"
+	default:
+		return "REPLAY_CACHE_ERROR"
+	}
+}
+
+func (g *Guard) add(e entry) {
+	if _, dup := g.seen[e.Nonce]; dup {
+		return
+	}
+	g.entries = append(g.entries, e)
+	g.seen[e.Nonce] = e.ExpiresAt
+}
+
+// prune 移除已过期的 nonce：对应推送本身已会因过期被拒绝，无需继续记录。
+func (g *Guard) prune(now int64) {
+	kept := g.entries[:0]
+	for _, e := range g.entries {
+		if e.ExpiresAt < now {
+			delete(g.seen, e.Nonce)
+			continue
+		}
+		kept = append(kept, e)
+	}
+	g.entries = kept
+}
+
+func (g *Guard) save() error {
+	if g.path == "" {
+		return nil
+	}
+	data, err := json.Marshal(g.entries)
+	if err != nil {
+		return fmt.Errorf("encode replay cache: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(g.path), 0o700); err != nil {
+		return fmt.Errorf("create replay cache dir: %w", err)
+	}
+	tmp := g.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return fmt.Errorf("write replay cache: %w", err)
+	}
+	if err := os.Rename(tmp, g.path); err != nil {
+		return fmt.Errorf("replace replay cache: %w", err)
+	}
+	return nil
+}
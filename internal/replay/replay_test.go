@@ -0,0 +1,140 @@
+package replay
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCheckRejectsExpiredAndFuturePushes(t *testing.T) {
+	now := time.UnixMilli(1_700_000_000_000)
+	guard := openTestGuard(t, Options{Now: func() time.Time { return now }, MaxSkew: time.Minute})
+
+	nowMs := now.UnixMilli()
+	if err := guard.Check("n-1", nowMs-2*time.Hour.Milliseconds(), nowMs-2*time.Minute.Milliseconds()); !errors.Is(err, ErrExpired) {
+		t.Fatalf("Check(expired) error = %v, want %v", err, ErrExpired)
+	}
+	if err := guard.Check("n-2", nowMs+2*time.Minute.Milliseconds(), 0); !errors.Is(err, ErrNotYetValid) {
+		t.Fatalf("Check(future) error = %v, want %v", err, ErrNotYetValid)
+	}
+	if err := guard.Check("n-3", nowMs-30*time.Second.Milliseconds(), nowMs-30*time.Second.Milliseconds()); err != nil {
+		t.Fatalf("Check(within skew) error = %v", err)
+	}
+	if err := guard.Check("", nowMs, 0); !errors.Is(err, ErrMissingNonce) {
+		t.Fatalf("Check(no nonce) error = %v, want %v", err, ErrMissingNonce)
+	}
+	if err := guard.Check("n-4", 0, 0); !errors.Is(err, ErrMissingExpiry) {
+		t.Fatalf("Check(no times) error = %v, want %v", err, ErrMissingExpiry)
+	}
+}
+
+func TestCheckRejectsDuplicateNonceAcrossRestarts(t *testing.T) {
+	now := time.Now()
+	path := filepath.Join(t.TempDir(), "replay.json")
+	opts := Options{Path: path, Now: func() time.Time { return now }}
+
+	guard := openTestGuard(t, opts)
+	if err := guard.Check("n-1", now.UnixMilli(), 0); err != nil {
+		t.Fatalf("Check(first) error = %v", err)
+	}
+	if err := guard.Check("n-1", now.UnixMilli(), 0); !errors.Is(err, ErrDuplicate) {
+		t.Fatalf("Check(duplicate) error = %v, want %v", err, ErrDuplicate)
+	}
+
+	reopened := openTestGuard(t, opts)
+	if err := reopened.Check("n-1", now.UnixMilli(), 0); !errors.Is(err, ErrDuplicate) {
+		t.Fatalf("Check(after restart) error = %v, want %v", err, ErrDuplicate)
+	}
+	if got := ErrorCode(ErrDuplicate); got != "PUSH_REPLAYED" {
+		t.Fatalf("ErrorCode() = %q, want PUSH_REPLAYED", got)
+	}
+}
+
+func TestReleaseForgetsRejectedNonce(t *testing.T) {
+	now := time.Now()
+	path := filepath.Join(t.TempDir(), "replay.json")
+	opts := Options{Path: path, Now: func() time.Time { return now }}
+
+	guard := openTestGuard(t, opts)
+	for _, nonce := range []string{"n-1", "n-2"} {
+		if err := guard.Check(nonce, now.UnixMilli(), 0); err != nil {
+			t.Fatalf("Check(%s) error = %v", nonce, err)
+		}
+	}
+	if err := guard.Release("n-1"); err != nil {
+		t.Fatalf("Release() error = %v", err)
+	}
+	if err := guard.Release("unknown"); err != nil {
+		t.Fatalf("Release(unknown) error = %v", err)
+	}
+
+	reopened := openTestGuard(t, opts)
+	if err := reopened.Check("n-1", now.UnixMilli(), 0); err != nil {
+		t.Fatalf("Check(released after restart) error = %v", err)
+	}
+	if err := reopened.Check("n-2", now.UnixMilli(), 0); !errors.Is(err, ErrDuplicate) {
+		t.Fatalf("Check(n-2 after restart) error = %v, want %v", err, ErrDuplicate)
+	}
+}
+
+func TestCacheIsBoundedAndPrunesExpiredNonces(t *testing.T) {
+	now := time.Now()
+	guard := openTestGuard(t, Options{Capacity: 2, Now: func() time.Time { return now }})
+
+	for _, nonce := range []string{"a", "b"} {
+		if err := guard.Check(nonce, now.UnixMilli(), 0); err != nil {
+			t.Fatalf("Check(%s) error = %v", nonce, err)
+		}
+	}
+	// 未过期的 nonce 不会被淘汰：缓存满时拒绝新推送，"a" 仍不可重放。
+	if err := guard.Check("c", now.UnixMilli(), 0); !errors.Is(err, ErrCacheFull) {
+		t.Fatalf("Check(c) error = %v, want %v", err, ErrCacheFull)
+	}
+	if err := guard.Check("a", now.UnixMilli(), 0); !errors.Is(err, ErrDuplicate) {
+		t.Fatalf("Check(a) error = %v, want %v", err, ErrDuplicate)
+	}
+
+	now = now.Add(time.Hour)
+	guard.prune(now.UnixMilli())
+	if len(guard.entries) != 0 || len(guard.seen) != 0 {
+		t.Fatalf("entries/seen = %d/%d after expiry, want 0/0", len(guard.entries), len(guard.seen))
+	}
+	if err := guard.Check("c", now.UnixMilli(), 0); err != nil {
+		t.Fatalf("Check(c after expiry) error = %v", err)
+	}
+}
+
+func TestCheckClampsFarFutureExpiry(t *testing.T) {
+	now := time.Now()
+	guard := openTestGuard(t, Options{MaxAge: 10 * time.Minute, MaxSkew: time.Minute, Now: func() time.Time { return now }})
+
+	farFuture := now.Add(365 * 24 * time.Hour).UnixMilli()
+	if err := guard.Check("n-1", now.UnixMilli(), farFuture); err != nil {
+		t.Fatalf("Check() error = %v", err)
+	}
+	if err := guard.Check("n-2", 0, farFuture); err != nil {
+		t.Fatalf("Check(expiresAt only) error = %v", err)
+	}
+	limit := now.Add(11 * time.Minute).UnixMilli()
+	for _, e := range guard.entries {
+		if e.ExpiresAt > limit {
+			t.Fatalf("nonce %s kept until %d, want at most issuedAt + maxAge + skew (%d)", e.Nonce, e.ExpiresAt, limit)
+		}
+	}
+
+	// 超过有效期上限后，即使 expiresAt 尚未到达也按过期拒绝。
+	now = now.Add(12 * time.Minute)
+	if err := guard.Check("n-3", now.Add(-12*time.Minute).UnixMilli(), farFuture); !errors.Is(err, ErrExpired) {
+		t.Fatalf("Check(stale issuedAt) error = %v, want %v", err, ErrExpired)
+	}
+}
+
+func openTestGuard(t *testing.T, opts Options) *Guard {
+	t.Helper()
+	guard, err := Open(opts)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	return guard
+}
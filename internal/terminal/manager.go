@@ -7,7 +7,9 @@ import (
 	"log"
 	"sync"
 
+	"devops-agent/internal/proc"
 	"devops-agent/internal/protocol"
+	"devops-agent/internal/redact"
 )
 
 type EventSink interface {
@@ -18,12 +20,14 @@ type EventSink interface {
 	EmitSessionError(ctx context.Context, payload protocol.TerminalSessionErrorPayload) error
 }
 
+// Options 配置 Manager；Redactor 在终端输出回传前遮蔽其中的密钥，为 nil 时不遮蔽。
 type Options struct {
 	DefaultShell   string
 	DefaultWorkDir string
 	Factory        PtyFactory
 	Sink           EventSink
 	Logger         *log.Logger
+	Redactor       *redact.Redactor
 }
 
 type OpenPayload struct {
@@ -33,6 +37,7 @@ type OpenPayload struct {
 	Shell     string
 	Cwd       string
 	Env       map[string]string
+	RunAs     *proc.RunAs
 	Cols      int
 	Rows      int
 	Title     string
@@ -47,6 +52,7 @@ type Manager struct {
 	factory        PtyFactory
 	sink           EventSink
 	logger         *log.Logger
+	redactor       *redact.Redactor
 
 	newSession func(payload OpenPayload, opts sessionOptions) *Session
 }
@@ -64,6 +70,7 @@ func NewManager(opts Options) *Manager {
 		factory:        opts.Factory,
 		sink:           opts.Sink,
 		logger:         logger,
+		redactor:       opts.Redactor,
 	}
 	m.newSession = func(payload OpenPayload, sopts sessionOptions) *Session {
 		return newSession(payload, sopts)
@@ -92,6 +99,7 @@ func (m *Manager) OpenSession(ctx context.Context, payload OpenPayload) error {
 		factory:        m.factory,
 		sink:           m.sink,
 		logger:         m.logger,
+		redactor:       m.redactor,
 	})
 	m.sessions[payload.SessionID] = session
 	m.mu.Unlock()
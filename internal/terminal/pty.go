@@ -1,12 +1,15 @@
 package terminal
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"os/exec"
 	"syscall"
 
 	"github.com/creack/pty"
+
+	"devops-agent/internal/proc"
 )
 
 type PtyFile interface {
@@ -33,16 +36,21 @@ type StartSpec struct {
 	Shell     string
 	Cwd       string
 	Env       []string
+	RunAs     *proc.RunAs
 	Cols      int
 	Rows      int
 	Title     string
 	Stdout    *os.File
 }
 
-type RealPtyFactory struct{}
+// RealPtyFactory 在真实 PTY 中启动 shell，子进程环境与运行身份按与命令执行一致的策略构造。
+type RealPtyFactory struct {
+	env   proc.EnvPolicy
+	users proc.UserPolicy
+}
 
-func NewRealPtyFactory() *RealPtyFactory {
-	return &RealPtyFactory{}
+func NewRealPtyFactory(env proc.EnvPolicy, users proc.UserPolicy) *RealPtyFactory {
+	return &RealPtyFactory{env: env, users: users}
 }
 
 func (f *RealPtyFactory) Start(spec StartSpec) (*PtyProcess, error) {
@@ -54,11 +62,38 @@ func (f *RealPtyFactory) Start(spec StartSpec) (*PtyProcess, error) {
 	if spec.Cwd != "" {
 		cmd.Dir = spec.Cwd
 	}
-	cmd.Env = append(os.Environ(), spec.Env...)
+	extraEnv := spec.Env
+	var identity *proc.Identity
+	if spec.RunAs != nil {
+		var err error
+		if identity, err = f.users.Resolve(*spec.RunAs); err != nil {
+			code := "RUN_AS_INVALID"
+			if errors.Is(err, proc.ErrRunAsDenied) {
+				code = "RUN_AS_DENIED"
+			}
+			return nil, &Error{code: code, message: err.Error()}
+		}
+		extraEnv = append(append([]string(nil), spec.Env...), identity.Env()...)
+	}
+	env, err := f.env.Environ(extraEnv)
+	if err != nil {
+		return nil, err
+	}
+	cmd.Env = env
+	if identity != nil {
+		identity.Apply(cmd)
+	}
+
+	return StartCommand(cmd, spec.Cols, spec.Rows)
+}
 
+// StartCommand 在新的会话与 cols×rows 的 PTY 中启动已构造好的 cmd（环境、运行身份等由调用方设置），
+// cmd 的标准输入输出均连接到 PTY 从端，返回的 File 为主端。子进程成为会话首进程，
+// 其进程组 ID 即 PID，cmd.SysProcAttr 中不应再设置 Setpgid。
+func StartCommand(cmd *exec.Cmd, cols, rows int) (*PtyProcess, error) {
 	ptmx, err := pty.StartWithSize(cmd, &pty.Winsize{
-		Cols: uint16(spec.Cols),
-		Rows: uint16(spec.Rows),
+		Cols: uint16(cols),
+		Rows: uint16(rows),
 	})
 	if err != nil {
 		return nil, err
@@ -2,6 +2,7 @@ package terminal
 
 import (
 	"context"
+	"devops-agent/internal/proc"
 	"devops-agent/internal/protocol"
 	"io"
 	"log"
@@ -24,7 +25,7 @@ func TestRealPtyFactoryStartsShellAndStreamsOutput(t *testing.T) {
 		Rows:      24,
 		Title:     "sh",
 	}, sessionOptions{
-		factory: NewRealPtyFactory(),
+		factory: NewRealPtyFactory(proc.EnvPolicy{}, proc.UserPolicy{}),
 		sink:    sink,
 		logger:  log.New(io.Discard, "", 0),
 	})
@@ -59,7 +60,7 @@ func TestRealPtyFactoryResizeAndSignalAffectShell(t *testing.T) {
 		Rows:      24,
 		Title:     "sh",
 	}, sessionOptions{
-		factory: NewRealPtyFactory(),
+		factory: NewRealPtyFactory(proc.EnvPolicy{}, proc.UserPolicy{}),
 		sink:    sink,
 		logger:  log.New(io.Discard, "", 0),
 	})
@@ -104,6 +105,26 @@ func TestRealPtyFactoryResizeAndSignalAffectShell(t *testing.T) {
 	})
 }
 
+func TestRealPtyFactoryDoesNotLeakAgentEnvWhenAllowlistMatchesNothing(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skip PTY integration test in short mode")
+	}
+	t.Setenv("AGENT_AUTH__TOKEN", "secret")
+
+	factory := NewRealPtyFactory(proc.EnvPolicy{Inherit: []string{"NOPE_*"}}, proc.UserPolicy{})
+	process, err := factory.Start(StartSpec{Shell: "/usr/bin/env", Cols: 80, Rows: 24})
+	if err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	defer process.File.Close()
+
+	output, _ := io.ReadAll(process.File)
+	_ = process.Wait()
+	if strings.Contains(string(output), "AGENT_") {
+		t.Fatalf("terminal environment = %q, want no AGENT_ variables", output)
+	}
+}
+
 func joinChunkData(chunks []protocol.TerminalStdoutChunkPayload) string {
 	var b strings.Builder
 	for _, chunk := range chunks {
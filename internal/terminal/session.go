@@ -11,15 +11,22 @@ import (
 	"syscall"
 	"time"
 
+	"devops-agent/internal/proc"
 	"devops-agent/internal/protocol"
+	"devops-agent/internal/redact"
 )
 
+// redactIdleFlush 为遮蔽过滤器保留未完成的一行后、无新输出时回传这部分内容的等待时间，
+// 保证提示符与按键回显仍能及时显示。
+const redactIdleFlush = 25 * time.Millisecond
+
 type sessionOptions struct {
 	defaultShell   string
 	defaultWorkDir string
 	factory        PtyFactory
 	sink           EventSink
 	logger         *log.Logger
+	redactor       *redact.Redactor
 }
 
 type Session struct {
@@ -34,10 +41,16 @@ type Session struct {
 	sink    EventSink
 	logger  *log.Logger
 	env     []string
+	runAs   *proc.RunAs
 
 	proc PtyProcess
 	pty  PtyFile
 
+	// outputMu 串行化遮蔽过滤与 stdout 分片的发送，使分片序号与输出顺序一致。
+	outputMu  sync.Mutex
+	output    *redact.Stream
+	idleFlush *time.Timer
+
 	startFn  func(context.Context) error
 	writeFn  func(context.Context, string) error
 	resizeFn func(context.Context, int, int) error
@@ -87,6 +100,8 @@ func newSession(payload OpenPayload, opts sessionOptions) *Session {
 		sink:      opts.sink,
 		logger:    opts.logger,
 		env:       envMapToList(payload.Env),
+		runAs:     payload.RunAs,
+		output:    opts.redactor.NewStream(),
 	}
 }
 
@@ -113,6 +128,7 @@ func (s *Session) start(ctx context.Context) error {
 			Shell:     s.meta.Shell,
 			Cwd:       s.meta.Cwd,
 			Env:       append([]string(nil), s.env...),
+			RunAs:     s.runAs,
 			Cols:      s.meta.Cols,
 			Rows:      s.meta.Rows,
 			Title:     s.meta.Title,
@@ -332,9 +348,10 @@ func (s *Session) startReadLoop() {
 		for {
 			n, err := s.pty.Read(buf)
 			if n > 0 {
-				s.recordChunk(context.Background(), string(buf[:n]))
+				s.recordOutput(buf[:n])
 			}
 			if err != nil {
+				s.flushOutput()
 				return
 			}
 		}
@@ -364,7 +381,33 @@ func (s *Session) startWaitLoop() {
 	}()
 }
 
-func (s *Session) recordChunk(ctx context.Context, data string) {
+// recordOutput 遮蔽读到的终端输出并回传；过滤器保留了未完成的一行时，空闲 redactIdleFlush 后回传。
+func (s *Session) recordOutput(p []byte) {
+	s.outputMu.Lock()
+	defer s.outputMu.Unlock()
+
+	data, redactions := s.output.Write(p)
+	s.recordChunk(context.Background(), string(data), redactions)
+	if !s.output.Pending() {
+		return
+	}
+	if s.idleFlush == nil {
+		s.idleFlush = time.AfterFunc(redactIdleFlush, s.flushOutput)
+	} else {
+		s.idleFlush.Reset(redactIdleFlush)
+	}
+}
+
+// flushOutput 回传过滤器保留的全部输出。
+func (s *Session) flushOutput() {
+	s.outputMu.Lock()
+	defer s.outputMu.Unlock()
+
+	data, redactions := s.output.Flush()
+	s.recordChunk(context.Background(), string(data), redactions)
+}
+
+func (s *Session) recordChunk(ctx context.Context, data string, redactions int) {
 	if data == "" {
 		return
 	}
@@ -372,13 +415,14 @@ func (s *Session) recordChunk(ctx context.Context, data string) {
 	s.mu.Lock()
 	s.seq++
 	payload := protocol.TerminalStdoutChunkPayload{
-		SessionID: s.meta.SessionID,
-		Seq:       s.seq,
-		Stream:    "stdout",
-		Data:      data,
-		Cwd:       s.meta.Cwd,
-		Title:     s.meta.Title,
-		IsBinary:  false,
+		SessionID:  s.meta.SessionID,
+		Seq:        s.seq,
+		Stream:     "stdout",
+		Data:       data,
+		Cwd:        s.meta.Cwd,
+		Title:      s.meta.Title,
+		IsBinary:   false,
+		Redactions: redactions,
 	}
 	s.meta.UpdatedAt = time.Now().UTC()
 	s.meta.LastActiveAt = s.meta.UpdatedAt
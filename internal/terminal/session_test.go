@@ -2,12 +2,15 @@ package terminal
 
 import (
 	"context"
+	"devops-agent/internal/proc"
 	"devops-agent/internal/protocol"
+	"devops-agent/internal/redact"
 	"errors"
 	"io"
 	"log"
 	"os"
 	"slices"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"syscall"
@@ -269,6 +272,7 @@ func TestSessionStartUsesFactoryAndEmitsOpenState(t *testing.T) {
 			"Z": "last",
 			"A": "first",
 		},
+		RunAs: &proc.RunAs{User: "svc"},
 		Cols:  80,
 		Rows:  24,
 		Title: "shell",
@@ -303,6 +307,9 @@ func TestSessionStartUsesFactoryAndEmitsOpenState(t *testing.T) {
 	if !slices.Equal(gotSpec.Env, []string{"A=first", "Z=last"}) {
 		t.Fatalf("StartSpec.Env = %v, want %v", gotSpec.Env, []string{"A=first", "Z=last"})
 	}
+	if gotSpec.RunAs == nil || gotSpec.RunAs.User != "svc" {
+		t.Fatalf("StartSpec.RunAs = %#v, want user svc", gotSpec.RunAs)
+	}
 	if session.meta.Status != SessionOpen {
 		t.Fatalf("status after start = %q, want %q", session.meta.Status, SessionOpen)
 	}
@@ -413,6 +420,50 @@ func TestSessionReadLoopEmitsIncreasingSeq(t *testing.T) {
 	}
 }
 
+func TestSessionReadLoopRedactsSecrets(t *testing.T) {
+	redactor, err := redact.New(redact.Options{})
+	if err != nil {
+		t.Fatalf("redact.New() error = %v", err)
+	}
+	redactor.SetValues(redact.SourceAuth, []string{"device-token-xyz"})
+
+	sink := &recordingSink{}
+	factory := newFakeFactory()
+	factory.pty.queueRead("$ cat token\r\ndevice-")
+	factory.pty.queueRead("token-xyz\r\n$ ")
+	session := newSession(OpenPayload{SessionID: "ts-redact", Shell: "/bin/sh", Cwd: t.TempDir()}, sessionOptions{
+		factory:  factory,
+		sink:     sink,
+		logger:   log.New(io.Discard, "", 0),
+		redactor: redactor,
+	})
+
+	if err := session.start(context.Background()); err != nil {
+		t.Fatalf("start() error = %v", err)
+	}
+	t.Cleanup(func() {
+		if err := session.close(context.Background(), "test_cleanup"); err != nil {
+			t.Fatalf("cleanup close() error = %v", err)
+		}
+	})
+
+	var data strings.Builder
+	redactions := 0
+	waitFor(t, time.Second, func() bool {
+		data.Reset()
+		redactions = 0
+		for _, chunk := range sink.chunkSnapshot() {
+			data.WriteString(chunk.Data)
+			redactions += chunk.Redactions
+		}
+		// 提示符 "$ " 没有换行，由空闲回传送达。
+		return strings.HasSuffix(data.String(), "$ ")
+	})
+	if data.String() != "$ cat token\r\n[REDACTED]\r\n$ " || redactions != 1 {
+		t.Fatalf("terminal output = %q redactions = %d, want the split token redacted once", data.String(), redactions)
+	}
+}
+
 func TestSessionWriteResizeAndSignalUseUnderlyingPTY(t *testing.T) {
 	sink := &recordingSink{}
 	factory := newFakeFactory()
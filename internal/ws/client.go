@@ -2,14 +2,20 @@ package ws
 
 import (
 	"context"
+	"crypto/ed25519"
+	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"log"
+	"math/rand"
+	"os"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/google/uuid"
 	"nhooyr.io/websocket"
 
 	agentconfig "devops-agent/internal/config"
@@ -17,24 +23,78 @@ import (
 	agentexec "devops-agent/internal/exec"
 	"devops-agent/internal/heartbeat"
 	"devops-agent/internal/metrics"
+	"devops-agent/internal/operator"
+	"devops-agent/internal/policy"
+	"devops-agent/internal/proc"
 	"devops-agent/internal/protocol"
+	"devops-agent/internal/redact"
+	"devops-agent/internal/replay"
 	"devops-agent/internal/terminal"
 )
 
-var ErrAuthRejected = errors.New("connect rejected by server: auth")
+var (
+	ErrAuthRejected     = errors.New("connect rejected by server: auth")
+	ErrGatewayIdentity  = errors.New("gateway identity verification failed")
+	ErrRotationRejected = errors.New("key rotation rejected by server")
+	ErrPendingApproval  = errors.New("enrollment pending approval")
+)
+
+// PendingApprovalError 表示注册请求待管理员审批；RetryAfter 为服务端建议的轮询间隔，未提供时为 0。
+type PendingApprovalError struct {
+	Code       string
+	Message    string
+	RetryAfter time.Duration
+}
+
+func (e *PendingApprovalError) Error() string {
+	return fmt.Sprintf("%v: %s: %s", ErrPendingApproval, e.Code, e.Message)
+}
+
+func (e *PendingApprovalError) Unwrap() error {
+	return ErrPendingApproval
+}
+
+const tokenRefreshRetryInterval = time.Minute
+
+// signedEvents 列出必须携带网关签名的入站事件；文件写入类事件落地后应一并加入。
+// 流式 stdin 会被已签名命令（sh、python3 等）当作代码执行，因此与 command.push 同样须签名。
+var signedEvents = map[string]bool{
+	protocol.EventCommandPush:         true,
+	protocol.EventCommandStdinWrite:   true,
+	protocol.EventCommandStdinClose:   true,
+	protocol.EventTerminalSessionOpen: true,
+	protocol.EventAuthTokenRotate:     true,
+	protocol.EventRedactionUpdate:     true,
+}
 
 type Client struct {
 	cfg           *agentconfig.Config
-	keyPair       agentcrypto.KeyPair
+	keys          *agentcrypto.SharedKeyStore
 	logger        *log.Logger
 	conn          *websocket.Conn
 	onDeviceToken func(string)
+	gatewayKey    ed25519.PublicKey
 
+	operators *operator.Verifier
+	policy    *policy.Engine
+	// redactor 遮蔽命令与终端输出中的密钥，未启用时为 nil。
+	redactor        *redact.Redactor
+	replayGuard     *replay.Guard
 	executor        agentexec.Executor
 	terminalManager terminalManager
 	sendEventFn     func(ctx context.Context, event string, payload any) error
 
 	writeMu sync.Mutex
+
+	// stdinMu 保护 stdins：以 task_uuid 索引的执行中命令的流式 stdin。
+	stdinMu sync.Mutex
+	stdins  map[string]*agentexec.StdinStream
+
+	// tokenMu 保护 cfg.Auth 中的 deviceToken 运行时字段及待响应的续期请求 ID。
+	tokenMu          sync.Mutex
+	pendingRefreshID string
+	refreshJitter    time.Duration
+	tokenUpdated     chan struct{}
 }
 
 type terminalManager interface {
@@ -46,21 +106,75 @@ type terminalManager interface {
 	CloseAll(ctx context.Context, reason string) error
 }
 
-func NewClient(cfg *agentconfig.Config, kp agentcrypto.KeyPair, logger *log.Logger, onDeviceToken func(string)) *Client {
+// NewClient 创建 WS 客户端。keys 与调用方共享：握手中提交密钥轮换时会替换为新密钥。
+// 抗重放缓存无法读取或解析时返回错误：以空缓存继续运行会使已记录的 nonce 全部可被重放；
+// operators.highRiskPatterns 或 redact.patterns 无法编译时同样返回错误，而不是退回内置模式。
+func NewClient(cfg *agentconfig.Config, keys *agentcrypto.SharedKeyStore, logger *log.Logger, onDeviceToken func(string)) (*Client, error) {
+	redactor, err := newRedactor(cfg.Redact)
+	if err != nil {
+		return nil, fmt.Errorf("invalid redact.patterns: %w", err)
+	}
 	client := &Client{
 		cfg:           cfg,
-		keyPair:       kp,
+		keys:          keys,
 		logger:        logger,
 		onDeviceToken: onDeviceToken,
-		executor:      agentexec.ShellExecutor{Enabled: cfg.Shell.Enabled, DefaultWorkDir: cfg.Shell.WorkDir},
+		tokenUpdated:  make(chan struct{}, 1),
+		redactor:      redactor,
+		executor: agentexec.ShellExecutor{
+			Enabled:        cfg.Shell.Enabled,
+			DefaultWorkDir: cfg.Shell.WorkDir,
+			ExecPath:       cfg.Shell.ExecPath,
+			Interpreters:   cfg.Shell.Interpreters,
+			Env:            cfg.EnvPolicy(),
+			Users:          cfg.UserPolicy(),
+			Limits:         cfg.ResourceLimits(),
+			Limiter:        proc.Limiter{CgroupRoot: cfg.Limits.CgroupRoot},
+			Output: agentexec.OutputLimits{
+				MaxStdoutBytes: cfg.Output.MaxStdoutBytes,
+				MaxStderrBytes: cfg.Output.MaxStderrBytes,
+				TailBytes:      cfg.Output.TailBytes,
+			},
+			FlushInterval: cfg.FlushInterval(),
+			MinChunkBytes: cfg.Output.MinChunkBytes,
+			Termination: agentexec.Termination{
+				Signal: cfg.Termination.Signal,
+				Grace:  cfg.TerminationGrace(),
+			},
+			Redactor: redactor,
+		},
+	}
+	operators, err := operator.NewVerifier(operator.Options{
+		AllowlistPath:    cfg.OperatorsFile(),
+		Required:         cfg.Operators.Required,
+		HighRiskPatterns: cfg.Operators.HighRiskPatterns,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("invalid operators.highRiskPatterns: %w", err)
+	}
+	client.operators = operators
+	client.policy = policy.NewEngine(cfg.PolicyFile())
+
+	guardOpts := replay.Options{
+		Path:     cfg.ReplayCachePath(),
+		Capacity: cfg.Replay.CacheSize,
+		MaxSkew:  time.Duration(cfg.Replay.MaxSkewSeconds) * time.Second,
+		MaxAge:   time.Duration(cfg.Replay.MaxAgeSeconds) * time.Second,
 	}
+	guard, err := replay.Open(guardOpts)
+	if err != nil {
+		return nil, fmt.Errorf("open replay cache %s (fix or remove the file once all pushes it records have expired): %w", guardOpts.Path, err)
+	}
+	client.replayGuard = guard
 	client.terminalManager = terminal.NewManager(terminal.Options{
 		DefaultWorkDir: cfg.Shell.WorkDir,
-		Factory:        terminal.NewRealPtyFactory(),
+		Factory:        terminal.NewRealPtyFactory(cfg.EnvPolicy(), cfg.UserPolicy()),
 		Sink:           client,
 		Logger:         logger,
+		Redactor:       redactor,
 	})
-	return client
+	client.redactAuthValues()
+	return client, nil
 }
 
 func (c *Client) ConnectAndServe(ctx context.Context) error {
@@ -68,23 +182,90 @@ func (c *Client) ConnectAndServe(ctx context.Context) error {
 		return fmt.Errorf("nil config")
 	}
 
-	authToken := c.selectAuthToken()
-	if authToken == "" {
+	c.tokenMu.Lock()
+	if c.cfg.DeviceTokenExpired(time.Now()) {
+		c.logger.Printf("[ws] device token expired, falling back to static auth token")
+		c.cfg.Auth.DeviceToken = ""
+		c.cfg.Auth.DeviceTokenExpiresAt = 0
+	}
+	c.tokenMu.Unlock()
+
+	auth := protocol.AuthInfo{Token: c.selectAuthToken()}
+	if auth.Token == "" && strings.TrimSpace(c.cfg.Auth.EnrollmentToken) != "" {
+		auth.EnrollmentToken = strings.TrimSpace(c.cfg.Auth.EnrollmentToken)
+		c.logger.Println("[ws] no auth token available, enrolling with enrollment token")
+	} else if auth.Token == "" {
 		c.logger.Println("[ws] warning: no auth token configured; server will likely reject connect")
 	}
 
+	hello, err := c.handshake(ctx, auth)
+	if err != nil {
+		return err
+	}
+	defer c.conn.Close(websocket.StatusNormalClosure, "agent shutdown")
+
+	tickMs := hello.Policy.TickIntervalMs
+	if tickMs <= 0 {
+		tickMs = c.cfg.TickInterval()
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	go heartbeat.Start(ctx, tickMs, c)
+	go c.tokenRefreshLoop(ctx)
+
+	go func() {
+		if err := c.readLoop(ctx); err != nil {
+			c.logger.Printf("[ws] read loop error: %v", err)
+		}
+		cancel()
+	}()
+
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+// Enroll 使用一次性的 auth.enrollmentToken 完成注册握手：获得 deviceToken（已经 onDeviceToken 持久化）后即断开。
+// 注册待审批时返回 *PendingApprovalError，调用方应按其 RetryAfter 退避后重试。
+func (c *Client) Enroll(ctx context.Context) error {
+	if c.cfg == nil {
+		return fmt.Errorf("nil config")
+	}
+	token := strings.TrimSpace(c.cfg.Auth.EnrollmentToken)
+	if token == "" {
+		return errors.New("no enrollment token configured")
+	}
+
+	if _, err := c.handshake(ctx, protocol.AuthInfo{EnrollmentToken: token}); err != nil {
+		return err
+	}
+	return c.conn.Close(websocket.StatusNormalClosure, "enrolled")
+}
+
+// handshake 完成 challenge → connect → hello-ok 握手，并应用 hello-ok 中的网关身份、密钥轮换与 deviceToken。
+// 握手失败时关闭连接；成功时连接保存在 c.conn 中，由调用方负责关闭。
+func (c *Client) handshake(ctx context.Context, auth protocol.AuthInfo) (hello protocol.HelloOkPayload, err error) {
+	if err := c.loadGatewayKey(); err != nil {
+		return hello, err
+	}
+
 	c.logger.Printf("[ws] dialing %s", c.cfg.Server.URL)
 	conn, _, err := websocket.Dial(ctx, c.cfg.Server.URL, nil)
 	if err != nil {
-		return fmt.Errorf("dial websocket: %w", err)
+		return hello, fmt.Errorf("dial websocket: %w", err)
 	}
-	defer conn.Close(websocket.StatusNormalClosure, "agent shutdown")
+	defer func() {
+		if err != nil {
+			conn.Close(websocket.StatusNormalClosure, "handshake failed")
+		}
+	}()
 
 	c.conn = conn
 
 	_, msg, err := conn.Read(ctx)
 	if err != nil {
-		return fmt.Errorf("read challenge: %w", err)
+		return hello, fmt.Errorf("read challenge: %w", err)
 	}
 
 	var challengeEnvelope struct {
@@ -93,35 +274,70 @@ func (c *Client) ConnectAndServe(ctx context.Context) error {
 		Payload protocol.ChallengePayload `json:"payload"`
 	}
 	if err := json.Unmarshal(msg, &challengeEnvelope); err != nil {
-		return fmt.Errorf("decode challenge frame: %w", err)
+		return hello, fmt.Errorf("decode challenge frame: %w", err)
 	}
 	if challengeEnvelope.Type != protocol.FrameTypeEvent || challengeEnvelope.Event != protocol.EventConnectChallenge {
-		return fmt.Errorf("unexpected first frame: type=%s event=%s", challengeEnvelope.Type, challengeEnvelope.Event)
+		return hello, fmt.Errorf("unexpected first frame: type=%s event=%s", challengeEnvelope.Type, challengeEnvelope.Event)
 	}
 	challenge := challengeEnvelope.Payload
 
-	deviceID := agentcrypto.DeviceID(c.keyPair.Public)
+	var (
+		rotation agentcrypto.KeyPair
+		rotating bool
+	)
+	if agentcrypto.SupportsRotation(c.cfg.Keys.Backend) {
+		rotation, rotating, err = agentcrypto.PendingRotation(c.cfg.Keys.Dir)
+		if err != nil {
+			return hello, fmt.Errorf("load pending key rotation: %w", err)
+		}
+	}
+
+	current := c.keys.Load()
+	signer := current
+	if rotating {
+		signer = rotation
+	}
+	deviceID := agentcrypto.DeviceID(signer.PublicKey())
 
-	reqFrame, err := BuildConnectRequest(c.keyPair, authToken, deviceID, challenge.Nonce, 3, 3)
+	reqFrame, err := BuildConnectRequest(signer, auth, deviceID, challenge.Nonce, 3, 3)
 	if err != nil {
-		return fmt.Errorf("build connect request: %w", err)
+		return hello, fmt.Errorf("build connect request: %w", err)
+	}
+	if auth.EnrollmentToken != "" {
+		params := reqFrame.Params.(protocol.ConnectParams)
+		params.Host = collectHostFacts()
+		reqFrame.Params = params
+	}
+	if rotating {
+		proof, err := agentcrypto.SignRotation(current, rotation.Public, challenge.Nonce)
+		if err != nil {
+			return hello, err
+		}
+		params := reqFrame.Params.(protocol.ConnectParams)
+		params.Rotation = &protocol.KeyRotation{
+			PreviousDeviceID:  agentcrypto.DeviceID(current.PublicKey()),
+			PreviousPublicKey: base64.StdEncoding.EncodeToString(current.PublicKey()),
+			Signature:         proof,
+		}
+		reqFrame.Params = params
+		c.logger.Printf("[ws] presenting key rotation: %s -> %s", params.Rotation.PreviousDeviceID, deviceID)
 	}
 
 	reqBytes, err := json.Marshal(reqFrame)
 	if err != nil {
-		return fmt.Errorf("marshal connect request: %w", err)
+		return hello, fmt.Errorf("marshal connect request: %w", err)
 	}
 
 	c.writeMu.Lock()
 	err = conn.Write(ctx, websocket.MessageText, reqBytes)
 	c.writeMu.Unlock()
 	if err != nil {
-		return fmt.Errorf("send connect request: %w", err)
+		return hello, fmt.Errorf("send connect request: %w", err)
 	}
 
 	_, msg, err = conn.Read(ctx)
 	if err != nil {
-		return fmt.Errorf("read hello-ok: %w", err)
+		return hello, fmt.Errorf("read hello-ok: %w", err)
 	}
 
 	var resEnvelope struct {
@@ -132,48 +348,62 @@ func (c *Client) ConnectAndServe(ctx context.Context) error {
 		Error   *protocol.ErrorBody     `json:"error,omitempty"`
 	}
 	if err := json.Unmarshal(msg, &resEnvelope); err != nil {
-		return fmt.Errorf("decode hello-ok frame: %w", err)
+		return hello, fmt.Errorf("decode hello-ok frame: %w", err)
 	}
 	if resEnvelope.Type != protocol.FrameTypeResponse || !resEnvelope.OK {
 		if resEnvelope.Error != nil {
+			if auth.EnrollmentToken != "" && isPendingErrorCode(resEnvelope.Error.Code) {
+				return hello, &PendingApprovalError{
+					Code:       resEnvelope.Error.Code,
+					Message:    resEnvelope.Error.Message,
+					RetryAfter: time.Duration(resEnvelope.Error.RetryAfterMs) * time.Millisecond,
+				}
+			}
+			if isRotationErrorCode(resEnvelope.Error.Code) {
+				return hello, fmt.Errorf("%w: %s: %s", ErrRotationRejected, resEnvelope.Error.Code, resEnvelope.Error.Message)
+			}
 			if isAuthErrorCode(resEnvelope.Error.Code) {
-				return fmt.Errorf("%w: %s: %s", ErrAuthRejected, resEnvelope.Error.Code, resEnvelope.Error.Message)
+				return hello, fmt.Errorf("%w: %s: %s", ErrAuthRejected, resEnvelope.Error.Code, resEnvelope.Error.Message)
 			}
-			return fmt.Errorf("connect failed: %s: %s", resEnvelope.Error.Code, resEnvelope.Error.Message)
+			return hello, fmt.Errorf("connect failed: %s: %s", resEnvelope.Error.Code, resEnvelope.Error.Message)
 		}
-		return fmt.Errorf("connect failed: unexpected frame type=%s ok=%v", resEnvelope.Type, resEnvelope.OK)
+		return hello, fmt.Errorf("connect failed: unexpected frame type=%s ok=%v", resEnvelope.Type, resEnvelope.OK)
 	}
 
-	hello := resEnvelope.Payload
-	c.logger.Printf("[ws] connected: protocol=%d tickIntervalMs=%d", hello.Protocol, hello.Policy.TickIntervalMs)
-
-	if hello.Auth != nil && hello.Auth.DeviceToken != "" {
-		c.logger.Printf("[ws] received deviceToken from server")
-		if c.onDeviceToken != nil {
-			c.onDeviceToken(hello.Auth.DeviceToken)
+	hello = resEnvelope.Payload
+	if err := c.verifyGateway(hello.Gateway, reqFrame.ID, deviceID, challenge.Nonce); err != nil {
+		return hello, err
+	}
+	if rotating {
+		if err := c.completeRotation(hello.Rotation, rotation); err != nil {
+			return hello, err
 		}
-		c.cfg.Auth.DeviceToken = hello.Auth.DeviceToken
 	}
+	c.logger.Printf("[ws] connected: protocol=%d tickIntervalMs=%d", hello.Protocol, hello.Policy.TickIntervalMs)
 
-	tickMs := hello.Policy.TickIntervalMs
-	if tickMs <= 0 {
-		tickMs = c.cfg.TickInterval()
+	if hello.Auth != nil {
+		if hello.Auth.DeviceToken != "" {
+			c.logger.Printf("[ws] received deviceToken from server")
+			c.applyDeviceToken(hello.Auth.DeviceToken, hello.Auth.ExpiresAt)
+		} else if hello.Auth.ExpiresAt > 0 {
+			c.tokenMu.Lock()
+			if c.cfg.Auth.DeviceToken != "" && auth.Token == c.cfg.Auth.DeviceToken {
+				c.cfg.Auth.DeviceTokenExpiresAt = hello.Auth.ExpiresAt
+			}
+			c.tokenMu.Unlock()
+			c.notifyTokenUpdated()
+		}
 	}
 
-	ctx, cancel := context.WithCancel(ctx)
-	defer cancel()
-
-	go heartbeat.Start(ctx, tickMs, c)
-
-	go func() {
-		if err := c.readLoop(ctx); err != nil {
-			c.logger.Printf("[ws] read loop error: %v", err)
+	if auth.EnrollmentToken != "" {
+		if hello.Auth == nil || hello.Auth.DeviceToken == "" {
+			return hello, errors.New("enrollment accepted but no deviceToken issued")
 		}
-		cancel()
-	}()
-
-	<-ctx.Done()
-	return ctx.Err()
+		// enrollmentToken 为一次性令牌，注册成功后不再使用。
+		c.cfg.Auth.EnrollmentToken = ""
+		c.logger.Printf("[ws] enrollment approved: deviceId=%s", deviceID)
+	}
+	return hello, nil
 }
 
 func (c *Client) SendHeartbeat(ctx context.Context, snap metrics.Snapshot) error {
@@ -182,7 +412,7 @@ func (c *Client) SendHeartbeat(ctx context.Context, snap metrics.Snapshot) error
 	}
 
 	payload := protocol.HeartbeatPayload{
-		DeviceID: agentcrypto.DeviceID(c.keyPair.Public),
+		DeviceID: agentcrypto.DeviceID(c.keys.Load().PublicKey()),
 		TS:       time.Now().UnixMilli(),
 		Metrics: &protocol.MetricsSnapshot{
 			CPUPercent:   snap.CPUPercent,
@@ -217,31 +447,127 @@ func (c *Client) SendHeartbeat(ctx context.Context, snap metrics.Snapshot) error
 }
 
 func (c *Client) HandleCommand(ctx context.Context, payload protocol.CommandPushPayload) error {
+	raw, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("marshal command.push: %w", err)
+	}
+	return c.runCommand(ctx, payload, raw, c.openStdin(payload))
+}
+
+// runCommand 执行 command.push；raw 为帧中原样传输的 payload，用于校验运维人员签名。
+// stdin 须在收到推送时同步打开，避免紧随其后的 command.stdin.write 找不到任务。
+func (c *Client) runCommand(ctx context.Context, payload protocol.CommandPushPayload, raw json.RawMessage, stdin commandStdin) error {
+	defer c.releaseStdin(payload.TaskUUID, stdin.stream)
+
 	c.logger.Printf("[ws] received command.push: task=%s cmd=%s", payload.TaskUUID, payload.Command)
 
+	if err := c.checkReplay(payload); err != nil {
+		c.logger.Printf("[ws] reject command.push: task=%s err=%v", payload.TaskUUID, err)
+		c.sendFrameRejected(ctx, protocol.FrameRejectedPayload{
+			Event:    protocol.EventCommandPush,
+			TaskUUID: payload.TaskUUID,
+			Code:     replay.ErrorCode(err),
+			Message:  err.Error(),
+		})
+		return nil
+	}
+	// checkReplay 已记录 nonce，使并发的重复推送无法同时通过；命令在执行前被拒绝时撤销记录，
+	// 被拒绝的推送不占用 nonce，服务端可在补签或审批通过后重发。
+	executed := false
+	defer func() {
+		if !executed {
+			c.releaseReplay(payload)
+		}
+	}()
+
+	agentID := agentcrypto.DeviceID(c.keys.Load().PublicKey())
+
+	var operatorID string
+	if c.operators != nil {
+		id, err := c.operators.Authorize(payload, raw, agentID)
+		if err != nil {
+			c.logger.Printf("[ws] reject command.push: task=%s err=%v", payload.TaskUUID, err)
+			c.sendFrameRejected(ctx, protocol.FrameRejectedPayload{
+				Event:    protocol.EventCommandPush,
+				TaskUUID: payload.TaskUUID,
+				Code:     operator.ErrorCode(err),
+				Message:  err.Error(),
+			})
+			return nil
+		}
+		operatorID = id
+		if operatorID != "" {
+			c.logger.Printf("[ws] command authorized by operator=%s task=%s", operatorID, payload.TaskUUID)
+		}
+	}
+
 	if c.executor == nil {
 		c.logger.Printf("[ws] executor not configured, skip execution")
 		return nil
 	}
 
-	timeout := time.Duration(payload.TimeoutSeconds) * time.Second
-	if timeout <= 0 {
-		timeout = 30 * time.Second
+	spec := commandSpec(payload, stdin.reader)
+	var resolved agentexec.Resolved
+	if c.policy != nil {
+		// 策略按实际生效的可执行文件、工作目录与运行用户评估；无法解析的命令同样无法执行，直接回报。
+		var err error
+		if resolved, err = c.executor.Resolve(spec); err != nil {
+			c.logger.Printf("[ws] resolve command failed: task=%s err=%v", payload.TaskUUID, err)
+			c.sendCommandError(ctx, payload, agentID, operatorID, "", "", err.Error())
+			return nil
+		}
+	}
+	if decision, err := c.checkPolicy(payload, resolved, operatorID); err != nil || decision.ErrorCode() != "" {
+		code, message := decision.ErrorCode(), decision.Message()
+		if err != nil {
+			code, message = policy.ErrorCodeInvalid, err.Error()
+		}
+		c.logger.Printf("[ws] command denied by policy: task=%s code=%s rule=%s", payload.TaskUUID, code, decision.RuleID)
+		c.sendCommandError(ctx, payload, agentID, operatorID, code, decision.RuleID, message)
+		return nil
 	}
 
-	agentID := agentcrypto.DeviceID(c.keyPair.Public)
+	if stdin.err != nil {
+		c.logger.Printf("[ws] invalid stdin: task=%s err=%v", payload.TaskUUID, stdin.err)
+		c.sendCommandError(ctx, payload, agentID, operatorID, errorCodeStdinInvalid, "", stdin.err.Error())
+		return nil
+	}
 
-	chunks := c.executor.RunStream(ctx, payload.Command, payload.WorkDir, timeout)
+	executed = true
+	chunks := agentexec.RunWithRetry(ctx, c.executor, spec, retryFromPayload(payload.Retry))
 	for chunk := range chunks {
 		rc := protocol.ResultChunkPayload{
 			TaskUUID:      payload.TaskUUID,
 			CorrelationID: payload.CorrelationID,
 			AgentID:       agentID,
+			OperatorID:    operatorID,
 			Seq:           chunk.Seq,
+			Stream:        chunk.Stream,
+			Offset:        chunk.Offset,
+			ElapsedUs:     chunk.Elapsed.Microseconds(),
 			StdoutChunk:   chunk.StdoutChunk,
 			StderrChunk:   chunk.StderrChunk,
+			Encoding:      chunk.Encoding,
+			LimitExceeded: chunk.LimitExceeded,
+			Attempt:       chunk.Attempt,
 			Final:         chunk.Final,
 		}
+		if chunk.Truncation != nil {
+			rc.Truncated = &protocol.OutputTruncation{Stream: chunk.Truncation.Stream, DroppedBytes: chunk.Truncation.DroppedBytes}
+		}
+		if chunk.RetryAfter > 0 {
+			// 将被重试的尝试的汇总分片：至少 1ms，以便 Server 据此区分。
+			rc.RetryAfterMs = max(chunk.RetryAfter.Milliseconds(), 1)
+		}
+		if chunk.Final || chunk.RetryAfter > 0 {
+			rc.StdoutBytes, rc.StderrBytes = chunk.StdoutBytes, chunk.StderrBytes
+			rc.StdoutDroppedBytes, rc.StderrDroppedBytes = chunk.StdoutDropped, chunk.StderrDropped
+			rc.OutputDropped = chunk.StdoutDropped > 0 || chunk.StderrDropped > 0
+			rc.TimedOut, rc.TerminationSignal, rc.Escalated = chunk.TimedOut, chunk.TerminationSignal, chunk.Escalated
+			rc.Redactions = chunk.Redactions
+			rc.LimitMode, rc.UnenforcedLimits = chunk.LimitMode, chunk.UnenforcedLimits
+			rc.Execution = executionToPayload(chunk.Execution)
+		}
 		if chunk.ExitCode != nil {
 			rc.ExitCode = chunk.ExitCode
 		}
@@ -255,6 +581,87 @@ func (c *Client) HandleCommand(ctx context.Context, payload protocol.CommandPush
 	return nil
 }
 
+// commandSpec 将 command.push 负载转换为执行参数；未指定超时时为 30 秒。
+func commandSpec(payload protocol.CommandPushPayload, stdin io.Reader) agentexec.Spec {
+	timeout := time.Duration(payload.TimeoutSeconds) * time.Second
+	if timeout <= 0 {
+		timeout = 30 * time.Second
+	}
+	return agentexec.Spec{
+		Command:     payload.Command,
+		Argv:        payload.Argv,
+		Executable:  payload.Executable,
+		Script:      scriptFromPayload(payload.Script),
+		WorkDir:     payload.WorkDir,
+		Env:         payload.Env,
+		RunAs:       runAsFromPayload(payload.RunAs),
+		Limits:      limitsFromPayload(payload.Limits),
+		Stdin:       stdin,
+		Output:      outputFromPayload(payload.Output),
+		Termination: terminationFromPayload(payload.Termination),
+		TTY:         ttyFromPayload(payload),
+		Timeout:     timeout,
+	}
+}
+
+// sendCommandError 以单个最终分片回报未执行即被拒绝的命令。
+func (c *Client) sendCommandError(ctx context.Context, payload protocol.CommandPushPayload, agentID, operatorID, code, ruleID, message string) {
+	exitCode := -1
+	rc := protocol.ResultChunkPayload{
+		TaskUUID:      payload.TaskUUID,
+		CorrelationID: payload.CorrelationID,
+		AgentID:       agentID,
+		OperatorID:    operatorID,
+		Seq:           1,
+		StderrChunk:   message,
+		ExitCode:      &exitCode,
+		ErrorCode:     code,
+		PolicyRuleID:  ruleID,
+		Final:         true,
+	}
+	if err := c.sendResultChunk(ctx, rc); err != nil {
+		c.logger.Printf("[ws] send result.chunk failed: %v", err)
+	}
+}
+
+// checkPolicy 按本地命令策略评估推送；resolved 为 Executor 解析出的实际可执行文件、工作目录与运行用户，
+// 使 "curl" 与 "/usr/bin/curl"、"~" 与 $HOME、UID "0" 与 "root" 按同一值匹配。
+// 策略文件无法解析时返回错误（调用方拒绝命令）。
+func (c *Client) checkPolicy(payload protocol.CommandPushPayload, resolved agentexec.Resolved, operatorID string) (policy.Decision, error) {
+	if c.policy == nil {
+		return policy.Decision{Action: policy.ActionAllow}, nil
+	}
+	return c.policy.Evaluate(policy.Request{
+		Command:    operator.CommandText(payload),
+		Executable: resolved.Executable,
+		WorkDir:    resolved.WorkDir,
+		RunAs:      resolved.User,
+		Operator:   operatorID,
+	})
+}
+
+// checkReplay 拒绝过期或 nonce 重复的推送。
+func (c *Client) checkReplay(payload protocol.CommandPushPayload) error {
+	if c.replayGuard == nil {
+		return nil
+	}
+	legacy := payload.Nonce == "" && payload.IssuedAt <= 0 && payload.ExpiresAt <= 0
+	if legacy && c.cfg != nil && c.cfg.Replay.AllowMissingFields {
+		return nil
+	}
+	return c.replayGuard.Check(payload.Nonce, payload.IssuedAt, payload.ExpiresAt)
+}
+
+// releaseReplay 撤销 checkReplay 记录的 nonce。
+func (c *Client) releaseReplay(payload protocol.CommandPushPayload) {
+	if c.replayGuard == nil || payload.Nonce == "" {
+		return
+	}
+	if err := c.replayGuard.Release(payload.Nonce); err != nil {
+		c.logger.Printf("[ws] release replay nonce failed: task=%s err=%v", payload.TaskUUID, err)
+	}
+}
+
 func (c *Client) CloseTerminalSessions(ctx context.Context) error {
 	if c.terminalManager == nil {
 		return nil
@@ -280,6 +687,7 @@ func (c *Client) handleTerminalEvent(ctx context.Context, event string, raw json
 			Shell:     payload.Shell,
 			Cwd:       payload.Cwd,
 			Env:       payload.Env,
+			RunAs:     runAsFromPayload(payload.RunAs),
 			Cols:      payload.Cols,
 			Rows:      payload.Rows,
 			Title:     payload.Title,
@@ -340,12 +748,17 @@ func (c *Client) readLoop(ctx context.Context) error {
 				Type    string          `json:"type"`
 				Event   string          `json:"event"`
 				Payload json.RawMessage `json:"payload"`
+				Sig     string          `json:"sig"`
 			}
 			if err := json.Unmarshal(msg, &ev); err != nil {
 				c.logger.Printf("[ws] invalid event frame: %v", err)
 				continue
 			}
 
+			if !c.admitEvent(ctx, ev.Event, ev.Payload, ev.Sig) {
+				continue
+			}
+
 			switch ev.Event {
 			case protocol.EventCommandPush:
 				var payload protocol.CommandPushPayload
@@ -353,12 +766,12 @@ func (c *Client) readLoop(ctx context.Context) error {
 					c.logger.Printf("[ws] invalid command.push payload: %v", err)
 					continue
 				}
-				// 命令执行放到独立 goroutine，避免阻塞 readLoop。
-				go func(p protocol.CommandPushPayload) {
-					if err := c.HandleCommand(ctx, p); err != nil {
+				// 命令执行放到独立 goroutine，避免阻塞 readLoop；stdin 在此同步打开以保证后续写入有序。
+				go func(p protocol.CommandPushPayload, raw json.RawMessage, stdin commandStdin) {
+					if err := c.runCommand(ctx, p, raw, stdin); err != nil {
 						c.logger.Printf("[ws] handle command error: %v", err)
 					}
-				}(payload)
+				}(payload, ev.Payload, c.openStdin(payload))
 			case protocol.EventResultAck:
 				var ack protocol.ResultAckPayload
 				if err := json.Unmarshal(ev.Payload, &ack); err != nil {
@@ -366,6 +779,8 @@ func (c *Client) readLoop(ctx context.Context) error {
 					continue
 				}
 				c.logger.Printf("[ws] received result.ack: task=%s seq=%d", ack.TaskUUID, ack.Seq)
+			case protocol.EventCommandStdinWrite, protocol.EventCommandStdinClose:
+				c.handleStdinEvent(ctx, ev.Event, ev.Payload)
 			case protocol.EventTerminalSessionOpen,
 				protocol.EventTerminalStdinWrite,
 				protocol.EventTerminalSessionResize,
@@ -374,12 +789,29 @@ func (c *Client) readLoop(ctx context.Context) error {
 				if err := c.handleTerminalEvent(ctx, ev.Event, ev.Payload); err != nil {
 					c.logger.Printf("[ws] handle %s error: %v", ev.Event, err)
 				}
+			case protocol.EventAuthTokenRotate:
+				var rotate protocol.TokenRotatePayload
+				if err := json.Unmarshal(ev.Payload, &rotate); err != nil {
+					c.logger.Printf("[ws] invalid auth.token.rotate payload: %v", err)
+					continue
+				}
+				c.handleTokenRotate(rotate)
+			case protocol.EventRedactionUpdate:
+				var update protocol.RedactionUpdatePayload
+				if err := json.Unmarshal(ev.Payload, &update); err != nil {
+					c.logger.Printf("[ws] invalid redaction.update payload: %v", err)
+					continue
+				}
+				c.redactor.SetValues(redact.SourceServer, update.Values)
+				c.logger.Printf("[ws] redaction values updated: count=%d", len(update.Values))
 			case "disconnect":
 				c.logger.Printf("[ws] received disconnect event, closing")
 				return nil
 			default:
 				c.logger.Printf("[ws] ignore event=%s", ev.Event)
 			}
+		case protocol.FrameTypeResponse:
+			c.handleResponse(msg)
 		default:
 			c.logger.Printf("[ws] ignore frame type=%s", base.Type)
 		}
@@ -458,13 +890,469 @@ func (c *Client) sendResultChunk(ctx context.Context, payload protocol.ResultChu
 	return nil
 }
 
+// loadGatewayKey 加载固定的网关公钥：优先使用配置项 gateway.publicKey，其次读取 TOFU 持久化文件。
+func (c *Client) loadGatewayKey() error {
+	if encoded := strings.TrimSpace(c.cfg.Gateway.PublicKey); encoded != "" {
+		pub, err := agentcrypto.ParsePublicKey(encoded)
+		if err != nil {
+			return fmt.Errorf("parse gateway.publicKey: %w", err)
+		}
+		c.gatewayKey = pub
+		return nil
+	}
+
+	pub, err := agentcrypto.LoadGatewayKey(c.cfg.GatewayKeyPath())
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return fmt.Errorf("load gateway key: %w", err)
+	}
+	c.gatewayKey = pub
+	return nil
+}
+
+// verifyGateway 校验 hello-ok 中的网关身份。
+//
+//   - 已固定公钥：hello-ok 必须携带相同公钥及有效签名；
+//   - 未固定公钥：签名有效时按 TOFU 固定并持久化（gateway.disableTofu=true 时拒绝）；
+//   - 未固定且网关未声明身份：兼容旧网关，继续连接。
+func (c *Client) verifyGateway(gw *protocol.HelloGateway, requestID, deviceID, nonce string) error {
+	if gw == nil || strings.TrimSpace(gw.PublicKey) == "" {
+		if c.gatewayKey != nil {
+			return fmt.Errorf("%w: hello-ok carries no gateway identity", ErrGatewayIdentity)
+		}
+		return nil
+	}
+
+	pub, err := agentcrypto.ParsePublicKey(gw.PublicKey)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrGatewayIdentity, err)
+	}
+	if c.gatewayKey != nil && !c.gatewayKey.Equal(pub) {
+		return fmt.Errorf("%w: gateway key mismatch: pinned=%s got=%s", ErrGatewayIdentity,
+			base64.StdEncoding.EncodeToString(c.gatewayKey), gw.PublicKey)
+	}
+	if err := agentcrypto.Verify(pub, agentcrypto.HelloSigningMessage(requestID, deviceID, nonce), gw.Signature); err != nil {
+		return fmt.Errorf("%w: hello-ok: %v", ErrGatewayIdentity, err)
+	}
+
+	if c.gatewayKey == nil {
+		if c.cfg.Gateway.DisableTOFU {
+			return fmt.Errorf("%w: no pinned gateway key and trust-on-first-use disabled", ErrGatewayIdentity)
+		}
+		path := c.cfg.GatewayKeyPath()
+		if err := agentcrypto.SaveGatewayKey(path, pub); err != nil {
+			return fmt.Errorf("pin gateway key: %w", err)
+		}
+		c.gatewayKey = pub
+		c.logger.Printf("[ws] pinned gateway key (trust on first use): %s -> %s", gw.PublicKey, path)
+	}
+	return nil
+}
+
+// admitEvent 对 signedEvents 中的事件校验网关签名，校验失败时回发 frame.rejected 并返回 false。
+func (c *Client) admitEvent(ctx context.Context, event string, payload json.RawMessage, sig string) bool {
+	if !signedEvents[event] {
+		return true
+	}
+	if err := c.verifyFrame(event, payload, sig); err != nil {
+		c.logger.Printf("[ws] reject %s: %v", event, err)
+		c.rejectFrame(ctx, event, payload, signatureErrorCode(err), err.Error())
+		return false
+	}
+	return true
+}
+
+// verifyFrame 校验入站事件帧的网关签名。
+//
+// gateway.allowUnsignedFrames 只在尚未固定网关公钥时生效：一旦固定，网关即具备签名能力，
+// 未签名的帧只可能来自中间人，必须拒绝。
+func (c *Client) verifyFrame(event string, payload json.RawMessage, sig string) error {
+	if c.gatewayKey == nil {
+		if c.cfg != nil && c.cfg.Gateway.AllowUnsignedFrames {
+			return nil
+		}
+		return errGatewayKeyUnknown
+	}
+	return agentcrypto.Verify(c.gatewayKey, agentcrypto.FrameSigningMessage(event, payload), sig)
+}
+
+// rejectFrame 回发 frame.rejected，告知服务端该帧未被执行。
+func (c *Client) rejectFrame(ctx context.Context, event string, raw json.RawMessage, code, message string) {
+	var ids struct {
+		TaskUUID  string `json:"task_uuid"`
+		SessionID string `json:"sessionId"`
+	}
+	_ = json.Unmarshal(raw, &ids)
+
+	c.sendFrameRejected(ctx, protocol.FrameRejectedPayload{
+		Event:     event,
+		TaskUUID:  ids.TaskUUID,
+		SessionID: ids.SessionID,
+		Code:      code,
+		Message:   message,
+	})
+}
+
+func (c *Client) sendFrameRejected(ctx context.Context, payload protocol.FrameRejectedPayload) {
+	if err := c.sendEvent(ctx, protocol.EventFrameRejected, payload); err != nil {
+		c.logger.Printf("[ws] send frame.rejected failed: %v", err)
+	}
+}
+
+var errGatewayKeyUnknown = errors.New("no pinned gateway key to verify signed frame")
+
+func signatureErrorCode(err error) string {
+	switch {
+	case errors.Is(err, errGatewayKeyUnknown):
+		return "GATEWAY_KEY_UNKNOWN"
+	case errors.Is(err, agentcrypto.ErrSignatureMissing):
+		return "SIGNATURE_MISSING"
+	default:
+		return "SIGNATURE_INVALID"
+	}
+}
+
 func (c *Client) selectAuthToken() string {
+	c.tokenMu.Lock()
+	defer c.tokenMu.Unlock()
 	if c.cfg.Auth.DeviceToken != "" {
 		return c.cfg.Auth.DeviceToken
 	}
 	return c.cfg.Auth.Token
 }
 
+// applyDeviceToken 切换到新的 deviceToken：更新运行时配置、通过 onDeviceToken 持久化，并重新规划续期。
+func (c *Client) applyDeviceToken(token string, expiresAt int64) {
+	if strings.TrimSpace(token) == "" {
+		return
+	}
+	// onDeviceToken 同样会写入 cfg，因此在持锁状态下调用。
+	c.tokenMu.Lock()
+	c.cfg.Auth.DeviceToken = token
+	c.cfg.Auth.DeviceTokenExpiresAt = expiresAt
+	if c.onDeviceToken != nil {
+		c.onDeviceToken(token)
+	}
+	c.tokenMu.Unlock()
+	c.redactAuthValues()
+
+	c.notifyTokenUpdated()
+}
+
+// newRedactor 按配置构造遮蔽器；未启用时返回 nil。
+// 附加正则无效时返回错误：悄悄忽略会让运维人员以为配置的密钥格式已被遮蔽。
+func newRedactor(cfg agentconfig.RedactConfig) (*redact.Redactor, error) {
+	if !cfg.Enabled {
+		return nil, nil
+	}
+	return redact.New(redact.Options{Builtins: cfg.Builtins, Patterns: cfg.Patterns})
+}
+
+// redactAuthValues 将 Agent 自身的认证令牌登记为待遮蔽值，避免 env / cat 配置之类的命令把它们回传给 server。
+func (c *Client) redactAuthValues() {
+	if c.redactor == nil {
+		return
+	}
+	c.tokenMu.Lock()
+	values := []string{c.cfg.Auth.Token, c.cfg.Auth.DeviceToken, c.cfg.Auth.EnrollmentToken}
+	c.tokenMu.Unlock()
+	c.redactor.SetValues(redact.SourceAuth, values)
+}
+
+func (c *Client) notifyTokenUpdated() {
+	if c.tokenUpdated == nil {
+		return
+	}
+	select {
+	case c.tokenUpdated <- struct{}{}:
+	default:
+	}
+}
+
+func (c *Client) handleTokenRotate(rotate protocol.TokenRotatePayload) {
+	if rotate.DeviceToken == "" {
+		c.logger.Printf("[ws] ignore auth.token.rotate without deviceToken")
+		return
+	}
+	c.applyDeviceToken(rotate.DeviceToken, rotate.ExpiresAt)
+	c.logger.Printf("[ws] device token rotated: expiresAt=%d", rotate.ExpiresAt)
+}
+
+// handleResponse 处理 Agent 主动发起请求的响应帧，目前仅有 auth.token.refresh。
+// 新令牌与 auth.token.rotate 一样须经网关签名，签名对象为 "auth.token.refresh|payload"。
+func (c *Client) handleResponse(msg []byte) {
+	var res struct {
+		ID        string              `json:"id"`
+		OK        bool                `json:"ok"`
+		Payload   json.RawMessage     `json:"payload"`
+		Error     *protocol.ErrorBody `json:"error,omitempty"`
+		Signature string              `json:"sig,omitempty"`
+	}
+	if err := json.Unmarshal(msg, &res); err != nil {
+		c.logger.Printf("[ws] invalid res frame: %v", err)
+		return
+	}
+
+	c.tokenMu.Lock()
+	pending := c.pendingRefreshID != "" && res.ID == c.pendingRefreshID
+	if pending {
+		c.pendingRefreshID = ""
+	}
+	c.tokenMu.Unlock()
+	if !pending {
+		c.logger.Printf("[ws] ignore res id=%s", res.ID)
+		return
+	}
+
+	if !res.OK {
+		if res.Error != nil {
+			c.logger.Printf("[ws] auth.token.refresh failed: %s: %s", res.Error.Code, res.Error.Message)
+		} else {
+			c.logger.Printf("[ws] auth.token.refresh failed")
+		}
+		return
+	}
+	if err := c.verifyFrame(protocol.MethodAuthTokenRefresh, res.Payload, res.Signature); err != nil {
+		c.logger.Printf("[ws] reject auth.token.refresh response: %v", err)
+		return
+	}
+	var rotate protocol.TokenRotatePayload
+	if err := json.Unmarshal(res.Payload, &rotate); err != nil {
+		c.logger.Printf("[ws] invalid auth.token.refresh payload: %v", err)
+		return
+	}
+	c.handleTokenRotate(rotate)
+}
+
+// refreshDelay 计算距下次主动续期的等待时长；deviceToken 或其过期时间未知时返回 false。
+func (c *Client) refreshDelay(now time.Time) (time.Duration, bool) {
+	c.tokenMu.Lock()
+	defer c.tokenMu.Unlock()
+
+	if c.cfg.Auth.DeviceToken == "" || c.cfg.Auth.DeviceTokenExpiresAt <= 0 {
+		return 0, false
+	}
+	before := time.Duration(c.cfg.Auth.RefreshBeforeSeconds) * time.Second
+	if before <= 0 {
+		return 0, false
+	}
+	if c.refreshJitter == 0 {
+		c.refreshJitter = time.Duration(rand.Int63n(int64(before)/2 + 1))
+	}
+
+	refreshAt := time.UnixMilli(c.cfg.Auth.DeviceTokenExpiresAt).Add(-before - c.refreshJitter)
+	delay := refreshAt.Sub(now)
+	if delay < 0 {
+		delay = 0
+	}
+	return delay, true
+}
+
+// tokenRefreshLoop 在 deviceToken 临近过期时主动请求续期；未收到新 token 时按固定间隔重试。
+func (c *Client) tokenRefreshLoop(ctx context.Context) {
+	var retry time.Duration
+	for {
+		var timerC <-chan time.Time
+		var timer *time.Timer
+		if delay, ok := c.refreshDelay(time.Now()); ok {
+			if delay < retry {
+				delay = retry
+			}
+			timer = time.NewTimer(delay)
+			timerC = timer.C
+		}
+
+		select {
+		case <-ctx.Done():
+			if timer != nil {
+				timer.Stop()
+			}
+			return
+		case <-c.tokenUpdated:
+			if timer != nil {
+				timer.Stop()
+			}
+			retry = 0
+		case <-timerC:
+			if err := c.requestTokenRefresh(ctx); err != nil {
+				c.logger.Printf("[ws] request token refresh failed: %v", err)
+			}
+			retry = tokenRefreshRetryInterval
+		}
+	}
+}
+
+func (c *Client) requestTokenRefresh(ctx context.Context) error {
+	if c.conn == nil {
+		return fmt.Errorf("no active websocket connection")
+	}
+
+	frame := protocol.RequestFrame{
+		Type:   protocol.FrameTypeRequest,
+		ID:     uuid.NewString(),
+		Method: protocol.MethodAuthTokenRefresh,
+		Params: protocol.TokenRefreshParams{DeviceID: agentcrypto.DeviceID(c.keys.Load().PublicKey())},
+	}
+	data, err := json.Marshal(frame)
+	if err != nil {
+		return fmt.Errorf("marshal token refresh: %w", err)
+	}
+
+	c.tokenMu.Lock()
+	c.pendingRefreshID = frame.ID
+	c.tokenMu.Unlock()
+
+	c.writeMu.Lock()
+	err = c.conn.Write(ctx, websocket.MessageText, data)
+	c.writeMu.Unlock()
+	if err != nil {
+		return fmt.Errorf("send token refresh: %w", err)
+	}
+	c.logger.Printf("[ws] requested device token refresh")
+	return nil
+}
+
+// completeRotation 根据 hello-ok 的确认结果提交轮换，未确认时返回 ErrRotationRejected。
+//
+// 未确认时不在此丢弃待确认密钥：拒绝统一由调用方（agentcrypto.RejectRotation）处理，它在存在待确认密钥时
+// 只丢弃该密钥；若此处先行丢弃，调用方会误以为被拒绝的是上一次已提交的轮换而恢复服务端已退役的旧密钥。
+// 提交后替换共享的设备密钥，并在 deviceToken 加密密钥由设备密钥派生时用新密钥重新加密 token。
+func (c *Client) completeRotation(ack *protocol.HelloRotation, next agentcrypto.KeyPair) error {
+	if ack == nil || ack.Status != "accepted" {
+		return fmt.Errorf("%w: hello-ok did not acknowledge rotation", ErrRotationRejected)
+	}
+
+	if err := agentcrypto.CommitRotation(c.cfg.Keys.Dir); err != nil {
+		return fmt.Errorf("commit key rotation: %w", err)
+	}
+	c.keys.Replace(next)
+	c.logger.Printf("[ws] key rotation committed: deviceId=%s", agentcrypto.DeviceID(next.Public))
+
+	if strings.TrimSpace(c.cfg.Auth.TokenKeyFile) == "" {
+		key, err := agentcrypto.DeriveTokenKey(next)
+		if err != nil {
+			return fmt.Errorf("derive device token key: %w", err)
+		}
+		c.tokenMu.Lock()
+		err = c.cfg.RekeyDeviceToken(key)
+		c.tokenMu.Unlock()
+		if err != nil {
+			c.logger.Printf("[ws] re-encrypt device token error: %v", err)
+		}
+	}
+	return nil
+}
+
+func runAsFromPayload(r *protocol.RunAs) *proc.RunAs {
+	if r == nil {
+		return nil
+	}
+	return &proc.RunAs{User: r.User, Group: r.Group, Groups: r.Groups}
+}
+
+func scriptFromPayload(s *protocol.ScriptPayload) *agentexec.Script {
+	if s == nil {
+		return nil
+	}
+	return &agentexec.Script{Body: []byte(s.Body), Interpreter: s.Interpreter, Args: s.Args, SHA256: s.SHA256}
+}
+
+func outputFromPayload(o *protocol.OutputLimits) agentexec.OutputLimits {
+	if o == nil {
+		return agentexec.OutputLimits{}
+	}
+	return agentexec.OutputLimits{MaxStdoutBytes: o.MaxStdoutBytes, MaxStderrBytes: o.MaxStderrBytes, TailBytes: o.TailBytes}
+}
+
+func executionToPayload(e *agentexec.Execution) *protocol.ExecutionInfo {
+	if e == nil {
+		return nil
+	}
+	return &protocol.ExecutionInfo{
+		StartedAt:   e.StartedAt.UnixMilli(),
+		EndedAt:     e.EndedAt.UnixMilli(),
+		DurationMs:  e.Duration().Milliseconds(),
+		PID:         e.PID,
+		WorkDir:     e.WorkDir,
+		User:        e.User,
+		Signaled:    e.Signal != "",
+		Signal:      e.Signal,
+		UserCPUMs:   e.UserCPU.Milliseconds(),
+		SystemCPUMs: e.SystemCPU.Milliseconds(),
+		MaxRSSBytes: e.MaxRSSBytes,
+		InBlocks:    e.InBlocks,
+		OutBlocks:   e.OutBlocks,
+	}
+}
+
+func ttyFromPayload(p protocol.CommandPushPayload) *agentexec.TTY {
+	if !p.TTY {
+		return nil
+	}
+	return &agentexec.TTY{Cols: p.Cols, Rows: p.Rows, Term: p.Term}
+}
+
+func retryFromPayload(r *protocol.RetryPolicy) agentexec.Retry {
+	if r == nil {
+		return agentexec.Retry{}
+	}
+	return agentexec.Retry{
+		MaxAttempts:   r.MaxAttempts,
+		Backoff:       time.Duration(r.BackoffMs) * time.Millisecond,
+		MaxBackoff:    time.Duration(r.MaxBackoffMs) * time.Millisecond,
+		ExitCodes:     r.ExitCodes,
+		StderrPattern: r.StderrPattern,
+	}
+}
+
+func terminationFromPayload(t *protocol.TerminationPolicy) agentexec.Termination {
+	if t == nil {
+		return agentexec.Termination{}
+	}
+	return agentexec.Termination{Signal: t.Signal, Grace: time.Duration(t.GraceSeconds) * time.Second}
+}
+
+// limitsFromPayload 将 command.push 的资源限制转换为 proc.Limits（MemoryMB 换算为字节）。
+func limitsFromPayload(l *protocol.ResourceLimits) proc.Limits {
+	if l == nil {
+		return proc.Limits{}
+	}
+	return proc.Limits{
+		MemoryBytes: l.MemoryMB << 20,
+		CPUQuota:    l.CPUQuota,
+		Pids:        l.Pids,
+		NoFile:      l.NoFile,
+		CPUSeconds:  l.CPUSeconds,
+	}
+}
+
+// isPendingErrorCode 报告 connect 错误是否表示注册待审批；只认精确的错误码，
+// 其他含有 PENDING 字样的错误码不代表待审批，不能据此进入轮询。
+func isPendingErrorCode(code string) bool {
+	return code == protocol.ErrorCodeEnrollmentPending
+}
+
+// collectHostFacts 采集注册时上报的主机信息。
+func collectHostFacts() *protocol.HostFacts {
+	facts := metrics.CollectHostFacts()
+	return &protocol.HostFacts{
+		Hostname:        facts.Hostname,
+		OS:              facts.OS,
+		Arch:            facts.Arch,
+		Platform:        facts.Platform,
+		PlatformVersion: facts.PlatformVersion,
+		KernelVersion:   facts.KernelVersion,
+		MachineID:       facts.MachineID,
+		IPs:             facts.IPs,
+	}
+}
+
+func isRotationErrorCode(code string) bool {
+	return strings.Contains(strings.ToUpper(code), "ROTATION")
+}
+
 func isAuthErrorCode(code string) bool {
 	up := strings.ToUpper(strings.TrimSpace(code))
 	if up == "" {
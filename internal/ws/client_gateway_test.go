@@ -0,0 +1,262 @@
+package ws
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	agentconfig "devops-agent/internal/config"
+	agentcrypto "devops-agent/internal/crypto"
+	"devops-agent/internal/operator"
+	"devops-agent/internal/protocol"
+	"devops-agent/internal/replay"
+)
+
+func TestVerifyFrameRequiresValidGatewaySignature(t *testing.T) {
+	pub, priv := mustGenerateKey(t)
+	client := &Client{cfg: &agentconfig.Config{}, gatewayKey: pub}
+
+	payload := json.RawMessage(`{"task_uuid":"t-1","command":"uptime"}`)
+	valid := signFrame(priv, protocol.EventCommandPush, payload)
+
+	if err := client.verifyFrame(protocol.EventCommandPush, payload, valid); err != nil {
+		t.Fatalf("verifyFrame(valid) error = %v", err)
+	}
+	if err := client.verifyFrame(protocol.EventCommandPush, payload, ""); !errors.Is(err, agentcrypto.ErrSignatureMissing) {
+		t.Fatalf("verifyFrame(unsigned) error = %v, want %v", err, agentcrypto.ErrSignatureMissing)
+	}
+
+	tampered := json.RawMessage(`{"task_uuid":"t-1","command":"rm -rf /"}`)
+	if err := client.verifyFrame(protocol.EventCommandPush, tampered, valid); !errors.Is(err, agentcrypto.ErrSignatureInvalid) {
+		t.Fatalf("verifyFrame(tampered) error = %v, want %v", err, agentcrypto.ErrSignatureInvalid)
+	}
+	if err := client.verifyFrame(protocol.EventTerminalSessionOpen, payload, valid); !errors.Is(err, agentcrypto.ErrSignatureInvalid) {
+		t.Fatalf("verifyFrame(other event) error = %v, want %v", err, agentcrypto.ErrSignatureInvalid)
+	}
+}
+
+func TestVerifyFrameWithoutPinnedKey(t *testing.T) {
+	client := &Client{cfg: &agentconfig.Config{}}
+	if err := client.verifyFrame(protocol.EventCommandPush, json.RawMessage(`{}`), ""); signatureErrorCode(err) != "GATEWAY_KEY_UNKNOWN" {
+		t.Fatalf("verifyFrame() error = %v, want GATEWAY_KEY_UNKNOWN", err)
+	}
+
+	client.cfg.Gateway.AllowUnsignedFrames = true
+	if err := client.verifyFrame(protocol.EventCommandPush, json.RawMessage(`{}`), ""); err != nil {
+		t.Fatalf("verifyFrame() with allowUnsignedFrames error = %v", err)
+	}
+}
+
+func TestVerifyFrameIgnoresAllowUnsignedOncePinned(t *testing.T) {
+	pub, _ := mustGenerateKey(t)
+	client := &Client{
+		cfg:        &agentconfig.Config{Gateway: agentconfig.GatewayConfig{AllowUnsignedFrames: true}},
+		gatewayKey: pub,
+	}
+	if err := client.verifyFrame(protocol.EventCommandPush, json.RawMessage(`{}`), ""); !errors.Is(err, agentcrypto.ErrSignatureMissing) {
+		t.Fatalf("verifyFrame(unsigned, pinned) error = %v, want %v", err, agentcrypto.ErrSignatureMissing)
+	}
+}
+
+func TestVerifyGatewayPinsKeyOnFirstUse(t *testing.T) {
+	pub, priv := mustGenerateKey(t)
+	keyPath := filepath.Join(t.TempDir(), "gateway.pub")
+	client := &Client{
+		cfg:    &agentconfig.Config{Gateway: agentconfig.GatewayConfig{KeyPath: keyPath}},
+		logger: log.New(io.Discard, "", 0),
+	}
+
+	gw := signHello(pub, priv, "req-1", "device-1", "nonce-1")
+	if err := client.verifyGateway(gw, "req-1", "device-1", "nonce-1"); err != nil {
+		t.Fatalf("verifyGateway() error = %v", err)
+	}
+	if !client.gatewayKey.Equal(pub) {
+		t.Fatalf("gatewayKey not pinned after first use")
+	}
+
+	pinned, err := agentcrypto.LoadGatewayKey(keyPath)
+	if err != nil {
+		t.Fatalf("LoadGatewayKey() error = %v", err)
+	}
+	if !pinned.Equal(pub) {
+		t.Fatalf("persisted gateway key mismatch")
+	}
+}
+
+func TestVerifyGatewayRejectsMismatchAndReplay(t *testing.T) {
+	pinnedPub, _ := mustGenerateKey(t)
+	otherPub, otherPriv := mustGenerateKey(t)
+	client := &Client{cfg: &agentconfig.Config{}, gatewayKey: pinnedPub}
+
+	gw := signHello(otherPub, otherPriv, "req-1", "device-1", "nonce-1")
+	if err := client.verifyGateway(gw, "req-1", "device-1", "nonce-1"); !errors.Is(err, ErrGatewayIdentity) {
+		t.Fatalf("verifyGateway(mismatch) error = %v, want %v", err, ErrGatewayIdentity)
+	}
+	if err := client.verifyGateway(nil, "req-1", "device-1", "nonce-1"); !errors.Is(err, ErrGatewayIdentity) {
+		t.Fatalf("verifyGateway(nil) error = %v, want %v", err, ErrGatewayIdentity)
+	}
+
+	client.gatewayKey = otherPub
+	if err := client.verifyGateway(gw, "req-2", "device-1", "nonce-1"); !errors.Is(err, ErrGatewayIdentity) {
+		t.Fatalf("verifyGateway(replayed) error = %v, want %v", err, ErrGatewayIdentity)
+	}
+}
+
+func TestRejectFrameEmitsFrameRejected(t *testing.T) {
+	client, sent := newEventRecordingClient()
+
+	client.rejectFrame(context.Background(), protocol.EventCommandPush, json.RawMessage(`{"task_uuid":"t-1"}`), "SIGNATURE_MISSING", "signature missing")
+
+	if len(*sent) != 1 {
+		t.Fatalf("sent events = %d, want 1", len(*sent))
+	}
+	if (*sent)[0].event != protocol.EventFrameRejected {
+		t.Fatalf("event = %q, want %q", (*sent)[0].event, protocol.EventFrameRejected)
+	}
+	payload := (*sent)[0].payload.(protocol.FrameRejectedPayload)
+	if payload.TaskUUID != "t-1" || payload.Code != "SIGNATURE_MISSING" {
+		t.Fatalf("payload = %#v, want task t-1 with SIGNATURE_MISSING", payload)
+	}
+}
+
+func TestAdmitEventRejectsUnsignedStdin(t *testing.T) {
+	pub, priv := mustGenerateKey(t)
+	client, sent := newEventRecordingClient()
+	client.cfg = &agentconfig.Config{}
+	client.gatewayKey = pub
+
+	for _, event := range []string{protocol.EventCommandStdinWrite, protocol.EventCommandStdinClose} {
+		payload := json.RawMessage(`{"task_uuid":"t-1","seq":1,"data":"cm0gLXJmIC8K"}`)
+		if client.admitEvent(context.Background(), event, payload, "") {
+			t.Fatalf("admitEvent(%s, unsigned) = true, want rejection", event)
+		}
+		if !client.admitEvent(context.Background(), event, payload, signFrame(priv, event, payload)) {
+			t.Fatalf("admitEvent(%s, signed) = false, want accepted", event)
+		}
+	}
+	if len(*sent) != 2 {
+		t.Fatalf("sent events = %d, want one frame.rejected per unsigned frame", len(*sent))
+	}
+	for _, ev := range *sent {
+		if payload := ev.payload.(protocol.FrameRejectedPayload); ev.event != protocol.EventFrameRejected || payload.Code != "SIGNATURE_MISSING" {
+			t.Fatalf("sent %q %#v, want frame.rejected with SIGNATURE_MISSING", ev.event, payload)
+		}
+	}
+}
+
+func TestSignedEventsCoverStateChangingEvents(t *testing.T) {
+	for _, event := range []string{
+		protocol.EventCommandPush,
+		protocol.EventCommandStdinWrite,
+		protocol.EventCommandStdinClose,
+		protocol.EventTerminalSessionOpen,
+		protocol.EventAuthTokenRotate,
+		protocol.EventRedactionUpdate,
+	} {
+		if !signedEvents[event] {
+			t.Fatalf("signedEvents[%q] = false, want signature required", event)
+		}
+	}
+}
+
+func TestNewClientFailsClosedOnCorruptReplayCache(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "replay_nonces.json")
+	if err := os.WriteFile(path, []byte("{not json"), 0o600); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	cfg := &agentconfig.Config{Replay: agentconfig.ReplayConfig{CachePath: path}}
+
+	if _, err := NewClient(cfg, new(agentcrypto.SharedKeyStore), log.New(io.Discard, "", 0), nil); err == nil {
+		t.Fatalf("NewClient() error = nil, want failure on a corrupt replay cache")
+	}
+}
+
+func TestRejectedCommandDoesNotConsumeNonce(t *testing.T) {
+	guard, err := replay.Open(replay.Options{})
+	if err != nil {
+		t.Fatalf("replay.Open() error = %v", err)
+	}
+	verifier, err := operator.NewVerifier(operator.Options{
+		AllowlistPath: filepath.Join(t.TempDir(), "operators.yaml"),
+		Required:      true,
+	})
+	if err != nil {
+		t.Fatalf("NewVerifier() error = %v", err)
+	}
+	client, sent := newEventRecordingClient()
+	client.cfg = &agentconfig.Config{}
+	client.keys = agentcrypto.NewSharedKeyStore(agentcrypto.KeyPair{})
+	client.replayGuard = guard
+	client.operators = verifier
+
+	payload := protocol.CommandPushPayload{TaskUUID: "t-1", Command: "uptime", IssuedAt: time.Now().UnixMilli(), Nonce: "n-1"}
+	raw, err := json.Marshal(payload)
+	if err != nil {
+		t.Fatalf("Marshal() error = %v", err)
+	}
+	// 未签名的推送被拒绝后重发，拒绝原因仍是签名缺失而不是重放。
+	for i := 0; i < 2; i++ {
+		if err := client.runCommand(context.Background(), payload, raw, commandStdin{}); err != nil {
+			t.Fatalf("runCommand() error = %v", err)
+		}
+		rejected, ok := (*sent)[i].payload.(protocol.FrameRejectedPayload)
+		if !ok || rejected.Code != "OPERATOR_SIGNATURE_REQUIRED" {
+			t.Fatalf("sent[%d] = %#v, want OPERATOR_SIGNATURE_REQUIRED rejection", i, (*sent)[i])
+		}
+	}
+	if err := guard.Check("n-1", payload.IssuedAt, 0); err != nil {
+		t.Fatalf("Check(n-1) error = %v, want nonce released after rejection", err)
+	}
+}
+
+func TestNewClientFailsOnInvalidHighRiskPatterns(t *testing.T) {
+	cfg := &agentconfig.Config{
+		Operators: agentconfig.OperatorsConfig{HighRiskPatterns: []string{`rm\s+-rf`, "("}},
+		Replay:    agentconfig.ReplayConfig{CachePath: filepath.Join(t.TempDir(), "replay_nonces.json")},
+	}
+
+	if _, err := NewClient(cfg, new(agentcrypto.SharedKeyStore), log.New(io.Discard, "", 0), nil); err == nil {
+		t.Fatalf("NewClient() error = nil, want failure on an invalid high-risk pattern")
+	}
+}
+
+func TestNewClientFailsOnInvalidRedactPatterns(t *testing.T) {
+	cfg := &agentconfig.Config{
+		Redact: agentconfig.RedactConfig{Enabled: true, Builtins: true, Patterns: []string{`internal-[0-9a-f]{32}`, "("}},
+		Replay: agentconfig.ReplayConfig{CachePath: filepath.Join(t.TempDir(), "replay_nonces.json")},
+	}
+
+	if _, err := NewClient(cfg, new(agentcrypto.SharedKeyStore), log.New(io.Discard, "", 0), nil); err == nil {
+		t.Fatalf("NewClient() error = nil, want failure on an invalid redact pattern")
+	}
+}
+
+func mustGenerateKey(t *testing.T) (ed25519.PublicKey, ed25519.PrivateKey) {
+	t.Helper()
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey() error = %v", err)
+	}
+	return pub, priv
+}
+
+func signFrame(priv ed25519.PrivateKey, event string, payload []byte) string {
+	return base64.StdEncoding.EncodeToString(ed25519.Sign(priv, agentcrypto.FrameSigningMessage(event, payload)))
+}
+
+func signHello(pub ed25519.PublicKey, priv ed25519.PrivateKey, requestID, deviceID, nonce string) *protocol.HelloGateway {
+	sig := ed25519.Sign(priv, agentcrypto.HelloSigningMessage(requestID, deviceID, nonce))
+	return &protocol.HelloGateway{
+		PublicKey: base64.StdEncoding.EncodeToString(pub),
+		Signature: base64.StdEncoding.EncodeToString(sig),
+	}
+}
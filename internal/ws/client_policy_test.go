@@ -0,0 +1,88 @@
+package ws
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	agentexec "devops-agent/internal/exec"
+	"devops-agent/internal/policy"
+	"devops-agent/internal/proc"
+	"devops-agent/internal/protocol"
+)
+
+func TestCheckPolicyMatchesResolvedValues(t *testing.T) {
+	dir := t.TempDir()
+	bin := filepath.Join(dir, "bin")
+	secret := filepath.Join(dir, "secret")
+	for _, d := range []string{bin, secret, filepath.Join(dir, "other")} {
+		if err := os.Mkdir(d, 0o755); err != nil {
+			t.Fatalf("Mkdir() error = %v", err)
+		}
+	}
+	tool := filepath.Join(bin, "fetch")
+	if err := os.WriteFile(tool, []byte("#!/bin/sh\n"), 0o755); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	t.Setenv("HOME", secret)
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("Getwd() error = %v", err)
+	}
+	relative, err := filepath.Rel(wd, secret)
+	if err != nil {
+		t.Fatalf("Rel() error = %v", err)
+	}
+
+	policyPath := filepath.Join(dir, "policy.yaml")
+	doc := fmt.Sprintf(`
+default: allow
+rules:
+  - id: no-fetch
+    action: deny
+    executable: [%q]
+  - id: no-secret
+    action: deny
+    workDir: [%q]
+  - id: no-root
+    action: deny
+    runAs: ["root"]
+`, tool, secret)
+	if err := os.WriteFile(policyPath, []byte(doc), 0o600); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+
+	client := &Client{
+		policy: policy.NewEngine(policyPath),
+		executor: agentexec.ShellExecutor{
+			Enabled:  true,
+			ExecPath: bin,
+			Users:    proc.UserPolicy{AllowedUsers: []string{"*"}},
+		},
+	}
+	cases := []struct {
+		name    string
+		payload protocol.CommandPushPayload
+		rule    string
+	}{
+		{"bare argv[0]", protocol.CommandPushPayload{Argv: []string{"fetch", "http://example.com"}}, "no-fetch"},
+		{"home shorthand", protocol.CommandPushPayload{Command: "ls", WorkDir: "~"}, "no-secret"},
+		{"relative path", protocol.CommandPushPayload{Command: "ls", WorkDir: relative}, "no-secret"},
+		{"dot-dot path", protocol.CommandPushPayload{Command: "ls", WorkDir: dir + "/other/../secret"}, "no-secret"},
+		{"numeric uid", protocol.CommandPushPayload{Command: "id", RunAs: &protocol.RunAs{User: "0"}}, "no-root"},
+	}
+	for _, tc := range cases {
+		resolved, err := client.executor.Resolve(commandSpec(tc.payload, nil))
+		if err != nil {
+			t.Fatalf("%s: Resolve() error = %v", tc.name, err)
+		}
+		decision, err := client.checkPolicy(tc.payload, resolved, "")
+		if err != nil {
+			t.Fatalf("%s: checkPolicy() error = %v", tc.name, err)
+		}
+		if decision.Action != policy.ActionDeny || decision.RuleID != tc.rule {
+			t.Fatalf("%s: decision = %+v, want deny by %s", tc.name, decision, tc.rule)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package ws
+
+import (
+	"errors"
+	"io"
+	"log"
+	"testing"
+	"time"
+
+	agentconfig "devops-agent/internal/config"
+	agentcrypto "devops-agent/internal/crypto"
+	"devops-agent/internal/protocol"
+)
+
+func TestUnacknowledgedRotationKeepsPreviouslyCommittedKey(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := agentcrypto.EnsureKeyPair(dir); err != nil {
+		t.Fatalf("EnsureKeyPair() error = %v", err)
+	}
+	cfg := &agentconfig.Config{
+		Keys: agentconfig.KeysConfig{Dir: dir},
+		Auth: agentconfig.AuthConfig{TokenKeyFile: "unused"},
+	}
+	client := &Client{cfg: cfg, keys: new(agentcrypto.SharedKeyStore), logger: log.New(io.Discard, "", 0)}
+
+	a, err := agentcrypto.StageRotation(dir)
+	if err != nil {
+		t.Fatalf("StageRotation(A) error = %v", err)
+	}
+	if err := client.completeRotation(&protocol.HelloRotation{Status: "accepted"}, a); err != nil {
+		t.Fatalf("completeRotation(A) error = %v", err)
+	}
+
+	b, err := agentcrypto.StageRotation(dir)
+	if err != nil {
+		t.Fatalf("StageRotation(B) error = %v", err)
+	}
+	if err := client.completeRotation(nil, b); !errors.Is(err, ErrRotationRejected) {
+		t.Fatalf("completeRotation(B) error = %v, want %v", err, ErrRotationRejected)
+	}
+	// 与 serveWithReconnect 收到 ErrRotationRejected 后的处理一致。
+	if restored, err := agentcrypto.RejectRotation(dir, time.Hour); err != nil || restored {
+		t.Fatalf("RejectRotation() = %v, %v; want the staged key discarded only", restored, err)
+	}
+
+	current, err := agentcrypto.EnsureKeyPair(dir)
+	if err != nil {
+		t.Fatalf("EnsureKeyPair() error = %v", err)
+	}
+	if !current.Public.Equal(a.Public) {
+		t.Fatalf("current key is not rotation A's key after B was rejected")
+	}
+	if _, pending, _ := agentcrypto.PendingRotation(dir); pending {
+		t.Fatalf("rotation B is still pending after rejection")
+	}
+}
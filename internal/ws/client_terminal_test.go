@@ -180,11 +180,14 @@ func TestEmitStdoutChunkUsesSendEvent(t *testing.T) {
 }
 
 func TestNewClientOpenAndWriteUseInjectedRealFactory(t *testing.T) {
-	client := NewClient(&agentconfig.Config{
+	client, err := NewClient(&agentconfig.Config{
 		Shell: agentconfig.ShellConfig{
 			WorkDir: t.TempDir(),
 		},
-	}, agentcrypto.KeyPair{}, log.New(io.Discard, "", 0), nil)
+	}, new(agentcrypto.SharedKeyStore), log.New(io.Discard, "", 0), nil)
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
 
 	openRaw, err := json.Marshal(protocol.TerminalSessionOpenPayload{
 		RequestID: "req-1",
@@ -0,0 +1,146 @@
+package ws
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/json"
+	"io"
+	"log"
+	"testing"
+	"time"
+
+	agentconfig "devops-agent/internal/config"
+	"devops-agent/internal/protocol"
+)
+
+func TestHandleTokenRotateAppliesAndPersistsToken(t *testing.T) {
+	var persisted []string
+	client := newTokenTestClient(&agentconfig.Config{
+		Auth: agentconfig.AuthConfig{DeviceToken: "old-token", DeviceTokenExpiresAt: 1},
+	}, func(token string) { persisted = append(persisted, token) })
+
+	client.handleTokenRotate(protocol.TokenRotatePayload{DeviceToken: "new-token", ExpiresAt: 1737264000000})
+
+	if client.cfg.Auth.DeviceToken != "new-token" || client.cfg.Auth.DeviceTokenExpiresAt != 1737264000000 {
+		t.Fatalf("auth = %#v, want rotated token and expiry", client.cfg.Auth)
+	}
+	if len(persisted) != 1 || persisted[0] != "new-token" {
+		t.Fatalf("persisted = %v, want [new-token]", persisted)
+	}
+	select {
+	case <-client.tokenUpdated:
+	default:
+		t.Fatalf("tokenUpdated not signaled")
+	}
+}
+
+func TestAdmitEventRejectsUnsignedTokenRotate(t *testing.T) {
+	pub, priv := mustGenerateKey(t)
+	client, sent := newEventRecordingClient()
+	client.cfg = &agentconfig.Config{}
+	client.gatewayKey = pub
+
+	payload := json.RawMessage(`{"deviceToken":"attacker-token","expiresAt":1737264000000}`)
+	if client.admitEvent(context.Background(), protocol.EventAuthTokenRotate, payload, "") {
+		t.Fatalf("admitEvent(unsigned rotate) = true, want rejection")
+	}
+	if len(*sent) != 1 || (*sent)[0].event != protocol.EventFrameRejected {
+		t.Fatalf("sent = %#v, want one frame.rejected", *sent)
+	}
+	if !client.admitEvent(context.Background(), protocol.EventAuthTokenRotate, payload, signFrame(priv, protocol.EventAuthTokenRotate, payload)) {
+		t.Fatalf("admitEvent(signed rotate) = false, want accepted")
+	}
+}
+
+// refreshResponse 构造 auth.token.refresh 的响应帧；priv 为 nil 时不带签名。
+func refreshResponse(priv ed25519.PrivateKey, id, payload string) []byte {
+	frame := `{"type":"res","id":"` + id + `","ok":true,"payload":` + payload
+	if priv != nil {
+		frame += `,"sig":"` + signFrame(priv, protocol.MethodAuthTokenRefresh, []byte(payload)) + `"`
+	}
+	return []byte(frame + "}")
+}
+
+func TestHandleResponseAppliesOnlyPendingRefresh(t *testing.T) {
+	pub, priv := mustGenerateKey(t)
+	client := newTokenTestClient(&agentconfig.Config{
+		Auth: agentconfig.AuthConfig{DeviceToken: "old-token"},
+	}, nil)
+	client.gatewayKey = pub
+	client.pendingRefreshID = "req-1"
+
+	client.handleResponse(refreshResponse(priv, "other", `{"deviceToken":"spoofed"}`))
+	if client.cfg.Auth.DeviceToken != "old-token" {
+		t.Fatalf("DeviceToken = %q after unrelated response, want old-token", client.cfg.Auth.DeviceToken)
+	}
+
+	client.handleResponse(refreshResponse(priv, "req-1", `{"deviceToken":"fresh","expiresAt":42}`))
+	if client.cfg.Auth.DeviceToken != "fresh" || client.cfg.Auth.DeviceTokenExpiresAt != 42 {
+		t.Fatalf("auth = %#v, want fresh token", client.cfg.Auth)
+	}
+	if client.pendingRefreshID != "" {
+		t.Fatalf("pendingRefreshID = %q, want cleared", client.pendingRefreshID)
+	}
+}
+
+func TestHandleResponseRejectsUnsignedRefresh(t *testing.T) {
+	pub, priv := mustGenerateKey(t)
+	_, other := mustGenerateKey(t)
+	for name, frame := range map[string][]byte{
+		"unsigned":  refreshResponse(nil, "req-1", `{"deviceToken":"attacker-token","expiresAt":42}`),
+		"wrong key": refreshResponse(other, "req-1", `{"deviceToken":"attacker-token","expiresAt":42}`),
+		"tampered":  bytes.Replace(refreshResponse(priv, "req-1", `{"deviceToken":"fresh","expiresAt":42}`), []byte("fresh"), []byte("attacker-token"), 1),
+	} {
+		t.Run(name, func(t *testing.T) {
+			client := newTokenTestClient(&agentconfig.Config{
+				Auth: agentconfig.AuthConfig{DeviceToken: "old-token"},
+			}, nil)
+			client.gatewayKey = pub
+			client.pendingRefreshID = "req-1"
+
+			client.handleResponse(frame)
+			if client.cfg.Auth.DeviceToken != "old-token" {
+				t.Fatalf("DeviceToken = %q, want old-token kept", client.cfg.Auth.DeviceToken)
+			}
+		})
+	}
+}
+
+func TestRefreshDelaySchedulesBeforeExpiryWithJitter(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	expiresAt := now.Add(3 * time.Hour)
+	client := newTokenTestClient(&agentconfig.Config{
+		Auth: agentconfig.AuthConfig{
+			DeviceToken:          "token",
+			DeviceTokenExpiresAt: expiresAt.UnixMilli(),
+			RefreshBeforeSeconds: 3600,
+		},
+	}, nil)
+
+	delay, ok := client.refreshDelay(now)
+	if !ok {
+		t.Fatalf("refreshDelay() ok = false, want true")
+	}
+	if delay > 2*time.Hour || delay < 90*time.Minute {
+		t.Fatalf("delay = %s, want within [1h30m, 2h]", delay)
+	}
+
+	if delay, _ := client.refreshDelay(expiresAt); delay != 0 {
+		t.Fatalf("delay after expiry = %s, want 0", delay)
+	}
+
+	client.cfg.Auth.DeviceTokenExpiresAt = 0
+	if _, ok := client.refreshDelay(now); ok {
+		t.Fatalf("refreshDelay() ok = true without known expiry")
+	}
+}
+
+func newTokenTestClient(cfg *agentconfig.Config, onDeviceToken func(string)) *Client {
+	return &Client{
+		cfg:           cfg,
+		logger:        log.New(io.Discard, "", 0),
+		onDeviceToken: onDeviceToken,
+		tokenUpdated:  make(chan struct{}, 1),
+	}
+}
@@ -1,7 +1,6 @@
 package ws
 
 import (
-	"crypto/ed25519"
 	"encoding/base64"
 	"fmt"
 	"time"
@@ -18,10 +17,12 @@ import (
 //
 //	deviceId|nonce|signedAt|role|token
 //
+// 其中 token 为 auth.token；注册时 auth.token 为空，token 位置改为 auth.enrollmentToken，使一次性令牌同样受设备签名保护。
+//
 // 使用 UTF-8 字节序列通过 Ed25519 进行签名，结果以 Base64 编码写入 device.signature。
 //
 // 注意：该函数仅负责构造 JSON 结构，实际发送由 WebSocket 客户端实现。
-func BuildConnectRequest(kp agentcrypto.KeyPair, authToken, deviceID, nonce string, minProtocol, maxProtocol int) (protocol.RequestFrame, error) {
+func BuildConnectRequest(signer agentcrypto.KeyStore, auth protocol.AuthInfo, deviceID, nonce string, minProtocol, maxProtocol int) (protocol.RequestFrame, error) {
 	client := protocol.ClientInfo{
 		ID:       "go-agent",
 		Version:  "0.1.0-mvp",
@@ -33,7 +34,7 @@ func BuildConnectRequest(kp agentcrypto.KeyPair, authToken, deviceID, nonce stri
 
 	devicePayload := protocol.DeviceInfo{
 		ID:        deviceID,
-		PublicKey: base64.StdEncoding.EncodeToString(kp.Public),
+		PublicKey: base64.StdEncoding.EncodeToString(signer.PublicKey()),
 		Nonce:     nonce,
 		SignedAt:  signedAt,
 		// Signature 在后续填充
@@ -46,16 +47,21 @@ func BuildConnectRequest(kp agentcrypto.KeyPair, authToken, deviceID, nonce stri
 		Role:        "node",
 		Scopes:      []string{}, // TODO: MVP 暂空，后续按权限模型填充
 		Device:      devicePayload,
-		Auth: protocol.AuthInfo{
-			Token: authToken,
-		},
+		Auth:        auth,
 	}
 
 	// 构造签名载荷：
 	// deviceId|nonce|signedAt|role|token
-	payload := fmt.Sprintf("%s|%s|%d|%s|%s", devicePayload.ID, devicePayload.Nonce, devicePayload.SignedAt, params.Role, params.Auth.Token)
+	token := params.Auth.Token
+	if token == "" {
+		token = params.Auth.EnrollmentToken
+	}
+	payload := fmt.Sprintf("%s|%s|%d|%s|%s", devicePayload.ID, devicePayload.Nonce, devicePayload.SignedAt, params.Role, token)
 
-	sig := ed25519.Sign(kp.Private, []byte(payload))
+	sig, err := signer.Sign([]byte(payload))
+	if err != nil {
+		return protocol.RequestFrame{}, fmt.Errorf("sign connect request: %w", err)
+	}
 	params.Device.Signature = base64.StdEncoding.EncodeToString(sig)
 
 	return protocol.RequestFrame{
@@ -4,9 +4,12 @@ import (
 	"crypto/ed25519"
 	"crypto/rand"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"testing"
 
 	agentcrypto "devops-agent/internal/crypto"
+	"devops-agent/internal/protocol"
 )
 
 func TestBuildConnectRequestMarshalsScopesAsArray(t *testing.T) {
@@ -17,7 +20,7 @@ func TestBuildConnectRequestMarshalsScopesAsArray(t *testing.T) {
 
 	req, err := BuildConnectRequest(
 		agentcrypto.KeyPair{Public: publicKey, Private: privateKey},
-		"auth-token",
+		protocol.AuthInfo{Token: "auth-token"},
 		"device-id",
 		"nonce",
 		3,
@@ -48,3 +51,42 @@ func TestBuildConnectRequestMarshalsScopesAsArray(t *testing.T) {
 		t.Fatalf("scopes length = %d, want 0", len(decoded.Params.Scopes))
 	}
 }
+
+func TestBuildConnectRequestSignsEnrollmentToken(t *testing.T) {
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey() error = %v", err)
+	}
+
+	req, err := BuildConnectRequest(
+		agentcrypto.KeyPair{Public: publicKey, Private: privateKey},
+		protocol.AuthInfo{EnrollmentToken: "enroll-once"},
+		"device-id",
+		"nonce",
+		3,
+		3,
+	)
+	if err != nil {
+		t.Fatalf("BuildConnectRequest() error = %v", err)
+	}
+
+	params := req.Params.(protocol.ConnectParams)
+	msg := fmt.Sprintf("device-id|nonce|%d|node|enroll-once", params.Device.SignedAt)
+	if err := agentcrypto.Verify(publicKey, []byte(msg), params.Device.Signature); err != nil {
+		t.Fatalf("device signature does not cover enrollment token: %v", err)
+	}
+}
+
+func TestPendingApprovalMatchesExactErrorCode(t *testing.T) {
+	if !errors.Is(&PendingApprovalError{Code: protocol.ErrorCodeEnrollmentPending}, ErrPendingApproval) {
+		t.Fatalf("PendingApprovalError does not match ErrPendingApproval")
+	}
+	if !isPendingErrorCode(protocol.ErrorCodeEnrollmentPending) {
+		t.Fatalf("isPendingErrorCode(%q) = false, want true", protocol.ErrorCodeEnrollmentPending)
+	}
+	for _, code := range []string{"enrollment_pending", "REVOCATION_PENDING", "PENDING", ""} {
+		if isPendingErrorCode(code) {
+			t.Fatalf("isPendingErrorCode(%q) = true, want false", code)
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package ws
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	agentexec "devops-agent/internal/exec"
+	"devops-agent/internal/protocol"
+)
+
+// command.stdin.* 被拒绝时 frame.rejected 中的错误码；STDIN_INVALID 也用于内联 stdin 无法解码的命令。
+const (
+	errorCodeStdinInvalid     = "STDIN_INVALID"
+	errorCodeStdinUnknownTask = "STDIN_UNKNOWN_TASK"
+	errorCodeStdinBufferFull  = "STDIN_BUFFER_FULL"
+	errorCodeStdinClosed      = "STDIN_CLOSED"
+)
+
+// commandStdin 为一次 command.push 打开的标准输入：reader 传给 Executor，stream 非空时接收后续写入事件。
+type commandStdin struct {
+	reader io.Reader
+	stream *agentexec.StdinStream
+	err    error
+}
+
+// openStdin 按推送构造命令的 stdin；StdinStream=true 时登记流，供 command.stdin.* 事件按 task_uuid 查找。
+func (c *Client) openStdin(payload protocol.CommandPushPayload) commandStdin {
+	if payload.Stdin == "" && !payload.StdinStream {
+		return commandStdin{}
+	}
+	inline, err := base64.StdEncoding.DecodeString(payload.Stdin)
+	if err != nil {
+		return commandStdin{err: fmt.Errorf("decode stdin: %w", err)}
+	}
+	if !payload.StdinStream {
+		return commandStdin{reader: bytes.NewReader(inline)}
+	}
+
+	limit := agentexec.DefaultStdinBuffer
+	if c.cfg != nil && c.cfg.Shell.StdinBufferBytes > 0 {
+		limit = c.cfg.Shell.StdinBufferBytes
+	}
+	if len(inline) > limit {
+		return commandStdin{err: fmt.Errorf("inline stdin exceeds buffer limit %d", limit)}
+	}
+
+	c.stdinMu.Lock()
+	defer c.stdinMu.Unlock()
+	if _, exists := c.stdins[payload.TaskUUID]; exists {
+		return commandStdin{err: fmt.Errorf("stdin stream already open for task %s", payload.TaskUUID)}
+	}
+	if c.stdins == nil {
+		c.stdins = make(map[string]*agentexec.StdinStream)
+	}
+	stream := agentexec.NewStdinStream(inline, limit)
+	c.stdins[payload.TaskUUID] = stream
+	return commandStdin{reader: stream, stream: stream}
+}
+
+// releaseStdin 在命令处理结束后注销并关闭其 stdin 流。
+func (c *Client) releaseStdin(taskUUID string, stream *agentexec.StdinStream) {
+	if stream == nil {
+		return
+	}
+	c.stdinMu.Lock()
+	if c.stdins[taskUUID] == stream {
+		delete(c.stdins, taskUUID)
+	}
+	c.stdinMu.Unlock()
+	_ = stream.Close()
+}
+
+func (c *Client) stdinStream(taskUUID string) *agentexec.StdinStream {
+	c.stdinMu.Lock()
+	defer c.stdinMu.Unlock()
+	return c.stdins[taskUUID]
+}
+
+// handleStdinEvent 处理 command.stdin.write / command.stdin.close，失败时回发 frame.rejected。
+func (c *Client) handleStdinEvent(ctx context.Context, event string, raw json.RawMessage) {
+	var payload protocol.CommandStdinWritePayload
+	if err := json.Unmarshal(raw, &payload); err != nil {
+		c.logger.Printf("[ws] invalid %s payload: %v", event, err)
+		return
+	}
+
+	err := c.applyStdinEvent(event, payload)
+	if err == nil {
+		return
+	}
+	c.logger.Printf("[ws] reject %s: task=%s seq=%d err=%v", event, payload.TaskUUID, payload.Seq, err)
+	c.sendFrameRejected(ctx, protocol.FrameRejectedPayload{
+		Event:    event,
+		TaskUUID: payload.TaskUUID,
+		Seq:      payload.Seq,
+		Code:     stdinErrorCode(err),
+		Message:  err.Error(),
+	})
+}
+
+var errStdinUnknownTask = errors.New("no running command with streaming stdin for task")
+
+func (c *Client) applyStdinEvent(event string, payload protocol.CommandStdinWritePayload) error {
+	stream := c.stdinStream(payload.TaskUUID)
+	if stream == nil {
+		return errStdinUnknownTask
+	}
+	if event == protocol.EventCommandStdinClose {
+		return stream.CloseWrite(payload.Seq)
+	}
+	data, err := base64.StdEncoding.DecodeString(payload.Data)
+	if err != nil {
+		return fmt.Errorf("decode stdin data: %w", err)
+	}
+	return stream.Write(payload.Seq, data)
+}
+
+func stdinErrorCode(err error) string {
+	switch {
+	case errors.Is(err, errStdinUnknownTask):
+		return errorCodeStdinUnknownTask
+	case errors.Is(err, agentexec.ErrStdinBufferFull):
+		return errorCodeStdinBufferFull
+	case errors.Is(err, agentexec.ErrStdinClosed):
+		return errorCodeStdinClosed
+	default:
+		return errorCodeStdinInvalid
+	}
+}
@@ -0,0 +1,71 @@
+package ws
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"testing"
+
+	"devops-agent/internal/protocol"
+)
+
+func TestStdinEventsReachRegisteredCommand(t *testing.T) {
+	client, sent := newEventRecordingClient()
+	push := protocol.CommandPushPayload{TaskUUID: "t-1", Stdin: base64.StdEncoding.EncodeToString([]byte("a")), StdinStream: true}
+
+	stdin := client.openStdin(push)
+	if stdin.err != nil || stdin.stream == nil {
+		t.Fatalf("openStdin() = %+v, want stream", stdin)
+	}
+	if dup := client.openStdin(push); dup.err == nil {
+		t.Fatalf("openStdin(duplicate task) error = nil, want error")
+	}
+
+	writeEvent(t, client, protocol.EventCommandStdinWrite, protocol.CommandStdinWritePayload{TaskUUID: "t-1", Seq: 1, Data: base64.StdEncoding.EncodeToString([]byte("b"))})
+	writeEvent(t, client, protocol.EventCommandStdinClose, protocol.CommandStdinClosePayload{TaskUUID: "t-1", Seq: 2})
+	if len(*sent) != 0 {
+		t.Fatalf("sent = %+v, want no rejection", *sent)
+	}
+	data, err := io.ReadAll(stdin.reader)
+	if err != nil || string(data) != "ab" {
+		t.Fatalf("stdin = %q, %v; want %q", data, err, "ab")
+	}
+
+	client.releaseStdin("t-1", stdin.stream)
+	writeEvent(t, client, protocol.EventCommandStdinWrite, protocol.CommandStdinWritePayload{TaskUUID: "t-1", Seq: 3, Data: ""})
+	if len(*sent) != 1 {
+		t.Fatalf("sent = %+v, want one frame.rejected", *sent)
+	}
+	rejected, ok := (*sent)[0].payload.(protocol.FrameRejectedPayload)
+	if (*sent)[0].event != protocol.EventFrameRejected || !ok || rejected.Code != errorCodeStdinUnknownTask || rejected.Seq != 3 {
+		t.Fatalf("rejection = %+v, want %s for seq 3", (*sent)[0], errorCodeStdinUnknownTask)
+	}
+}
+
+func TestOpenStdinInlineOnly(t *testing.T) {
+	client, _ := newEventRecordingClient()
+
+	stdin := client.openStdin(protocol.CommandPushPayload{TaskUUID: "t-1", Stdin: base64.StdEncoding.EncodeToString([]byte("inline"))})
+	if stdin.stream != nil || stdin.err != nil {
+		t.Fatalf("openStdin(inline) = %+v, want plain reader", stdin)
+	}
+	if data, _ := io.ReadAll(stdin.reader); string(data) != "inline" {
+		t.Fatalf("stdin = %q, want inline", data)
+	}
+	if bad := client.openStdin(protocol.CommandPushPayload{TaskUUID: "t-2", Stdin: "not base64!"}); bad.err == nil {
+		t.Fatalf("openStdin(invalid base64) error = nil, want error")
+	}
+	if none := client.openStdin(protocol.CommandPushPayload{TaskUUID: "t-3"}); none.reader != nil {
+		t.Fatalf("openStdin(no stdin) reader = %v, want nil", none.reader)
+	}
+}
+
+func writeEvent(t *testing.T, client *Client, event string, payload any) {
+	t.Helper()
+	raw, err := json.Marshal(payload)
+	if err != nil {
+		t.Fatalf("marshal %s: %v", event, err)
+	}
+	client.handleStdinEvent(context.Background(), event, raw)
+}